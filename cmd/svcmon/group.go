@@ -0,0 +1,76 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"monitor/win"
+)
+
+// Group runs the start or stop command, which starts or stops services in
+// dependency order:
+//
+//	svcmon start|stop [-group expr] [-no-rollback] [name...]
+//
+// The services are those named and those matching the filter expression
+// expr, see win.ParseFilter.
+func Group(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	group := fs.String("group", "", "also "+cmd+" the services matching filter `expr`")
+	noRollback := fs.Bool("no-rollback", false, "leave the services as they are if one fails to "+cmd)
+	poll := fs.Duration("poll", time.Second, "shortest interval between status queries")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if *group == "" && len(names) == 0 {
+		fs.Usage()
+		return errors.New("no services to " + cmd)
+	}
+
+	b, err := win.ConnectSCM()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if *group != "" {
+		filter, err := win.ParseFilter(*group)
+		if err != nil {
+			return err
+		}
+		members, err := win.GroupMembers(b, filter)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("no services match %s", *group)
+		}
+		names = append(names, members...)
+	}
+
+	opts := &win.GroupOptions{
+		PollInterval: *poll,
+		NoRollback:   *noRollback,
+		Changed: func(name string, state win.ServiceState) {
+			fmt.Printf("%-32s %s\n", name, state)
+		},
+	}
+	if cmd == "start" {
+		err = win.StartGroup(context.Background(), b, names, opts)
+	} else {
+		err = win.StopGroup(context.Background(), b, names, opts)
+	}
+	var gerr *win.GroupError
+	if errors.As(err, &gerr) && len(gerr.RolledBack) != 0 {
+		fmt.Fprintln(os.Stderr, "Rolled back:", gerr.RolledBack)
+	}
+	return err
+}
@@ -1,8 +1,12 @@
+//go:build windows
+// +build windows
+
 package main
 
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
@@ -15,6 +19,7 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"monitor/errno"
+	"monitor/win"
 )
 
 const MaxUint32 = 1<<32 - 1
@@ -28,13 +33,11 @@ var (
 	procSleepEx                   = kernel32DLL.NewProc("SleepEx")
 	procOpenProcess               = kernel32DLL.NewProc("OpenProcess")
 	procLocalFree                 = kernel32DLL.NewProc("LocalFree")
+	procLocalSize                 = kernel32DLL.NewProc("LocalSize")
 	procNotifyServiceStatusChange = advapi32DLL.MustFindProc("NotifyServiceStatusChange")
-	procEnumServicesStatusExW     = advapi32DLL.MustFindProc("EnumServicesStatusExW")
 	procGetProcessMemoryInfo      = psapiDLL.MustFindProc("GetProcessMemoryInfo")
 )
 
-const SC_ENUM_PROCESS_INFO uint32 = 0
-
 type ServiceType uint32
 
 const (
@@ -211,126 +214,54 @@ type ENUM_SERVICE_STATUS_PROCESS struct {
 
 func (e ENUM_SERVICE_STATUS_PROCESS) String() string {
 	const format = "{ServiceName: %s, DisplayName: %s, ServiceStatusProcess: {%s}}"
-	return fmt.Sprintf(format, UTF16ToString(e.ServiceName),
-		UTF16ToString(e.DisplayName), e.ServiceStatusProcess)
+	return fmt.Sprintf(format, UTF16ToString(e.ServiceName, maxServiceNameLen+1),
+		UTF16ToString(e.DisplayName, maxServiceNameLen+1), e.ServiceStatusProcess)
 }
 
-func ListServices(m *mgr.Mgr, typ ServiceType) ([]ENUM_SERVICE_STATUS_PROCESS, error) {
-	// TODO: If the GC frees portions of the allocated bufffer
-	// the ENUM_SERVICE_STATUS_PROCESS *uint16 fields will be
-	// invalid, or worse...
-
-	var (
-		bytesNeeded      uint32
-		servicesReturned uint32
-		resumeHandle     uint32
-		groupName        uint32
-	)
-	_, _, e1 := syscall.Syscall12(
-		procEnumServicesStatusExW.Addr(),
-		uintptr(10),
-		uintptr(m.Handle),
-		uintptr(SC_ENUM_PROCESS_INFO),
-		uintptr(typ),
-		uintptr(SERVICE_STATE_ALL),
-		uintptr(0),
-		uintptr(0),
-		uintptr(unsafe.Pointer(&bytesNeeded)),
-		uintptr(unsafe.Pointer(&servicesReturned)),
-		uintptr(unsafe.Pointer(&resumeHandle)),
-		uintptr(unsafe.Pointer(&groupName)),
-		uintptr(0),
-		uintptr(0),
-	)
-	if e1 != 0 {
-		switch e := errno.Errno(e1); e {
-		case errno.ERROR_MORE_DATA:
-			// Ok
-		case errno.ERROR_ACCESS_DENIED, errno.ERROR_INVALID_PARAMETER,
-			errno.ERROR_INVALID_HANDLE, errno.ERROR_INVALID_LEVEL:
-			// Programming error
-			return nil, e
-		case errno.ERROR_SHUTDOWN_IN_PROGRESS:
-			// Trigger stop
-			return nil, e
-		default:
-			return nil, syscall.EINVAL
-		}
+// ListServices returns the services of type typ, enumerated a page at a
+// time with win.EnumerateServices.
+func ListServices(b win.SCMBackend, typ ServiceType) ([]win.EnumServiceStatusProcess, error) {
+	var procs []win.EnumServiceStatusProcess
+	it := win.EnumerateServices(b, win.EnumOptions{Type: win.ServiceType(typ)})
+	for it.Next() {
+		procs = append(procs, it.Service())
 	}
-
-	var procs []ENUM_SERVICE_STATUS_PROCESS
-
-	// TODO: Allocate buffer once, and advance pointer.
-	// TODO: Allocate to nearest ENUM_SERVICE_STATUS_PROCESS
-	// size boundry and set cap to prevent GC'ing of string
-	// memory.
-	if bytesNeeded > 256*1024 {
-		bytesNeeded = 256 * 1024
-	}
-	for bytesNeeded > 0 {
-		buffer := make([]byte, bytesNeeded)
-		_, _, e1 := syscall.Syscall12(
-			procEnumServicesStatusExW.Addr(),
-			uintptr(10),
-			uintptr(m.Handle),
-			uintptr(SC_ENUM_PROCESS_INFO),
-			uintptr(typ),
-			uintptr(SERVICE_STATE_ALL),
-			uintptr(unsafe.Pointer(&buffer[0])),
-			uintptr(bytesNeeded),
-			uintptr(unsafe.Pointer(&bytesNeeded)),
-			uintptr(unsafe.Pointer(&servicesReturned)),
-			uintptr(unsafe.Pointer(&resumeHandle)),
-			uintptr(unsafe.Pointer(&groupName)),
-			uintptr(0),
-			uintptr(0),
-		)
-		if e1 != 0 {
-			switch e := errno.Errno(e1); e {
-			case errno.ERROR_MORE_DATA:
-				// Ok
-			case errno.ERROR_ACCESS_DENIED, errno.ERROR_INVALID_PARAMETER,
-				errno.ERROR_INVALID_HANDLE, errno.ERROR_INVALID_LEVEL:
-				// Programming error
-				return nil, e
-			case errno.ERROR_SHUTDOWN_IN_PROGRESS:
-				// Trigger stop
-				return nil, e
-			default:
-				return nil, syscall.EINVAL
-			}
-		}
-
-		var p []ENUM_SERVICE_STATUS_PROCESS
-		sp := (*sliceHeader)(unsafe.Pointer(&p))
-		sp.Data = unsafe.Pointer(&buffer[0])
-		sp.Len = int(servicesReturned)
-		sp.Cap = int(servicesReturned)
-
-		procs = append(procs, p...)
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
-
 	return procs, nil
 }
 
-type sliceHeader struct {
-	Data unsafe.Pointer
-	Len  int
-	Cap  int
-}
-
 func main() {
-	m, err := mgr.Connect()
+	bench := flag.Int("bench", 0, "compare the status strategies over `n` runs")
+	flag.Parse()
+
+	if args := flag.Args(); len(args) != 0 {
+		switch args[0] {
+		case "start", "stop":
+			Fatal(Group(args[0], args[1:]))
+		default:
+			Fatal("unknown command: " + args[0])
+		}
+		return
+	}
+
+	m, err := win.ConnectSCM()
 	if err != nil {
 		Fatal(err)
 	}
-	defer m.Disconnect()
+	defer m.Close()
 
 	// svcType := SERVICE_WIN32 | SERVICE_WIN32_OWN_PROCESS |
 	// 	SERVICE_WIN32_SHARE_PROCESS
 
 	svcType := SERVICE_ALL
 
+	if *bench > 0 {
+		Fatal(Benchmark(m, svcType, *bench))
+		return
+	}
+
 	t := time.Now()
 	_, err = ServiceStatuses(m, svcType)
 	if err != nil {
@@ -340,6 +271,36 @@ func main() {
 	fmt.Println(d, d/time.Duration(100))
 }
 
+// Benchmark compares reading the service statuses from the enumeration
+// with opening and querying every service, each strategy is run n times.
+func Benchmark(m win.SCMBackend, typ ServiceType, n int) error {
+	strategies := []struct {
+		name string
+		fn   func(win.SCMBackend, ServiceType) ([]svc.Status, error)
+	}{
+		{"enumeration", ServiceStatuses},
+		{"open+query", QueryServiceStatuses},
+	}
+	for _, s := range strategies {
+		var count int
+		t := time.Now()
+		for i := 0; i < n; i++ {
+			stats, err := s.fn(m, typ)
+			if err != nil {
+				return fmt.Errorf("%s: %s", s.name, err)
+			}
+			count = len(stats)
+		}
+		d := time.Since(t) / time.Duration(n)
+		fmt.Printf("%-12s %4d services %12s/op", s.name, count, d)
+		if count != 0 {
+			fmt.Printf(" %10s/service", d/time.Duration(count))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
 // func OpenService(m *mgr.Mgr, name *uint16) (*Service, error) {
 // 	h, err := windows.OpenService(m.Handle, name, windows.SERVICE_ALL_ACCESS)
 // 	if err != nil {
@@ -348,7 +309,38 @@ func main() {
 // 	return &mgr.Service{Name: name, Handle: h}, nil
 // }
 
-func ServiceStatuses(m *mgr.Mgr, typ ServiceType) ([]svc.Status, error) {
+// ServiceStatuses returns the status of every service of type typ, the
+// status is read from the enumeration and no service is opened.
+func ServiceStatuses(m win.SCMBackend, typ ServiceType) ([]svc.Status, error) {
+	procs, err := ListServices(m, typ)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]svc.Status, 0, len(procs))
+	for _, p := range procs {
+		if p.ServiceName == "" {
+			continue
+		}
+		stats = append(stats, status(p.ServiceStatusProcess))
+	}
+	return stats, nil
+}
+
+func status(st win.SERVICE_STATUS_PROCESS) svc.Status {
+	return svc.Status{
+		State:                   svc.State(st.CurrentState),
+		Accepts:                 svc.Accepted(st.ControlsAccepted),
+		CheckPoint:              st.CheckPoint,
+		WaitHint:                st.WaitHint,
+		ProcessId:               st.ProcessId,
+		Win32ExitCode:           st.Win32ExitCode,
+		ServiceSpecificExitCode: st.ServiceSpecificExitCode,
+	}
+}
+
+// QueryServiceStatuses is like ServiceStatuses, but opens and queries every
+// service.  Services that access is denied to are skipped.
+func QueryServiceStatuses(m win.SCMBackend, typ ServiceType) ([]svc.Status, error) {
 	procs, err := ListServices(m, typ)
 	if err != nil {
 		return nil, err
@@ -356,29 +348,26 @@ func ServiceStatuses(m *mgr.Mgr, typ ServiceType) ([]svc.Status, error) {
 	var first error
 	stats := make([]svc.Status, 0, len(procs))
 	for _, p := range procs {
-		name := UTF16ToString(p.ServiceName)
+		name := p.ServiceName
 		if name == "" {
 			continue
 		}
 		s, err := m.OpenService(name)
 		if err != nil {
-			fmt.Println("Error:", name, err)
-		}
-		if err != nil && err.(syscall.Errno) != 0x5 {
-			if first == nil {
+			if e, ok := err.(syscall.Errno); (!ok || e != 0x5) && first == nil {
 				first = fmt.Errorf("opening service (%s): %s", name, err)
-				continue
 			}
+			continue
 		}
-		if s != nil {
-			defer s.Close()
-			st, err := s.Query()
-			if err != nil && first == nil {
+		st, err := s.Query()
+		s.Close()
+		if err != nil {
+			if first == nil {
 				first = fmt.Errorf("querying service (%s): %s", name, err)
-				continue
 			}
-			stats = append(stats, st)
+			continue
 		}
+		stats = append(stats, status(st))
 	}
 	return stats, first
 }
@@ -473,8 +462,8 @@ func (s SERVICE_NOTIFY) String() string {
 		"NotificationTriggered: %s, ServiceNames: %s}"
 
 	return fmt.Sprintf(format, s.Version, s.NotifyCallback, s.Context,
-		s.NotificationStatus, s.ServiceStatus, s.NotificationTriggered,
-		UTF16ToString(s.ServiceNames))
+		errno.Errno(s.NotificationStatus), s.ServiceStatus, s.NotificationTriggered,
+		UTF16ToString(s.ServiceNames, localLen(s.ServiceNames)))
 }
 
 func (s SERVICE_NOTIFY) MarshalJSON() ([]byte, error) {
@@ -494,7 +483,7 @@ func (s SERVICE_NOTIFY) MarshalJSON() ([]byte, error) {
 		NotificationStatus:    errno.Errno(s.NotificationStatus),
 		ServiceStatus:         s.ServiceStatus,
 		NotificationTriggered: s.NotificationTriggered.String(),
-		ServiceNames:          UTF16ToStringPtr(s.ServiceNames),
+		ServiceNames:          UTF16ToStringPtr(s.ServiceNames, localLen(s.ServiceNames)),
 	}
 	return json.Marshal(n)
 }
@@ -543,11 +532,11 @@ func (s *ServiceMonitor) Monitor() {
 		case err := <-s.errs:
 			fmt.Println("Error:", err)
 		case n := <-s.ch:
-			switch n.NotificationStatus {
+			switch errno.Errno(n.NotificationStatus) {
 			case errno.ERROR_SUCCESS:
 				fmt.Println(n)
 			case errno.ERROR_SERVICE_MARKED_FOR_DELETE:
-				fmt.Println(n.NotificationStatus.String())
+				fmt.Println(errno.Errno(n.NotificationStatus).String())
 				s.Close()
 				return
 			}
@@ -685,19 +674,33 @@ func XXX() {
 	fmt.Println("Done")
 }
 
-func UTF16ToStringPtr(p *uint16) *string {
+// maxServiceNameLen is the maximum length, in UTF-16 code units, of the
+// name and display name of a service.
+const maxServiceNameLen = 256
+
+// localLen returns the number of UTF-16 code units in the LocalAlloc
+// allocation at p.
+func localLen(p *uint16) int {
+	if p == nil {
+		return 0
+	}
+	size, _, _ := procLocalSize.Call(uintptr(unsafe.Pointer(p)))
+	return int(size / 2)
+}
+
+// UTF16ToStringPtr is like UTF16ToString, but returns nil if p is nil.
+func UTF16ToStringPtr(p *uint16, max int) *string {
 	if p == nil {
 		return nil
 	}
-	s := UTF16ToString(p)
+	s := UTF16ToString(p, max)
 	return &s
 }
 
-func UTF16ToString(p *uint16) string {
-	if p == nil || *p == 0 {
-		return ""
-	}
-	return syscall.UTF16ToString((*[4096]uint16)(unsafe.Pointer(p))[:])
+// UTF16ToString returns the UTF-16 string at p, which ends at a NUL or
+// after max code units, see win.UTF16PtrToString.
+func UTF16ToString(p *uint16, max int) string {
+	return win.UTF16PtrToString(p, max)
 }
 
 var bufferPool sync.Pool
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package main
 
 import (
@@ -7,7 +10,7 @@ import (
 	"testing"
 	"unicode/utf16"
 
-	"golang.org/x/sys/windows/svc/mgr"
+	"monitor/win"
 )
 
 const longString = `Go is expressive, concise, clean, and efficient. Its
@@ -56,7 +59,7 @@ func utf16Ptr(s string) *uint16 {
 
 func TestUTF16ToString(t *testing.T) {
 	for _, x := range utf16ToStringTests {
-		s := UTF16ToString(x.Ptr)
+		s := UTF16ToString(x.Ptr, len(x.Exp)+1)
 		if s != x.Exp {
 			t.Errorf("UTF16ToString (%q): %q", x.Exp, s)
 		}
@@ -65,7 +68,7 @@ func TestUTF16ToString(t *testing.T) {
 	// Max length
 	n := (4096 * 2) / len(longString)
 	u := strings.Repeat(longString, n)
-	s := UTF16ToString(utf16Ptr(u))
+	s := UTF16ToString(utf16Ptr(u), 4096)
 	if len(s) != 4096 {
 		t.Fatalf("Max length changed expected (%d) got (%d)", 4096, len(s))
 	}
@@ -82,22 +85,22 @@ func TestUTF16ToString(t *testing.T) {
 func BenchmarkUTF16ToString_Short(b *testing.B) {
 	p := utf16Ptr("hello, world")
 	for i := 0; i < b.N; i++ {
-		UTF16ToString(p)
+		UTF16ToString(p, 13)
 	}
 }
 
 func BenchmarkUTF16ToString_Long(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		UTF16ToString(longStringPtr)
+		UTF16ToString(longStringPtr, len(longString)+1)
 	}
 }
 
 func BenchmarkListServices(b *testing.B) {
-	m, err := mgr.Connect()
+	m, err := win.ConnectSCM()
 	if err != nil {
 		b.Fatal(err)
 	}
-	defer m.Disconnect()
+	defer m.Close()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err := ListServices(m, SERVICE_ALL)
@@ -108,11 +111,11 @@ func BenchmarkListServices(b *testing.B) {
 }
 
 func TestServiceStatuses(t *testing.T) {
-	m, err := mgr.Connect()
+	m, err := win.ConnectSCM()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer m.Disconnect()
+	defer m.Close()
 	for i := 0; i < 100; i++ {
 		_, err := ServiceStatuses(m, SERVICE_ALL)
 		if err != nil {
@@ -124,11 +127,11 @@ func TestServiceStatuses(t *testing.T) {
 }
 
 func BenchmarkServiceStatuses(b *testing.B) {
-	m, err := mgr.Connect()
+	m, err := win.ConnectSCM()
 	if err != nil {
 		b.Fatal(err)
 	}
-	defer m.Disconnect()
+	defer m.Close()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		_, err := ServiceStatuses(m, SERVICE_ALL)
@@ -137,3 +140,39 @@ func BenchmarkServiceStatuses(b *testing.B) {
 		}
 	}
 }
+
+func TestQueryServiceStatuses(t *testing.T) {
+	m, err := win.ConnectSCM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	enum, err := ServiceStatuses(m, SERVICE_ALL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := QueryServiceStatuses(m, SERVICE_ALL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Services that access is denied to are only skipped by the query.
+	if len(query) > len(enum) {
+		t.Errorf("QueryServiceStatuses: %d services, ServiceStatuses: %d",
+			len(query), len(enum))
+	}
+}
+
+func BenchmarkQueryServiceStatuses(b *testing.B) {
+	m, err := win.ConnectSCM()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := QueryServiceStatuses(m, SERVICE_ALL)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
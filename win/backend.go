@@ -0,0 +1,84 @@
+package win
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned when the Service Control Manager is not
+// available on the current platform.
+var ErrNotSupported = errors.New("win: service control manager not supported on this platform")
+
+// SCMBackend is the Service Control Manager as seen by the Supervisor and
+// its listeners.  The Windows implementation is returned by ConnectSCM,
+// NewFakeBackend returns an in-memory implementation for tests.
+type SCMBackend interface {
+	// ListServices enumerates all services of type typ.
+	ListServices(typ ServiceType) ([]EnumServiceStatusProcess, error)
+
+	// OpenService opens the service named name.
+	OpenService(name string) (ServiceHandle, error)
+
+	// WaitNotify registers for the SCM notifications in mask (one or more
+	// of SERVICE_NOTIFY_CREATED and SERVICE_NOTIFY_DELETED) and waits up
+	// to timeout for one to be delivered.  A nil ServiceNotify and error
+	// are returned if the timeout elapses, a negative timeout waits forever.
+	// The registration may outlive the call, later calls must use the
+	// same mask.
+	WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error)
+
+	// Close closes the connection to the SCM.
+	Close() error
+}
+
+// A Reconnector is an SCMBackend that can replace a lost connection to the
+// Service Control Manager.  Service handles opened before Reconnect remain
+// invalid and must be reopened.
+type Reconnector interface {
+	Reconnect() error
+}
+
+// A ServiceStarter is a ServiceHandle that can start its service, args
+// are passed to the service's ServiceMain function.
+type ServiceStarter interface {
+	Start(args ...string) error
+}
+
+// ServiceHandle is an open handle to a service.
+type ServiceHandle interface {
+	Name() string
+
+	// Config returns the service's configuration.
+	Config() (Config, error)
+
+	// Query returns the current status of the service.
+	Query() (SERVICE_STATUS_PROCESS, error)
+
+	// Control sends control code c to the service.
+	Control(c ControlCode) error
+
+	// WaitNotify registers for the status notifications in mask and waits
+	// up to timeout for one to be delivered.  A nil ServiceNotify and error
+	// are returned if the timeout elapses, a negative timeout waits forever.
+	// The registration may outlive the call, later calls must use the
+	// same mask.  Close wakes a pending WaitNotify.
+	WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error)
+
+	Close() error
+}
+
+// Config is the configuration of a service, as returned by
+// QueryServiceConfig and QueryServiceConfig2.
+type Config struct {
+	ServiceType      ServiceType
+	StartType        StartType
+	ErrorControl     ErrorControl
+	BinaryPathName   string
+	LoadOrderGroup   string
+	TagId            uint32
+	Dependencies     []string
+	ServiceStartName string
+	DisplayName      string
+	Description      string
+	Triggers         []ServiceTrigger // Start and stop triggers, see TriggerStart
+}
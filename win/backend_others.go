@@ -0,0 +1,6 @@
+//go:build !windows
+// +build !windows
+
+package win
+
+func defaultBackend() (SCMBackend, error) { return nil, ErrNotSupported }
@@ -0,0 +1,297 @@
+package win
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"monitor/errno"
+)
+
+type windowsBackend struct {
+	mu     sync.RWMutex // Guards mgr during Reconnect
+	mgr    *mgr.Mgr
+	notify notifyWaiter
+}
+
+// ConnectSCM connects to the Service Control Manager of the local machine.
+func ConnectSCM() (SCMBackend, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return &windowsBackend{mgr: m}, nil
+}
+
+func defaultBackend() (SCMBackend, error) { return ConnectSCM() }
+
+// handle returns the Service Control Manager handle.
+func (b *windowsBackend) handle() windows.Handle {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.mgr.Handle
+}
+
+func (b *windowsBackend) OpenService(name string) (ServiceHandle, error) {
+	b.mu.RLock()
+	s, err := b.mgr.OpenService(name)
+	b.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &windowsService{svc: s}, nil
+}
+
+func (b *windowsBackend) WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error) {
+	return b.notify.wait(sharedNotifyEngine(), b.handle(), mask, timeout)
+}
+
+// Reconnect replaces the connection to the Service Control Manager, the
+// caller must not be waiting for notifications.
+func (b *windowsBackend) Reconnect() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	old := b.mgr
+	b.mgr = m
+	b.mu.Unlock()
+	old.Disconnect() // The old handle is usually invalid
+	b.notify.close(sharedNotifyEngine())
+	return nil
+}
+
+// Close closes the handle before unregistering its notifications, see
+// apcSource.disarm.
+func (b *windowsBackend) Close() error {
+	b.mu.RLock()
+	err := b.mgr.Disconnect()
+	b.mu.RUnlock()
+	b.notify.close(sharedNotifyEngine())
+	return err
+}
+
+func (b *windowsBackend) ListServices(typ ServiceType) ([]EnumServiceStatusProcess, error) {
+	var procs []EnumServiceStatusProcess
+	it := EnumerateServices(b, EnumOptions{Type: typ})
+	for it.Next() {
+		procs = append(procs, it.Service())
+	}
+	return procs, it.err
+}
+
+// Sizes of the buffer passed to EnumServicesStatusEx, a page is grown up to
+// the maximum if a single service does not fit.
+const (
+	enumPageSize    = 64 * 1024
+	maxEnumPageSize = 256 * 1024
+)
+
+func (b *windowsBackend) EnumServicesPage(opts EnumOptions, resume uint32) ([]EnumServiceStatusProcess, uint32, error) {
+	var groupName *uint16 // All groups
+	if opts.Group != "" || opts.NoGroup {
+		p, err := syscall.UTF16PtrFromString(opts.Group)
+		if err != nil {
+			return nil, 0, err
+		}
+		groupName = p
+	}
+
+	size := enumPageSize
+	for {
+		var (
+			bytesNeeded      uint32
+			servicesReturned uint32
+			resumeHandle     = resume
+		)
+		buffer := make([]byte, size)
+		r1, _, e1 := syscall.Syscall12(
+			procEnumServicesStatusExW.Addr(),
+			uintptr(10),
+			uintptr(b.handle()),                        // hSCManager,
+			uintptr(SC_ENUM_PROCESS_INFO),              // InfoLevel,
+			uintptr(opts.serviceType()),                // dwServiceType,
+			uintptr(opts.state()),                      // dwServiceState,
+			uintptr(unsafe.Pointer(&buffer[0])),        // lpServices,
+			uintptr(len(buffer)),                       // cbBufSize,
+			uintptr(unsafe.Pointer(&bytesNeeded)),      // pcbBytesNeeded,
+			uintptr(unsafe.Pointer(&servicesReturned)), // lpServicesReturned,
+			uintptr(unsafe.Pointer(&resumeHandle)),     // lpResumeHandle,
+			uintptr(unsafe.Pointer(groupName)),         // pszGroupName
+			uintptr(0),
+			uintptr(0),
+		)
+		// e1 is only meaningful if the call failed.
+		e := errno.Errno(e1)
+		if r1 != 0 {
+			e = errno.ERROR_SUCCESS
+		} else if e == errno.ERROR_SUCCESS {
+			return nil, 0, syscall.EINVAL
+		}
+		switch e {
+		case errno.ERROR_SUCCESS:
+			resumeHandle = 0 // Last page
+		case errno.ERROR_MORE_DATA:
+			if servicesReturned == 0 {
+				// The next service does not fit, grow the page.
+				if int(bytesNeeded) <= size || size >= maxEnumPageSize {
+					return nil, 0, e
+				}
+				size = int(bytesNeeded)
+				if size > maxEnumPageSize {
+					size = maxEnumPageSize
+				}
+				continue
+			}
+		default:
+			// ERROR_ACCESS_DENIED, ERROR_INVALID_PARAMETER, ERROR_INVALID_HANDLE
+			// and ERROR_INVALID_LEVEL are programming errors, RPC errors
+			// mean the connection was lost.
+			return nil, 0, e
+		}
+
+		// The strings are copied out of buffer, which is only read
+		// through offsets so it may be collected once decoded.
+		procs, err := DecodeEnumServiceStatusProcess(buffer,
+			uint64(uintptr(unsafe.Pointer(&buffer[0]))), int(servicesReturned),
+			int(unsafe.Sizeof(uintptr(0))))
+		if err != nil {
+			return nil, 0, err
+		}
+		return procs, resumeHandle, nil
+	}
+}
+
+type windowsService struct {
+	svc    *mgr.Service
+	notify notifyWaiter
+}
+
+func (s *windowsService) Name() string { return s.svc.Name }
+
+// Handle returns the underlying Windows service handle.
+func (s *windowsService) Handle() windows.Handle { return s.svc.Handle }
+
+func (s *windowsService) Config() (Config, error) {
+	c, err := s.svc.Config()
+	if err != nil {
+		return Config{}, err
+	}
+	// Only demand started services can be trigger started.  Triggers are
+	// optional, failing to read them does not fail the whole Config.
+	var triggers []ServiceTrigger
+	if StartType(c.StartType) == SERVICE_DEMAND_START {
+		triggers, _ = s.triggers()
+	}
+	return Config{
+		ServiceType:      ServiceType(c.ServiceType),
+		StartType:        StartType(c.StartType),
+		ErrorControl:     ErrorControl(c.ErrorControl),
+		BinaryPathName:   c.BinaryPathName,
+		LoadOrderGroup:   c.LoadOrderGroup,
+		TagId:            c.TagId,
+		Dependencies:     c.Dependencies,
+		ServiceStartName: c.ServiceStartName,
+		DisplayName:      c.DisplayName,
+		Description:      c.Description,
+		Triggers:         triggers,
+	}, nil
+}
+
+// triggers returns the triggers of the service, they are part of its
+// Config as the StartType of trigger started services is
+// SERVICE_DEMAND_START.
+func (s *windowsService) triggers() ([]ServiceTrigger, error) {
+	buffer, err := s.queryConfig2(SERVICE_CONFIG_TRIGGER_INFO, make([]byte, 256))
+	if err != nil {
+		return nil, err
+	}
+	var conf ServiceConfig2
+	err = DecodeServiceConfig2(buffer, uint64(uintptr(unsafe.Pointer(&buffer[0]))),
+		int(unsafe.Sizeof(uintptr(0))), SERVICE_CONFIG_TRIGGER_INFO, &conf)
+	return conf.Triggers, err
+}
+
+// Config2 returns the extended configuration of the service, querying each
+// information level in turn.
+func (s *windowsService) Config2() (ServiceConfig2, error) {
+	var conf ServiceConfig2
+	buffer := make([]byte, 1024)
+	for _, level := range serviceConfig2Levels {
+		var err error
+		buffer, err = s.queryConfig2(level, buffer)
+		if err != nil {
+			return conf, err
+		}
+		err = DecodeServiceConfig2(buffer, uint64(uintptr(unsafe.Pointer(&buffer[0]))),
+			int(unsafe.Sizeof(uintptr(0))), level, &conf)
+		if err != nil {
+			return conf, err
+		}
+	}
+	return conf, nil
+}
+
+// queryConfig2 calls QueryServiceConfig2 for level, buffer is grown if it
+// is too small and returned.
+func (s *windowsService) queryConfig2(level uint32, buffer []byte) ([]byte, error) {
+	for {
+		var bytesNeeded uint32
+		r1, _, e1 := syscall.Syscall6(
+			procQueryServiceConfig2W.Addr(),
+			uintptr(5),
+			uintptr(s.svc.Handle),                 // hService
+			uintptr(level),                        // dwInfoLevel
+			uintptr(unsafe.Pointer(&buffer[0])),   // lpBuffer
+			uintptr(len(buffer)),                  // cbBufSize
+			uintptr(unsafe.Pointer(&bytesNeeded)), // pcbBytesNeeded
+			uintptr(0),
+		)
+		if r1 != 0 {
+			return buffer, nil
+		}
+		e := errno.Errno(e1)
+		if e == errno.ERROR_INSUFFICIENT_BUFFER && int(bytesNeeded) > len(buffer) {
+			buffer = make([]byte, bytesNeeded)
+			continue
+		}
+		return buffer, e
+	}
+}
+
+func (s *windowsService) Query() (SERVICE_STATUS_PROCESS, error) {
+	var (
+		p           SERVICE_STATUS_PROCESS
+		bytesNeeded uint32
+	)
+	err := windows.QueryServiceStatusEx(s.svc.Handle, windows.SC_STATUS_PROCESS_INFO,
+		(*byte)(unsafe.Pointer(&p)), uint32(unsafe.Sizeof(p)), &bytesNeeded)
+	return p, err
+}
+
+func (s *windowsService) Start(args ...string) error {
+	return s.svc.Start(args...)
+}
+
+func (s *windowsService) Control(c ControlCode) error {
+	_, err := s.svc.Control(svc.Cmd(c))
+	return err
+}
+
+func (s *windowsService) WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error) {
+	return s.notify.wait(sharedNotifyEngine(), s.svc.Handle, mask, timeout)
+}
+
+// Close closes the handle before unregistering its notifications, see
+// apcSource.disarm.
+func (s *windowsService) Close() error {
+	err := s.svc.Close()
+	s.notify.close(sharedNotifyEngine())
+	return err
+}
@@ -7,13 +7,10 @@ import (
 	"strings"
 	"time"
 
-	"golang.org/x/sys/windows/svc/mgr"
-
 	"monitor/win"
 )
 
 var (
-	_ = mgr.Mgr{}
 	_ = time.ANSIC
 )
 
@@ -36,6 +33,7 @@ func main() {
 	if err != nil {
 		Fatal(err)
 	}
+	defer m.Close()
 	const typ = win.SERVICE_WIN32_OWN_PROCESS |
 		win.SERVICE_WIN32_SHARE_PROCESS |
 		win.SERVICE_WIN32
@@ -48,7 +46,7 @@ func main() {
 	// 	fmt.Println(p.ServiceName)
 	// }
 
-	fn := func(name string, _ *mgr.Config) bool {
+	fn := func(name string, _ *win.Config) bool {
 		return strings.Contains(strings.ToLower(name), "v")
 	}
 	m.AddFilters(fn)
@@ -62,7 +60,12 @@ func main() {
 	// 	fmt.Println(s)
 	// }
 	for _, s := range m.Services() {
-		fmt.Println(s)
+		health := "healthy"
+		if !s.Healthy() {
+			health = "unhealthy"
+		}
+		fmt.Printf("%-32s %-8s %-24s %s\n", s.Name, s.Config.StartMode(),
+			s.Status.CurrentState, health)
 	}
 }
 
@@ -0,0 +1,210 @@
+package win
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Information levels of QueryServiceConfig2.
+const (
+	SERVICE_CONFIG_DESCRIPTION              uint32 = 1
+	SERVICE_CONFIG_FAILURE_ACTIONS          uint32 = 2
+	SERVICE_CONFIG_DELAYED_AUTO_START_INFO  uint32 = 3
+	SERVICE_CONFIG_FAILURE_ACTIONS_FLAG     uint32 = 4
+	SERVICE_CONFIG_SERVICE_SID_INFO         uint32 = 5
+	SERVICE_CONFIG_REQUIRED_PRIVILEGES_INFO uint32 = 6
+	SERVICE_CONFIG_PRESHUTDOWN_INFO         uint32 = 7
+	SERVICE_CONFIG_TRIGGER_INFO             uint32 = 8
+)
+
+// serviceConfig2Levels are the information levels queried for a
+// ServiceConfig2.
+var serviceConfig2Levels = []uint32{
+	SERVICE_CONFIG_DESCRIPTION,
+	SERVICE_CONFIG_FAILURE_ACTIONS,
+	SERVICE_CONFIG_DELAYED_AUTO_START_INFO,
+	SERVICE_CONFIG_FAILURE_ACTIONS_FLAG,
+	SERVICE_CONFIG_SERVICE_SID_INFO,
+	SERVICE_CONFIG_REQUIRED_PRIVILEGES_INFO,
+	SERVICE_CONFIG_PRESHUTDOWN_INFO,
+	SERVICE_CONFIG_TRIGGER_INFO,
+}
+
+// INFINITE is the ResetPeriod of failure actions whose failure count is
+// never reset.
+const INFINITE uint32 = 0xffffffff
+
+// https://msdn.microsoft.com/en-us/library/windows/desktop/ms685126(v=vs.85).aspx
+type ActionType uint32
+
+const (
+	SC_ACTION_NONE        ActionType = iota // 0
+	SC_ACTION_RESTART                       // 1
+	SC_ACTION_REBOOT                        // 2
+	SC_ACTION_RUN_COMMAND                   // 3
+)
+
+var actionTypeNames = []enumName{
+	{uint32(SC_ACTION_NONE), "SC_ACTION_NONE"},
+	{uint32(SC_ACTION_RESTART), "SC_ACTION_RESTART"},
+	{uint32(SC_ACTION_REBOOT), "SC_ACTION_REBOOT"},
+	{uint32(SC_ACTION_RUN_COMMAND), "SC_ACTION_RUN_COMMAND"},
+}
+
+func (t ActionType) String() string { return formatEnum(actionTypeNames, uint32(t)) }
+
+// https://msdn.microsoft.com/en-us/library/windows/desktop/ms685989(v=vs.85).aspx
+type SidType uint32
+
+const (
+	SERVICE_SID_TYPE_NONE         SidType = 0
+	SERVICE_SID_TYPE_UNRESTRICTED SidType = 1
+	SERVICE_SID_TYPE_RESTRICTED   SidType = 3
+)
+
+var sidTypeNames = []enumName{
+	{uint32(SERVICE_SID_TYPE_NONE), "SERVICE_SID_TYPE_NONE"},
+	{uint32(SERVICE_SID_TYPE_UNRESTRICTED), "SERVICE_SID_TYPE_UNRESTRICTED"},
+	{uint32(SERVICE_SID_TYPE_RESTRICTED), "SERVICE_SID_TYPE_RESTRICTED"},
+}
+
+func (t SidType) String() string { return formatEnum(sidTypeNames, uint32(t)) }
+
+// A FailureAction is taken by the Service Control Manager when a service
+// fails, the Delay is in milliseconds.
+type FailureAction struct {
+	Type  ActionType
+	Delay uint32
+}
+
+// FailureActions are the actions taken on consecutive failures of a
+// service, the last action is repeated for later failures.  The failure
+// count is reset after ResetPeriod seconds without a failure, or never if
+// it is INFINITE.
+type FailureActions struct {
+	ResetPeriod uint32
+	RebootMsg   string
+	Command     string
+	Actions     []FailureAction
+}
+
+// ServiceConfig2 is the extended configuration of a service, as returned
+// by QueryServiceConfig2.
+type ServiceConfig2 struct {
+	Description    string
+	FailureActions FailureActions
+
+	// FailureActionsOnNonCrashFailures takes the FailureActions when the
+	// service stops with an error, not only when its process exits.
+	FailureActionsOnNonCrashFailures bool
+
+	DelayedAutoStart   bool
+	SidType            SidType
+	PreshutdownTimeout uint32 // Milliseconds
+	RequiredPrivileges []string
+	Triggers           []ServiceTrigger
+}
+
+// A ServiceConfig2Querier is a ServiceHandle that can query the extended
+// configuration of its service.
+type ServiceConfig2Querier interface {
+	Config2() (ServiceConfig2, error)
+}
+
+// QueryServiceConfig2 returns the extended configuration of the service
+// opened by h.  ErrNotSupported is returned if h is not a
+// ServiceConfig2Querier.
+func QueryServiceConfig2(h ServiceHandle) (ServiceConfig2, error) {
+	q, ok := h.(ServiceConfig2Querier)
+	if !ok {
+		return ServiceConfig2{}, ErrNotSupported
+	}
+	return q.Config2()
+}
+
+// DecodeServiceConfig2 decodes the structure returned by QueryServiceConfig2
+// for information level into the corresponding fields of conf.  The buffer
+// was filled at address base in a process with ptrSize (4 or 8) byte
+// pointers, like DecodeEnumServiceStatusProcess.
+func DecodeServiceConfig2(buf []byte, base uint64, ptrSize int, level uint32, conf *ServiceConfig2) error {
+	if ptrSize != 4 && ptrSize != 8 {
+		return &DecodeError{Msg: fmt.Sprintf("invalid pointer size: %d", ptrSize)}
+	}
+	var err error
+	switch level {
+	case SERVICE_CONFIG_DESCRIPTION:
+		conf.Description, err = decodeStringPtr(buf, base, 0, ptrSize)
+	case SERVICE_CONFIG_FAILURE_ACTIONS:
+		conf.FailureActions, err = decodeFailureActions(buf, base, ptrSize)
+	case SERVICE_CONFIG_DELAYED_AUTO_START_INFO:
+		conf.DelayedAutoStart, err = decodeBool(buf)
+	case SERVICE_CONFIG_FAILURE_ACTIONS_FLAG:
+		conf.FailureActionsOnNonCrashFailures, err = decodeBool(buf)
+	case SERVICE_CONFIG_SERVICE_SID_INFO:
+		var v uint32
+		v, err = decodeUint32(buf, 0)
+		conf.SidType = SidType(v)
+	case SERVICE_CONFIG_REQUIRED_PRIVILEGES_INFO:
+		conf.RequiredPrivileges, err = decodeMultiSZPtr(buf, base, 0, ptrSize)
+	case SERVICE_CONFIG_PRESHUTDOWN_INFO:
+		conf.PreshutdownTimeout, err = decodeUint32(buf, 0)
+	case SERVICE_CONFIG_TRIGGER_INFO:
+		conf.Triggers, err = decodeTriggerInfo(buf, base, ptrSize)
+	default:
+		err = &DecodeError{Msg: fmt.Sprintf("invalid information level: %d", level)}
+	}
+	return err
+}
+
+// decodeFailureActions decodes a SERVICE_FAILURE_ACTIONSW structure, whose
+// pointers are aligned to ptrSize.
+func decodeFailureActions(buf []byte, base uint64, ptrSize int) (FailureActions, error) {
+	var (
+		fa        FailureActions
+		err       error
+		rebootOff = ptrSize // After dwResetPeriod and its padding
+		cmdOff    = rebootOff + ptrSize
+		countOff  = cmdOff + ptrSize
+		arrayOff  = countOff + ptrSize
+	)
+	if len(buf) < arrayOff+ptrSize {
+		return fa, &DecodeError{Msg: fmt.Sprintf("SERVICE_FAILURE_ACTIONS does not fit in %d bytes", len(buf))}
+	}
+	fa.ResetPeriod = binary.LittleEndian.Uint32(buf)
+	if fa.RebootMsg, err = decodeStringPtr(buf, base, rebootOff, ptrSize); err != nil {
+		return fa, err
+	}
+	if fa.Command, err = decodeStringPtr(buf, base, cmdOff, ptrSize); err != nil {
+		return fa, err
+	}
+	count := int(binary.LittleEndian.Uint32(buf[countOff:]))
+	start, err := decodePtr(buf, base, arrayOff, ptrSize)
+	if err != nil || start < 0 {
+		return fa, err
+	}
+	const sizeofSCAction = 8
+	if count > (len(buf)-start)/sizeofSCAction {
+		return fa, &DecodeError{Offset: start, Msg: fmt.Sprintf("%d actions do not fit in buffer", count)}
+	}
+	fa.Actions = make([]FailureAction, count)
+	for i := range fa.Actions {
+		off := start + i*sizeofSCAction
+		fa.Actions[i] = FailureAction{
+			Type:  ActionType(binary.LittleEndian.Uint32(buf[off:])),
+			Delay: binary.LittleEndian.Uint32(buf[off+4:]),
+		}
+	}
+	return fa, nil
+}
+
+func decodeUint32(buf []byte, off int) (uint32, error) {
+	if off < 0 || off+4 > len(buf) {
+		return 0, &DecodeError{Offset: off, Msg: "DWORD outside of buffer"}
+	}
+	return binary.LittleEndian.Uint32(buf[off:]), nil
+}
+
+func decodeBool(buf []byte) (bool, error) {
+	v, err := decodeUint32(buf, 0)
+	return v != 0, err
+}
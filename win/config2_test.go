@@ -0,0 +1,391 @@
+package win
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// structWriter writes a structure followed by the data its pointers point
+// to, like QueryServiceConfig2 does.
+type structWriter struct {
+	buf     []byte
+	base    uint64
+	ptrSize int
+}
+
+func (w *structWriter) uint32(v uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+// align pads the buffer to a multiple of the pointer size.
+func (w *structWriter) align() {
+	for len(w.buf)%w.ptrSize != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// ptr appends a nil pointer, aligned to the pointer size, and returns its
+// offset.
+func (w *structWriter) ptr() int {
+	w.align()
+	off := len(w.buf)
+	w.buf = append(w.buf, make([]byte, w.ptrSize)...)
+	return off
+}
+
+// point sets the pointer at off to the end of the buffer.
+func (w *structWriter) point(off int) {
+	p := w.base + uint64(len(w.buf))
+	if w.ptrSize == 4 {
+		binary.LittleEndian.PutUint32(w.buf[off:], uint32(p))
+	} else {
+		binary.LittleEndian.PutUint64(w.buf[off:], p)
+	}
+}
+
+func (w *structWriter) utf16(s []uint16) {
+	for _, c := range s {
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, c)
+	}
+}
+
+// str appends s and sets the pointer at off to it, an empty s is left nil.
+func (w *structWriter) str(off int, s string) {
+	if s != "" {
+		w.point(off)
+		w.utf16(utf16.Encode([]rune(s + "\x00")))
+	}
+}
+
+// encodeServiceConfig2 encodes the fields of conf for information level.
+func encodeServiceConfig2(conf *ServiceConfig2, level uint32, base uint64, ptrSize int) []byte {
+	w := &structWriter{base: base, ptrSize: ptrSize}
+	switch level {
+	case SERVICE_CONFIG_DESCRIPTION:
+		w.str(w.ptr(), conf.Description)
+	case SERVICE_CONFIG_FAILURE_ACTIONS:
+		fa := conf.FailureActions
+		w.uint32(fa.ResetPeriod)
+		reboot, cmd := w.ptr(), w.ptr()
+		w.uint32(uint32(len(fa.Actions)))
+		actions := w.ptr()
+		if len(fa.Actions) != 0 {
+			w.point(actions)
+			for _, a := range fa.Actions {
+				w.uint32(uint32(a.Type))
+				w.uint32(a.Delay)
+			}
+		}
+		w.str(reboot, fa.RebootMsg)
+		w.str(cmd, fa.Command)
+	case SERVICE_CONFIG_DELAYED_AUTO_START_INFO:
+		w.uint32(boolToUint32(conf.DelayedAutoStart))
+	case SERVICE_CONFIG_FAILURE_ACTIONS_FLAG:
+		w.uint32(boolToUint32(conf.FailureActionsOnNonCrashFailures))
+	case SERVICE_CONFIG_SERVICE_SID_INFO:
+		w.uint32(uint32(conf.SidType))
+	case SERVICE_CONFIG_REQUIRED_PRIVILEGES_INFO:
+		off := w.ptr()
+		if conf.RequiredPrivileges != nil {
+			s, err := EncodeMultiSZ(conf.RequiredPrivileges)
+			if err != nil {
+				panic(err)
+			}
+			w.point(off)
+			w.utf16(s)
+		}
+	case SERVICE_CONFIG_PRESHUTDOWN_INFO:
+		w.uint32(conf.PreshutdownTimeout)
+	case SERVICE_CONFIG_TRIGGER_INFO:
+		w.uint32(uint32(len(conf.Triggers)))
+		triggers := w.ptr()
+		w.ptr() // pReserved
+		if len(conf.Triggers) != 0 {
+			w.point(triggers)
+			w.triggers(conf.Triggers)
+		}
+	}
+	return w.buf
+}
+
+// triggers appends an array of SERVICE_TRIGGER structures followed by
+// their subtypes and data items.
+func (w *structWriter) triggers(triggers []ServiceTrigger) {
+	subtypes := make([]int, len(triggers))
+	items := make([]int, len(triggers))
+	for i, t := range triggers {
+		w.uint32(uint32(t.Type))
+		w.uint32(uint32(t.Action))
+		subtypes[i] = w.ptr()
+		w.uint32(uint32(len(t.Data)))
+		items[i] = w.ptr()
+	}
+	for i, t := range triggers {
+		if t.Subtype != (GUID{}) {
+			w.point(subtypes[i])
+			w.uint32(t.Subtype.Data1)
+			w.buf = binary.LittleEndian.AppendUint16(w.buf, t.Subtype.Data2)
+			w.buf = binary.LittleEndian.AppendUint16(w.buf, t.Subtype.Data3)
+			w.buf = append(w.buf, t.Subtype.Data4[:]...)
+		}
+		if len(t.Data) == 0 {
+			continue
+		}
+		w.align()
+		w.point(items[i])
+		data := make([]int, len(t.Data))
+		for j, d := range t.Data {
+			w.uint32(uint32(d.Type))
+			w.uint32(uint32(len(d.Data)))
+			data[j] = w.ptr()
+		}
+		for j, d := range t.Data {
+			if len(d.Data) != 0 {
+				w.point(data[j])
+				w.buf = append(w.buf, d.Data...)
+			}
+		}
+	}
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var goldenConfig2 = ServiceConfig2{
+	Description: "Enables the detection, download, and installation of updates.",
+	FailureActions: FailureActions{
+		ResetPeriod: 86400,
+		RebootMsg:   "Rebooting after 3 failures",
+		Command:     `C:\Windows\System32\cmd.exe /c echo failed`,
+		Actions: []FailureAction{
+			{Type: SC_ACTION_RESTART, Delay: 60000},
+			{Type: SC_ACTION_RUN_COMMAND, Delay: 120000},
+			{Type: SC_ACTION_REBOOT, Delay: 0},
+		},
+	},
+	FailureActionsOnNonCrashFailures: true,
+	DelayedAutoStart:                 true,
+	SidType:                          SERVICE_SID_TYPE_UNRESTRICTED,
+	PreshutdownTimeout:               180000,
+	RequiredPrivileges:               []string{"SeAuditPrivilege", "SeCreateGlobalPrivilege", "SeImpersonatePrivilege"},
+	Triggers: []ServiceTrigger{
+		{
+			Type:    SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY,
+			Action:  SERVICE_TRIGGER_ACTION_SERVICE_START,
+			Subtype: NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID,
+		},
+		{
+			Type:    SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY,
+			Action:  SERVICE_TRIGGER_ACTION_SERVICE_STOP,
+			Subtype: NETWORK_MANAGER_LAST_IP_ADDRESS_REMOVAL_GUID,
+		},
+		{
+			Type:    SERVICE_TRIGGER_TYPE_DOMAIN_JOIN,
+			Action:  SERVICE_TRIGGER_ACTION_SERVICE_START,
+			Subtype: DOMAIN_JOIN_GUID,
+		},
+		{
+			Type:    SERVICE_TRIGGER_TYPE_NETWORK_ENDPOINT,
+			Action:  SERVICE_TRIGGER_ACTION_SERVICE_START,
+			Subtype: RPC_INTERFACE_EVENT_GUID,
+			Data: []TriggerData{
+				{Type: SERVICE_TRIGGER_DATA_TYPE_STRING, Data: utf16Bytes("367ABB81-9844-35F1-AD32-98F038001003\x00")},
+			},
+		},
+		{
+			Type:    SERVICE_TRIGGER_TYPE_CUSTOM,
+			Action:  SERVICE_TRIGGER_ACTION_SERVICE_START,
+			Subtype: MustParseGUID("{bc14e3d8-5e33-4e41-9f5c-c6b0a3f4c2a1}"),
+			Data: []TriggerData{
+				{Type: SERVICE_TRIGGER_DATA_TYPE_LEVEL, Data: []byte{4}},
+				{Type: SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ANY, Data: []byte{0x10, 0, 0, 0, 0, 0, 0, 0x80}},
+			},
+		},
+	},
+}
+
+// utf16Bytes returns s encoded as little-endian UTF-16.
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// config2Fixture holds the buffers returned for each information level.
+type config2Fixture struct {
+	Base    uint64
+	PtrSize int
+	Buffers map[uint32][]byte
+	Config  ServiceConfig2
+}
+
+var config2Files = []struct {
+	name    string
+	base    uint64
+	ptrSize int
+}{
+	{"service_config2_32", 0x00a31000, 4},
+	{"service_config2_64", 0x000001c4a0b31000, 8},
+}
+
+func TestDecodeServiceConfig2Golden(t *testing.T) {
+	for _, g := range config2Files {
+		path := filepath.Join("testdata", g.name+".json")
+		if *updateGolden {
+			fix := config2Fixture{Base: g.base, PtrSize: g.ptrSize, Buffers: map[uint32][]byte{}, Config: goldenConfig2}
+			for _, level := range serviceConfig2Levels {
+				fix.Buffers[level] = encodeServiceConfig2(&goldenConfig2, level, g.base, g.ptrSize)
+			}
+			b, err := json.MarshalIndent(fix, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fix := readConfig2Fixture(t, path)
+		var conf ServiceConfig2
+		for _, level := range serviceConfig2Levels {
+			if err := DecodeServiceConfig2(fix.Buffers[level], fix.Base, fix.PtrSize, level, &conf); err != nil {
+				t.Fatalf("%s: level %d: %s", g.name, level, err)
+			}
+		}
+		got, _ := json.Marshal(conf)
+		want, _ := json.Marshal(fix.Config)
+		if string(got) != string(want) {
+			t.Errorf("%s:\ngot  %s\nwant %s", g.name, got, want)
+		}
+	}
+}
+
+func readConfig2Fixture(tb testing.TB, path string) config2Fixture {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var fix config2Fixture
+	if err := json.Unmarshal(b, &fix); err != nil {
+		tb.Fatal(err)
+	}
+	return fix
+}
+
+func FuzzDecodeServiceConfig2(f *testing.F) {
+	for _, g := range config2Files {
+		fix := readConfig2Fixture(f, filepath.Join("testdata", g.name+".json"))
+		for level, buf := range fix.Buffers {
+			f.Add(buf, fix.Base, fix.PtrSize, level)
+		}
+	}
+	f.Fuzz(func(t *testing.T, buf []byte, base uint64, ptrSize int, level uint32) {
+		var conf ServiceConfig2
+		err := DecodeServiceConfig2(buf, base, ptrSize, level, &conf)
+		var de *DecodeError
+		if err != nil && !errors.As(err, &de) {
+			t.Fatalf("unexpected error type %T: %s", err, err)
+		}
+		if len(conf.FailureActions.Actions) > len(buf)/8 {
+			t.Fatalf("%d actions decoded from %d bytes", len(conf.FailureActions.Actions), len(buf))
+		}
+		if len(conf.Triggers) > len(buf)/16 {
+			t.Fatalf("%d triggers decoded from %d bytes", len(conf.Triggers), len(buf))
+		}
+	})
+}
+
+var _ = Describe("ServiceConfig2", func() {
+	const base = 0x7ff1000
+
+	for _, ptrSize := range []int{4, 8} {
+		ptrSize := ptrSize
+
+		Context(fmt.Sprintf("with %d byte pointers", ptrSize), func() {
+			It("decodes every information level", func() {
+				var conf ServiceConfig2
+				for _, level := range serviceConfig2Levels {
+					buf := encodeServiceConfig2(&goldenConfig2, level, base, ptrSize)
+					Expect(DecodeServiceConfig2(buf, base, ptrSize, level, &conf)).To(Succeed())
+				}
+				Expect(conf).To(Equal(goldenConfig2))
+			})
+
+			It("decodes nil pointers and empty actions", func() {
+				empty := ServiceConfig2{FailureActions: FailureActions{ResetPeriod: INFINITE}}
+				conf := goldenConfig2
+				for _, level := range serviceConfig2Levels {
+					buf := encodeServiceConfig2(&empty, level, base, ptrSize)
+					Expect(DecodeServiceConfig2(buf, base, ptrSize, level, &conf)).To(Succeed())
+				}
+				Expect(conf).To(Equal(empty))
+			})
+
+			It("rejects truncated buffers", func() {
+				for _, level := range serviceConfig2Levels {
+					buf := encodeServiceConfig2(&goldenConfig2, level, base, ptrSize)
+					var conf ServiceConfig2
+					err := DecodeServiceConfig2(buf[:len(buf)-1], base, ptrSize, level, &conf)
+					Expect(err).To(BeAssignableToTypeOf(&DecodeError{}), "level %d", level)
+				}
+			})
+
+			It("rejects action arrays that exceed the buffer", func() {
+				buf := encodeServiceConfig2(&goldenConfig2, SERVICE_CONFIG_FAILURE_ACTIONS, base, ptrSize)
+				binary.LittleEndian.PutUint32(buf[3*ptrSize:], 1<<20)
+				var conf ServiceConfig2
+				err := DecodeServiceConfig2(buf, base, ptrSize, SERVICE_CONFIG_FAILURE_ACTIONS, &conf)
+				Expect(err).To(MatchError(ContainSubstring("1048576 actions do not fit")))
+			})
+
+			It("rejects trigger arrays that exceed the buffer", func() {
+				buf := encodeServiceConfig2(&goldenConfig2, SERVICE_CONFIG_TRIGGER_INFO, base, ptrSize)
+				binary.LittleEndian.PutUint32(buf, 1<<20)
+				var conf ServiceConfig2
+				err := DecodeServiceConfig2(buf, base, ptrSize, SERVICE_CONFIG_TRIGGER_INFO, &conf)
+				Expect(err).To(MatchError(ContainSubstring("1048576 triggers do not fit")))
+			})
+		})
+	}
+
+	It("rejects unknown levels and pointer sizes", func() {
+		var conf ServiceConfig2
+		Expect(DecodeServiceConfig2(make([]byte, 8), base, 8, 42, &conf)).
+			To(MatchError("win: decode: offset 0: invalid information level: 42"))
+		Expect(DecodeServiceConfig2(make([]byte, 8), base, 2, SERVICE_CONFIG_DESCRIPTION, &conf)).
+			To(HaveOccurred())
+	})
+
+	It("is queried from handles of the fake backend", func() {
+		backend := NewFakeBackend()
+		backend.AddService("svc-1", Config{}, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.SetConfig2("svc-1", goldenConfig2)
+		h, err := backend.OpenService("svc-1")
+		Expect(err).To(BeNil())
+
+		conf, err := QueryServiceConfig2(h)
+		Expect(err).To(BeNil())
+		Expect(conf).To(Equal(goldenConfig2))
+
+		Expect(h.Close()).To(Succeed())
+		_, err = QueryServiceConfig2(h)
+		Expect(err).To(HaveOccurred())
+
+		_, err = QueryServiceConfig2(struct{ ServiceHandle }{h})
+		Expect(err).To(Equal(ErrNotSupported))
+	})
+})
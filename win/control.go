@@ -0,0 +1,300 @@
+package win
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"monitor/errno"
+)
+
+// defaultWaitHint is how long a service that reports no WaitHint is waited
+// for, the default service control timeout of Windows.
+const defaultWaitHint = 30 * time.Second
+
+// maxPollInterval is the longest interval between queries of a service
+// that is waited for.
+const maxPollInterval = 10 * time.Second
+
+// ControlOptions configures Start, Stop, Pause, Continue and Restart.
+type ControlOptions struct {
+	// Args are passed to the ServiceMain function of a started service.
+	Args []string
+
+	// PollInterval is the shortest interval between queries of a service
+	// that is waited for, one second if zero.  A tenth of the service's
+	// WaitHint is used if it is longer.
+	PollInterval time.Duration
+}
+
+func (o *ControlOptions) pollInterval() time.Duration {
+	if o == nil || o.PollInterval <= 0 {
+		return time.Second
+	}
+	return o.PollInterval
+}
+
+func (o *ControlOptions) args() []string {
+	if o == nil {
+		return nil
+	}
+	return o.Args
+}
+
+// A ControlError records a failed Start, Stop, Pause, Continue or Restart.
+// Err wraps the errno.Errno of the failure, which is also recorded in
+// Errno, for example:
+//
+//	ERROR_SERVICE_CANNOT_ACCEPT_CTRL  the service does not accept the control
+//	ERROR_SERVICE_NOT_ACTIVE          the service is not running
+//	ERROR_SERVICE_ALREADY_RUNNING     the service is paused or continuing
+//	ERROR_SERVICE_REQUEST_TIMEOUT     the service made no progress within its WaitHint
+//
+// If the service stopped while it was started or continued, its exit code
+// is returned.  Errno is zero if the context was done.
+type ControlError struct {
+	Service string
+	Op      string                 // "start", "stop", "pause", "continue" or "restart"
+	Target  ServiceState           // State the service was changed to
+	Status  SERVICE_STATUS_PROCESS // Last status of the service
+	Errno   errno.Errno
+	Err     error
+}
+
+func (e *ControlError) Error() string {
+	return "win: " + e.Op + " " + e.Service + ": " + e.Err.Error()
+}
+
+func (e *ControlError) Unwrap() error { return e.Err }
+
+// Start starts the service name and waits for it to run.  A running service
+// is left running, a stopping service is waited for and started.
+func Start(ctx context.Context, b SCMBackend, name string, opts *ControlOptions) error {
+	return controlService(ctx, b, name, "start", opts, (*serviceControl).start)
+}
+
+// Stop stops the service name and waits for it to stop, a stopped service
+// is left stopped.
+func Stop(ctx context.Context, b SCMBackend, name string, opts *ControlOptions) error {
+	return controlService(ctx, b, name, "stop", opts, (*serviceControl).stop)
+}
+
+// Pause pauses the service name and waits for it to be paused, a paused
+// service is left paused.
+func Pause(ctx context.Context, b SCMBackend, name string, opts *ControlOptions) error {
+	return controlService(ctx, b, name, "pause", opts, func(c *serviceControl) error {
+		return c.control(SERVICE_CONTROL_PAUSE, SERVICE_ACCEPT_PAUSE_CONTINUE,
+			SERVICE_PAUSE_PENDING, SERVICE_PAUSED)
+	})
+}
+
+// Continue continues the paused service name and waits for it to run, a
+// running service is left running.
+func Continue(ctx context.Context, b SCMBackend, name string, opts *ControlOptions) error {
+	return controlService(ctx, b, name, "continue", opts, func(c *serviceControl) error {
+		return c.control(SERVICE_CONTROL_CONTINUE, SERVICE_ACCEPT_PAUSE_CONTINUE,
+			SERVICE_CONTINUE_PENDING, SERVICE_RUNNING)
+	})
+}
+
+// Restart stops the service name, if it is not stopped, and starts it
+// again.  Restart waits for each state like Stop and Start.
+func Restart(ctx context.Context, b SCMBackend, name string, opts *ControlOptions) error {
+	return controlService(ctx, b, name, "restart", opts, func(c *serviceControl) error {
+		if err := c.stop(); err != nil {
+			return err
+		}
+		return c.start()
+	})
+}
+
+// serviceControl changes the state of a service and waits for it.
+type serviceControl struct {
+	ctx    context.Context
+	h      ServiceHandle
+	name   string
+	op     string
+	opts   *ControlOptions
+	target ServiceState
+	status SERVICE_STATUS_PROCESS // Last status queried
+}
+
+func controlService(ctx context.Context, b SCMBackend, name, op string, opts *ControlOptions, fn func(*serviceControl) error) error {
+	c := &serviceControl{ctx: ctx, name: name, op: op, opts: opts}
+	h, err := b.OpenService(name)
+	if err != nil {
+		return c.fail(listenerError(name, "OpenService", err))
+	}
+	defer h.Close()
+	c.h = h
+	return fn(c)
+}
+
+// fail returns err as a *ControlError.
+func (c *serviceControl) fail(err error) error {
+	en, _ := toErrno(err)
+	return &ControlError{
+		Service: c.name,
+		Op:      c.op,
+		Target:  c.target,
+		Status:  c.status,
+		Errno:   en,
+		Err:     err,
+	}
+}
+
+func (c *serviceControl) query() error {
+	status, err := c.h.Query()
+	if err != nil {
+		return c.fail(listenerError(c.name, "QueryServiceStatusEx", err))
+	}
+	c.status = status
+	return nil
+}
+
+func (c *serviceControl) start() error {
+	c.target = SERVICE_RUNNING
+	if err := c.query(); err != nil {
+		return err
+	}
+	if c.status.CurrentState == SERVICE_STOP_PENDING {
+		if err := c.settle(); err != nil {
+			return err
+		}
+	}
+	switch c.status.CurrentState {
+	case SERVICE_RUNNING:
+		return nil
+	case SERVICE_START_PENDING:
+		// Already starting, wait for it
+	case SERVICE_STOPPED:
+		s, ok := c.h.(ServiceStarter)
+		if !ok {
+			return c.fail(ErrNotSupported)
+		}
+		if err := s.Start(c.opts.args()...); err != nil {
+			return c.fail(listenerError(c.name, "StartService", err))
+		}
+	default:
+		return c.fail(errno.Errno(errno.ERROR_SERVICE_ALREADY_RUNNING))
+	}
+	return c.wait(SERVICE_RUNNING)
+}
+
+func (c *serviceControl) stop() error {
+	return c.control(SERVICE_CONTROL_STOP, SERVICE_ACCEPT_STOP, SERVICE_STOP_PENDING, SERVICE_STOPPED)
+}
+
+// control sends code to the service, if it accepts the control, and waits
+// for it to change to target through the pending state.  A service in
+// another pending state is waited for before sending code.
+func (c *serviceControl) control(code ControlCode, accept ServiceControl, pending, target ServiceState) error {
+	c.target = target
+	if err := c.query(); err != nil {
+		return err
+	}
+	if s := c.status.CurrentState; s != pending && isPending(s) {
+		if err := c.settle(); err != nil {
+			return err
+		}
+	}
+	switch c.status.CurrentState {
+	case target:
+		return nil
+	case pending:
+		return c.wait(target)
+	case SERVICE_STOPPED:
+		return c.fail(errno.Errno(errno.ERROR_SERVICE_NOT_ACTIVE))
+	}
+	if c.status.ControlsAccepted&accept == 0 {
+		return c.fail(fmt.Errorf("%w: %s, accepts %s", errno.Errno(errno.ERROR_SERVICE_CANNOT_ACCEPT_CTRL),
+			code, c.status.ControlsAccepted))
+	}
+	if err := c.h.Control(code); err != nil {
+		return c.fail(listenerError(c.name, "ControlService", err))
+	}
+	return c.wait(target)
+}
+
+// isPending reports whether state is a pending state.
+func isPending(state ServiceState) bool {
+	switch state {
+	case SERVICE_START_PENDING, SERVICE_STOP_PENDING, SERVICE_CONTINUE_PENDING, SERVICE_PAUSE_PENDING:
+		return true
+	}
+	return false
+}
+
+// wait waits for the service to be in state, see poll.
+func (c *serviceControl) wait(state ServiceState) error {
+	return c.poll(func(s ServiceState) bool { return s == state })
+}
+
+// settle waits for the service to leave its pending state, see poll.
+func (c *serviceControl) settle() error {
+	return c.poll(func(s ServiceState) bool { return !isPending(s) })
+}
+
+// poll queries the service until done reports true for its state.  The
+// service must make progress, by changing state or by incrementing its
+// CheckPoint, within its WaitHint or ERROR_SERVICE_REQUEST_TIMEOUT is
+// returned.  A service that stops while it is started or continued
+// failed, its exit code is returned.
+func (c *serviceControl) poll(done func(ServiceState) bool) error {
+	var (
+		prev     SERVICE_STATUS_PROCESS
+		deadline time.Time
+	)
+	for i := 0; ; i++ {
+		if err := c.query(); err != nil {
+			return err
+		}
+		state := c.status.CurrentState
+		if done(state) {
+			return nil
+		}
+		if state == SERVICE_STOPPED && c.target != SERVICE_STOPPED {
+			return c.fail(exitError(c.status))
+		}
+		now := time.Now()
+		if i == 0 || state != prev.CurrentState || c.status.CheckPoint != prev.CheckPoint {
+			wait := time.Duration(c.status.WaitHint) * time.Millisecond
+			if wait == 0 {
+				wait = defaultWaitHint
+			}
+			deadline = now.Add(wait)
+		} else if now.After(deadline) {
+			return c.fail(fmt.Errorf("%w: %s after %d checkpoints, waiting for %s",
+				errno.Errno(errno.ERROR_SERVICE_REQUEST_TIMEOUT), state, c.status.CheckPoint, c.target))
+		}
+		prev = c.status
+
+		interval := time.Duration(c.status.WaitHint) * time.Millisecond / 10
+		if min := c.opts.pollInterval(); interval < min {
+			interval = min
+		}
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-c.ctx.Done():
+			t.Stop()
+			return c.fail(c.ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// exitError returns the exit code of a stopped service as an error.
+func exitError(status SERVICE_STATUS_PROCESS) error {
+	switch e := errno.Errno(status.Win32ExitCode); e {
+	case errno.ERROR_SUCCESS:
+		return errors.New("service stopped")
+	case errno.ERROR_SERVICE_SPECIFIC_ERROR:
+		return fmt.Errorf("%w: %d", e, status.ServiceSpecificExitCode)
+	default:
+		return e
+	}
+}
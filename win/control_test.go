@@ -0,0 +1,188 @@
+package win
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Service control", func() {
+	var (
+		backend *FakeBackend
+		ctx     context.Context
+		opts    *ControlOptions
+	)
+
+	status := func(name string) SERVICE_STATUS_PROCESS {
+		h, err := backend.OpenService(name)
+		Expect(err).To(BeNil())
+		defer h.Close()
+		status, err := h.Query()
+		Expect(err).To(BeNil())
+		return status
+	}
+
+	controlError := func(err error) *ControlError {
+		var cerr *ControlError
+		Expect(errors.As(err, &cerr)).To(BeTrue(), "%v", err)
+		return cerr
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc", Config{StartType: SERVICE_DEMAND_START}, SERVICE_STATUS_PROCESS{
+			ServiceType:      SERVICE_WIN32_OWN_PROCESS,
+			CurrentState:     SERVICE_STOPPED,
+			ControlsAccepted: SERVICE_ACCEPT_STOP | SERVICE_ACCEPT_PAUSE_CONTINUE,
+		})
+		ctx = context.Background()
+		opts = &ControlOptions{PollInterval: time.Millisecond}
+	})
+
+	It("starts, pauses, continues and stops services", func() {
+		Expect(Start(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(Pause(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_PAUSED))
+		Expect(Continue(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(Stop(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("leaves services that are in the target state", func() {
+		Expect(Stop(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(Start(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(Start(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(Continue(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("restarts services", func() {
+		Expect(Restart(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+
+		backend.SetPending("svc", 1000)
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return status("svc").CurrentState }).Should(Equal(SERVICE_STOP_PENDING))
+			backend.SetState("svc", SERVICE_STOPPED)
+			Eventually(func() ServiceState { return status("svc").CurrentState }).Should(Equal(SERVICE_START_PENDING))
+			backend.SetStatus("svc", SERVICE_STATUS_PROCESS{CurrentState: SERVICE_RUNNING})
+		}()
+		Expect(Restart(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("does not send controls the service does not accept", func() {
+		backend.SetStatus("svc", SERVICE_STATUS_PROCESS{
+			CurrentState:     SERVICE_RUNNING,
+			ControlsAccepted: SERVICE_ACCEPT_SHUTDOWN,
+		})
+		for _, fn := range []func(context.Context, SCMBackend, string, *ControlOptions) error{Stop, Pause, Restart} {
+			err := fn(ctx, backend, "svc", opts)
+			Expect(errors.Is(err, errno.Errno(errno.ERROR_SERVICE_CANNOT_ACCEPT_CTRL))).To(BeTrue())
+			cerr := controlError(err)
+			Expect(cerr.Errno).To(Equal(errno.Errno(errno.ERROR_SERVICE_CANNOT_ACCEPT_CTRL)))
+			Expect(cerr.Service).To(Equal("svc"))
+			Expect(cerr.Status.CurrentState).To(Equal(SERVICE_RUNNING))
+		}
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_RUNNING))
+
+		err := Stop(ctx, backend, "svc", opts)
+		Expect(err).To(MatchError(ContainSubstring("win: stop svc: ")))
+		Expect(err).To(MatchError(ContainSubstring("SERVICE_CONTROL_STOP, accepts SERVICE_ACCEPT_SHUTDOWN")))
+	})
+
+	It("returns ERROR_SERVICE_NOT_ACTIVE for stopped services", func() {
+		err := Pause(ctx, backend, "svc", opts)
+		cerr := controlError(err)
+		Expect(cerr.Op).To(Equal("pause"))
+		Expect(cerr.Target).To(Equal(SERVICE_PAUSED))
+		Expect(cerr.Errno).To(Equal(errno.Errno(errno.ERROR_SERVICE_NOT_ACTIVE)))
+		Expect(errors.Is(Continue(ctx, backend, "svc", opts), errno.Errno(errno.ERROR_SERVICE_NOT_ACTIVE))).To(BeTrue())
+	})
+
+	It("returns ERROR_SERVICE_ALREADY_RUNNING when starting paused services", func() {
+		Expect(Start(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(Pause(ctx, backend, "svc", opts)).To(Succeed())
+		err := Start(ctx, backend, "svc", opts)
+		Expect(controlError(err).Errno).To(Equal(errno.Errno(errno.ERROR_SERVICE_ALREADY_RUNNING)))
+	})
+
+	It("waits for services in other pending states to settle", func() {
+		backend.SetStatus("svc", SERVICE_STATUS_PROCESS{CurrentState: SERVICE_START_PENDING, CheckPoint: 1, WaitHint: 1000})
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			backend.SetStatus("svc", SERVICE_STATUS_PROCESS{CurrentState: SERVICE_RUNNING, ControlsAccepted: SERVICE_ACCEPT_STOP})
+		}()
+		Expect(Stop(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(status("svc").CurrentState).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("waits as long as the CheckPoint increments within the WaitHint", func() {
+		backend.SetPending("svc", 30)
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return status("svc").CurrentState }).Should(Equal(SERVICE_START_PENDING))
+			for i := uint32(2); i <= 5; i++ {
+				time.Sleep(15 * time.Millisecond)
+				backend.SetStatus("svc", SERVICE_STATUS_PROCESS{CurrentState: SERVICE_START_PENDING, CheckPoint: i, WaitHint: 30})
+			}
+			backend.SetState("svc", SERVICE_RUNNING)
+		}()
+		t := time.Now()
+		Expect(Start(ctx, backend, "svc", opts)).To(Succeed())
+		Expect(time.Since(t)).To(BeNumerically(">=", 60*time.Millisecond), "longer than the WaitHint")
+	})
+
+	It("times out services that make no progress", func() {
+		backend.SetPending("svc", 20)
+		err := Start(ctx, backend, "svc", opts)
+		Expect(errors.Is(err, errno.Errno(errno.ERROR_SERVICE_REQUEST_TIMEOUT))).To(BeTrue())
+		cerr := controlError(err)
+		Expect(cerr.Status.CurrentState).To(Equal(SERVICE_START_PENDING))
+		Expect(cerr.Status.CheckPoint).To(Equal(uint32(1)))
+		Expect(err).To(MatchError(ContainSubstring("after 1 checkpoints, waiting for SERVICE_RUNNING")))
+	})
+
+	It("returns the exit code of services that stop while starting", func() {
+		backend.SetPending("svc", 1000)
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return status("svc").CurrentState }).Should(Equal(SERVICE_START_PENDING))
+			backend.SetStatus("svc", SERVICE_STATUS_PROCESS{
+				CurrentState:  SERVICE_STOPPED,
+				Win32ExitCode: uint32(errno.ERROR_FILE_NOT_FOUND),
+			})
+		}()
+		err := Start(ctx, backend, "svc", opts)
+		Expect(controlError(err).Errno).To(Equal(errno.Errno(errno.ERROR_FILE_NOT_FOUND)))
+	})
+
+	It("returns the errors of the Service Control Manager", func() {
+		backend.AddService("off", Config{StartType: SERVICE_DISABLED}, SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED})
+		err := Start(ctx, backend, "off", opts)
+		Expect(controlError(err).Errno).To(Equal(errno.Errno(errno.ERROR_SERVICE_DISABLED)))
+		var lerr *ListenerError
+		Expect(errors.As(err, &lerr)).To(BeTrue())
+		Expect(lerr.Op).To(Equal("StartService"))
+
+		err = Stop(ctx, backend, "unknown", opts)
+		Expect(controlError(err).Errno).To(Equal(errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST)))
+	})
+
+	It("stops waiting when the context is done", func() {
+		backend.SetPending("svc", 10000)
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err := Start(ctx, backend, "svc", opts)
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(controlError(err).Errno).To(BeZero())
+	})
+})
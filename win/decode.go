@@ -0,0 +1,156 @@
+package win
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Sizes of the ENUM_SERVICE_STATUS_PROCESSW structure in 32-bit and 64-bit
+// processes, which differ in the size and alignment of its two pointers.
+const (
+	SizeofEnumServiceStatusProcess32 = 44
+	SizeofEnumServiceStatusProcess64 = 56
+
+	sizeofServiceStatusProcess = 36
+)
+
+// A DecodeError reports a malformed buffer returned by the Service Control
+// Manager.
+type DecodeError struct {
+	Offset int // Offset of the malformed data in the buffer
+	Msg    string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("win: decode: offset %d: %s", e.Offset, e.Msg)
+}
+
+// DecodeEnumServiceStatusProcess decodes count ENUM_SERVICE_STATUS_PROCESSW
+// structures from buf, which was filled by EnumServicesStatusEx at address
+// base in a process with ptrSize (4 or 8) byte pointers.  The service and
+// display names must be NUL terminated and lie within buf, a nil pointer
+// decodes as an empty string.
+func DecodeEnumServiceStatusProcess(buf []byte, base uint64, count, ptrSize int) ([]EnumServiceStatusProcess, error) {
+	var size int
+	switch ptrSize {
+	case 4:
+		size = SizeofEnumServiceStatusProcess32
+	case 8:
+		size = SizeofEnumServiceStatusProcess64
+	default:
+		return nil, &DecodeError{Msg: fmt.Sprintf("invalid pointer size: %d", ptrSize)}
+	}
+	if count < 0 || count > len(buf)/size {
+		return nil, &DecodeError{Msg: fmt.Sprintf("%d services do not fit in %d bytes", count, len(buf))}
+	}
+	procs := make([]EnumServiceStatusProcess, count)
+	for i := range procs {
+		off := i * size
+		name, err := decodeStringPtr(buf, base, off, ptrSize)
+		if err != nil {
+			return nil, err
+		}
+		display, err := decodeStringPtr(buf, base, off+ptrSize, ptrSize)
+		if err != nil {
+			return nil, err
+		}
+		procs[i] = EnumServiceStatusProcess{
+			ServiceName:          name,
+			DisplayName:          display,
+			ServiceStatusProcess: decodeServiceStatusProcess(buf[off+2*ptrSize:]),
+		}
+	}
+	return procs, nil
+}
+
+// decodeServiceStatusProcess decodes a SERVICE_STATUS_PROCESS structure, b
+// must hold at least sizeofServiceStatusProcess bytes.
+func decodeServiceStatusProcess(b []byte) SERVICE_STATUS_PROCESS {
+	_ = b[sizeofServiceStatusProcess-1]
+	u := func(i int) uint32 { return binary.LittleEndian.Uint32(b[4*i:]) }
+	return SERVICE_STATUS_PROCESS{
+		ServiceType:             ServiceType(u(0)),
+		CurrentState:            ServiceState(u(1)),
+		ControlsAccepted:        ServiceControl(u(2)),
+		Win32ExitCode:           u(3),
+		ServiceSpecificExitCode: u(4),
+		CheckPoint:              u(5),
+		WaitHint:                u(6),
+		ProcessId:               u(7),
+		ServiceFlags:            u(8),
+	}
+}
+
+// decodePtr decodes the pointer at buf[off:] and returns the offset in buf
+// that it points to, or -1 for a nil pointer.  The pointer must lie within
+// buf.
+func decodePtr(buf []byte, base uint64, off, ptrSize int) (int, error) {
+	if off < 0 || off+ptrSize > len(buf) {
+		return 0, &DecodeError{Offset: off, Msg: "pointer outside of buffer"}
+	}
+	var p uint64
+	if ptrSize == 4 {
+		p = uint64(binary.LittleEndian.Uint32(buf[off:]))
+	} else {
+		p = binary.LittleEndian.Uint64(buf[off:])
+	}
+	if p == 0 {
+		return -1, nil
+	}
+	if p < base || p-base >= uint64(len(buf)) {
+		return 0, &DecodeError{Offset: off, Msg: fmt.Sprintf("pointer %#x outside of buffer", p)}
+	}
+	return int(p - base), nil
+}
+
+// decodeStringPtr decodes the NUL terminated UTF-16 string pointed to by
+// the pointer at buf[off:].
+func decodeStringPtr(buf []byte, base uint64, off, ptrSize int) (string, error) {
+	start, err := decodePtr(buf, base, off, ptrSize)
+	if start < 0 || err != nil {
+		return "", err
+	}
+	s, ok := decodeUTF16z(buf[start:])
+	if !ok {
+		return "", &DecodeError{Offset: start, Msg: "unterminated string"}
+	}
+	return s, nil
+}
+
+// decodeMultiSZPtr decodes the MULTI_SZ string pointed to by the pointer at
+// buf[off:].
+func decodeMultiSZPtr(buf []byte, base uint64, off, ptrSize int) ([]string, error) {
+	start, err := decodePtr(buf, base, off, ptrSize)
+	if start < 0 || err != nil {
+		return nil, err
+	}
+	b := buf[start:]
+	if len(b) < 2 {
+		return nil, &DecodeError{Offset: start, Msg: "unterminated MULTI_SZ string"}
+	}
+	s := make([]uint16, len(b)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	list, err := DecodeMultiSZ(s)
+	if err != nil {
+		e := err.(*DecodeError)
+		return nil, &DecodeError{Offset: start + e.Offset, Msg: e.Msg}
+	}
+	return list, nil
+}
+
+// decodeUTF16z decodes the little-endian UTF-16 string at the start of b up
+// to its NUL terminator, false is returned if b holds no terminator.
+func decodeUTF16z(b []byte) (string, bool) {
+	var s []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			return string(utf16.Decode(s)), true
+		}
+		s = append(s, c)
+	}
+	return "", false
+}
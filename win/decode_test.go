@@ -0,0 +1,224 @@
+package win
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// encodeEnumServiceStatusProcess lays out procs the way EnumServicesStatusEx
+// does: the structures at the start of the buffer followed by the strings.
+// Empty display names are encoded as nil pointers.
+func encodeEnumServiceStatusProcess(procs []EnumServiceStatusProcess, base uint64, ptrSize int) []byte {
+	size := SizeofEnumServiceStatusProcess64
+	if ptrSize == 4 {
+		size = SizeofEnumServiceStatusProcess32
+	}
+	buf := make([]byte, len(procs)*size)
+	putString := func(off int, s string) {
+		p := base + uint64(len(buf))
+		for _, c := range utf16.Encode([]rune(s + "\x00")) {
+			buf = binary.LittleEndian.AppendUint16(buf, c)
+		}
+		if ptrSize == 4 {
+			binary.LittleEndian.PutUint32(buf[off:], uint32(p))
+		} else {
+			binary.LittleEndian.PutUint64(buf[off:], p)
+		}
+	}
+	for i, p := range procs {
+		off := i * size
+		putString(off, p.ServiceName)
+		if p.DisplayName != "" {
+			putString(off+ptrSize, p.DisplayName)
+		}
+		st := p.ServiceStatusProcess
+		for j, v := range []uint32{uint32(st.ServiceType), uint32(st.CurrentState),
+			uint32(st.ControlsAccepted), st.Win32ExitCode, st.ServiceSpecificExitCode,
+			st.CheckPoint, st.WaitHint, st.ProcessId, st.ServiceFlags} {
+			binary.LittleEndian.PutUint32(buf[off+2*ptrSize+4*j:], v)
+		}
+	}
+	return buf
+}
+
+var goldenServices = []EnumServiceStatusProcess{
+	{
+		ServiceName: "AppHostSvc",
+		DisplayName: "Application Host Helper Service",
+		ServiceStatusProcess: SERVICE_STATUS_PROCESS{
+			ServiceType:      SERVICE_WIN32_SHARE_PROCESS,
+			CurrentState:     SERVICE_RUNNING,
+			ControlsAccepted: SERVICE_ACCEPT_STOP | SERVICE_ACCEPT_PAUSE_CONTINUE,
+			ProcessId:        1804,
+		},
+	},
+	{
+		ServiceName: "garden-windows",
+		DisplayName: "Gärten – Windows ✓ 🌱",
+		ServiceStatusProcess: SERVICE_STATUS_PROCESS{
+			ServiceType:   SERVICE_WIN32_OWN_PROCESS,
+			CurrentState:  SERVICE_STOPPED,
+			Win32ExitCode: 1077,
+		},
+	},
+	{
+		ServiceName: "wuauserv",
+		ServiceStatusProcess: SERVICE_STATUS_PROCESS{
+			ServiceType:  SERVICE_WIN32_SHARE_PROCESS,
+			CurrentState: SERVICE_START_PENDING,
+			CheckPoint:   3,
+			WaitHint:     30000,
+			ServiceFlags: 1,
+		},
+	},
+}
+
+// goldenFixture describes a buffer in testdata.
+type goldenFixture struct {
+	Base     uint64
+	PtrSize  int
+	Count    int
+	Services []EnumServiceStatusProcess
+}
+
+var goldenFiles = []struct {
+	name    string
+	base    uint64
+	ptrSize int
+}{
+	{"enum_service_status_process_32", 0x00a30000, 4},
+	{"enum_service_status_process_64", 0x000001c4a0b20000, 8},
+}
+
+func readGolden(tb testing.TB, name string) ([]byte, goldenFixture) {
+	buf, err := os.ReadFile(filepath.Join("testdata", name+".bin"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var fix goldenFixture
+	if err := json.Unmarshal(b, &fix); err != nil {
+		tb.Fatal(err)
+	}
+	return buf, fix
+}
+
+func TestDecodeEnumServiceStatusProcessGolden(t *testing.T) {
+	for _, g := range goldenFiles {
+		if *updateGolden {
+			buf := encodeEnumServiceStatusProcess(goldenServices, g.base, g.ptrSize)
+			fix, err := json.MarshalIndent(goldenFixture{
+				Base:     g.base,
+				PtrSize:  g.ptrSize,
+				Count:    len(goldenServices),
+				Services: goldenServices,
+			}, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join("testdata", g.name+".bin"), buf, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join("testdata", g.name+".json"), append(fix, '\n'), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		buf, fix := readGolden(t, g.name)
+		procs, err := DecodeEnumServiceStatusProcess(buf, fix.Base, fix.Count, fix.PtrSize)
+		if err != nil {
+			t.Fatalf("%s: %s", g.name, err)
+		}
+		if len(procs) != len(fix.Services) {
+			t.Fatalf("%s: decoded %d services, want %d", g.name, len(procs), len(fix.Services))
+		}
+		for i := range procs {
+			if procs[i] != fix.Services[i] {
+				t.Errorf("%s: service %d: got %+v, want %+v", g.name, i, procs[i], fix.Services[i])
+			}
+		}
+	}
+}
+
+func FuzzDecodeEnumServiceStatusProcess(f *testing.F) {
+	for _, g := range goldenFiles {
+		buf, fix := readGolden(f, g.name)
+		f.Add(buf, fix.Base, fix.Count, fix.PtrSize)
+	}
+	f.Fuzz(func(t *testing.T, buf []byte, base uint64, count, ptrSize int) {
+		procs, err := DecodeEnumServiceStatusProcess(buf, base, count, ptrSize)
+		if err == nil && len(procs) != count {
+			t.Fatalf("decoded %d services, want %d", len(procs), count)
+		}
+		if err != nil && procs != nil {
+			t.Fatalf("services returned with error: %s", err)
+		}
+	})
+}
+
+var _ = Describe("DecodeEnumServiceStatusProcess", func() {
+	const base = 0x7ff0000
+
+	for _, ptrSize := range []int{4, 8} {
+		ptrSize := ptrSize
+		size := SizeofEnumServiceStatusProcess64
+		if ptrSize == 4 {
+			size = SizeofEnumServiceStatusProcess32
+		}
+
+		Context(fmt.Sprintf("with %d byte pointers", ptrSize), func() {
+			It("decodes the services", func() {
+				buf := encodeEnumServiceStatusProcess(goldenServices, base, ptrSize)
+				procs, err := DecodeEnumServiceStatusProcess(buf, base, len(goldenServices), ptrSize)
+				Expect(err).To(BeNil())
+				Expect(procs).To(Equal(goldenServices))
+			})
+
+			It("decodes an empty buffer", func() {
+				procs, err := DecodeEnumServiceStatusProcess(nil, base, 0, ptrSize)
+				Expect(err).To(BeNil())
+				Expect(procs).To(BeEmpty())
+			})
+
+			It("rejects counts that exceed the buffer", func() {
+				buf := encodeEnumServiceStatusProcess(goldenServices[:1], base, ptrSize)
+				_, err := DecodeEnumServiceStatusProcess(buf[:size-1], base, 1, ptrSize)
+				Expect(err).To(BeAssignableToTypeOf(&DecodeError{}))
+				_, err = DecodeEnumServiceStatusProcess(buf, base, -1, ptrSize)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("rejects pointers outside of the buffer", func() {
+				buf := encodeEnumServiceStatusProcess(goldenServices[:1], base, ptrSize)
+				_, err := DecodeEnumServiceStatusProcess(buf, base+uint64(len(buf)), 1, ptrSize)
+				Expect(err).To(MatchError(ContainSubstring("outside of buffer")))
+				_, err = DecodeEnumServiceStatusProcess(buf, base+1<<31, 1, ptrSize)
+				Expect(err).To(MatchError(ContainSubstring("outside of buffer")))
+			})
+
+			It("rejects unterminated strings", func() {
+				buf := encodeEnumServiceStatusProcess(goldenServices[:1], base, ptrSize)
+				_, err := DecodeEnumServiceStatusProcess(buf[:len(buf)-2], base, 1, ptrSize)
+				Expect(err).To(MatchError(ContainSubstring("unterminated string")))
+			})
+		})
+	}
+
+	It("rejects invalid pointer sizes", func() {
+		_, err := DecodeEnumServiceStatusProcess(make([]byte, 64), base, 1, 2)
+		Expect(err).To(MatchError("win: decode: offset 0: invalid pointer size: 2"))
+	})
+})
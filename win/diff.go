@@ -0,0 +1,118 @@
+package win
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ChangeType is the kind of difference reported by Diff.
+type ChangeType int
+
+const (
+	ServiceCreated          ChangeType = iota // Service is only in the new list
+	ServiceDeleted                            // Service is only in the old list
+	DisplayNameChanged                        // DisplayName changed
+	CurrentStateChanged                       // ServiceStatusProcess.CurrentState changed
+	ProcessIdChanged                          // ServiceStatusProcess.ProcessId changed
+	ControlsAcceptedChanged                   // ServiceStatusProcess.ControlsAccepted changed
+)
+
+var changeTypeStr = [...]string{
+	"ServiceCreated",
+	"ServiceDeleted",
+	"DisplayNameChanged",
+	"CurrentStateChanged",
+	"ProcessIdChanged",
+	"ControlsAcceptedChanged",
+}
+
+func (t ChangeType) String() string {
+	if t >= 0 && int(t) < len(changeTypeStr) {
+		return changeTypeStr[t]
+	}
+	return strconv.Itoa(int(t))
+}
+
+// A Change is a difference between two service inventories.  Old is the
+// zero value for created services and New is the zero value for deleted
+// services.
+type Change struct {
+	Type        ChangeType
+	ServiceName string
+	Old         EnumServiceStatusProcess
+	New         EnumServiceStatusProcess
+}
+
+func (c Change) String() string {
+	switch c.Type {
+	case ServiceCreated, ServiceDeleted:
+		return fmt.Sprintf("%s: %s", c.ServiceName, c.Type)
+	case DisplayNameChanged:
+		return fmt.Sprintf("%s: %s: %q -> %q", c.ServiceName, c.Type,
+			c.Old.DisplayName, c.New.DisplayName)
+	case CurrentStateChanged:
+		return fmt.Sprintf("%s: %s: %s -> %s", c.ServiceName, c.Type,
+			c.Old.ServiceStatusProcess.CurrentState, c.New.ServiceStatusProcess.CurrentState)
+	case ProcessIdChanged:
+		return fmt.Sprintf("%s: %s: %d -> %d", c.ServiceName, c.Type,
+			c.Old.ServiceStatusProcess.ProcessId, c.New.ServiceStatusProcess.ProcessId)
+	case ControlsAcceptedChanged:
+		return fmt.Sprintf("%s: %s: %s -> %s", c.ServiceName, c.Type,
+			c.Old.ServiceStatusProcess.ControlsAccepted, c.New.ServiceStatusProcess.ControlsAccepted)
+	}
+	return fmt.Sprintf("%s: %s", c.ServiceName, c.Type)
+}
+
+// Diff returns the changes between the service inventories old and new.
+// Changes are ordered by service name and then by ChangeType, a service
+// may have more than one change.  If a list contains a service more than
+// once the last entry is used.
+func Diff(old, new []EnumServiceStatusProcess) []Change {
+	before := make(map[string]EnumServiceStatusProcess, len(old))
+	for _, p := range old {
+		before[p.ServiceName] = p
+	}
+	after := make(map[string]EnumServiceStatusProcess, len(new))
+	for _, p := range new {
+		after[p.ServiceName] = p
+	}
+
+	var changes []Change
+	for name, n := range after {
+		o, ok := before[name]
+		if !ok {
+			changes = append(changes, Change{Type: ServiceCreated, ServiceName: name, New: n})
+			continue
+		}
+		add := func(t ChangeType) {
+			changes = append(changes, Change{Type: t, ServiceName: name, Old: o, New: n})
+		}
+		if o.DisplayName != n.DisplayName {
+			add(DisplayNameChanged)
+		}
+		os, ns := o.ServiceStatusProcess, n.ServiceStatusProcess
+		if os.CurrentState != ns.CurrentState {
+			add(CurrentStateChanged)
+		}
+		if os.ProcessId != ns.ProcessId {
+			add(ProcessIdChanged)
+		}
+		if os.ControlsAccepted != ns.ControlsAccepted {
+			add(ControlsAcceptedChanged)
+		}
+	}
+	for name, o := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, Change{Type: ServiceDeleted, ServiceName: name, Old: o})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].ServiceName != changes[j].ServiceName {
+			return changes[i].ServiceName < changes[j].ServiceName
+		}
+		return changes[i].Type < changes[j].Type
+	})
+	return changes
+}
@@ -0,0 +1,104 @@
+package win
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func enumService(name, display string, state ServiceState, pid uint32, controls ServiceControl) EnumServiceStatusProcess {
+	return EnumServiceStatusProcess{
+		ServiceName: name,
+		DisplayName: display,
+		ServiceStatusProcess: SERVICE_STATUS_PROCESS{
+			ServiceType:      SERVICE_WIN32_OWN_PROCESS,
+			CurrentState:     state,
+			ControlsAccepted: controls,
+			ProcessId:        pid,
+		},
+	}
+}
+
+func changeTypes(changes []Change) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.ServiceName+":"+c.Type.String())
+	}
+	return s
+}
+
+var _ = Describe("Diff", func() {
+	It("returns no changes for identical inventories", func() {
+		list := []EnumServiceStatusProcess{
+			enumService("a", "A", SERVICE_RUNNING, 10, SERVICE_ACCEPT_STOP),
+		}
+		Expect(Diff(list, list)).To(BeEmpty())
+		Expect(Diff(nil, nil)).To(BeEmpty())
+	})
+
+	It("reports created and deleted services", func() {
+		old := []EnumServiceStatusProcess{
+			enumService("a", "A", SERVICE_RUNNING, 10, 0),
+		}
+		new := []EnumServiceStatusProcess{
+			enumService("b", "B", SERVICE_STOPPED, 0, 0),
+		}
+		changes := Diff(old, new)
+		Expect(changeTypes(changes)).To(Equal([]string{
+			"a:ServiceDeleted",
+			"b:ServiceCreated",
+		}))
+		Expect(changes[0].Old.DisplayName).To(Equal("A"))
+		Expect(changes[0].New).To(BeZero())
+		Expect(changes[1].Old).To(BeZero())
+		Expect(changes[1].New.DisplayName).To(Equal("B"))
+	})
+
+	It("reports every changed field of a service in ChangeType order", func() {
+		old := []EnumServiceStatusProcess{
+			enumService("a", "A", SERVICE_STOPPED, 0, 0),
+		}
+		new := []EnumServiceStatusProcess{
+			enumService("a", "Renamed", SERVICE_RUNNING, 42, SERVICE_ACCEPT_STOP),
+		}
+		changes := Diff(old, new)
+		Expect(changeTypes(changes)).To(Equal([]string{
+			"a:DisplayNameChanged",
+			"a:CurrentStateChanged",
+			"a:ProcessIdChanged",
+			"a:ControlsAcceptedChanged",
+		}))
+		Expect(changes[1].String()).To(Equal("a: CurrentStateChanged: SERVICE_STOPPED -> SERVICE_RUNNING"))
+		Expect(changes[2].String()).To(Equal("a: ProcessIdChanged: 0 -> 42"))
+	})
+
+	It("orders changes by service name regardless of input order", func() {
+		old := []EnumServiceStatusProcess{
+			enumService("c", "C", SERVICE_RUNNING, 1, 0),
+			enumService("a", "A", SERVICE_RUNNING, 1, 0),
+		}
+		new := []EnumServiceStatusProcess{
+			enumService("b", "B", SERVICE_RUNNING, 1, 0),
+			enumService("a", "A", SERVICE_STOPPED, 0, 0),
+		}
+		expected := []string{
+			"a:CurrentStateChanged",
+			"a:ProcessIdChanged",
+			"b:ServiceCreated",
+			"c:ServiceDeleted",
+		}
+		for i := 0; i < 10; i++ {
+			Expect(changeTypes(Diff(old, new))).To(Equal(expected))
+		}
+	})
+
+	It("uses the last entry of duplicated services", func() {
+		old := []EnumServiceStatusProcess{
+			enumService("a", "A", SERVICE_STOPPED, 0, 0),
+			enumService("a", "A", SERVICE_RUNNING, 1, 0),
+		}
+		new := []EnumServiceStatusProcess{
+			enumService("a", "A", SERVICE_RUNNING, 1, 0),
+		}
+		Expect(Diff(old, new)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,153 @@
+package win
+
+import (
+	"errors"
+	"strings"
+)
+
+// EnumOptions selects the services enumerated by EnumerateServices.
+type EnumOptions struct {
+	// Type is the type of the services enumerated, SERVICE_WIN32 if zero.
+	Type ServiceType
+
+	// State selects active or inactive services, SERVICE_STATE_ALL if zero.
+	State ServiceEnumState
+
+	// Group enumerates only the members of the load order group, matched
+	// ignoring case.  Services of every group are enumerated if Group is
+	// empty and NoGroup is false.
+	Group string
+
+	// NoGroup enumerates only the services that belong to no group.
+	NoGroup bool
+}
+
+func (o EnumOptions) serviceType() ServiceType {
+	if o.Type == 0 {
+		return SERVICE_WIN32
+	}
+	return o.Type
+}
+
+func (o EnumOptions) state() ServiceEnumState {
+	if o.State == 0 {
+		return SERVICE_STATE_ALL
+	}
+	return o.State
+}
+
+// match reports whether a service with status st in group matches the
+// State, Group and NoGroup options.
+func (o EnumOptions) match(st *SERVICE_STATUS_PROCESS, group string) bool {
+	active := st.CurrentState != SERVICE_STOPPED
+	if o.state()&SERVICE_ACTIVE == 0 && active ||
+		o.state()&SERVICE_INACTIVE == 0 && !active {
+		return false
+	}
+	switch {
+	case o.NoGroup:
+		return group == ""
+	case o.Group != "":
+		return strings.EqualFold(group, o.Group)
+	}
+	return true
+}
+
+// A ServicePager is an SCMBackend that enumerates services a page at a
+// time.  EnumServicesPage returns the services matching opts starting at
+// resume, which is zero for the first page, and the resume handle of the
+// next page, which is zero after the last page.
+type ServicePager interface {
+	EnumServicesPage(opts EnumOptions, resume uint32) ([]EnumServiceStatusProcess, uint32, error)
+}
+
+// errNoProgress is returned when a page is empty and does not advance the
+// resume handle, which would otherwise loop forever.
+var errNoProgress = errors.New("win: enumeration did not advance")
+
+// A ServiceIterator steps through the services enumerated by
+// EnumerateServices, requesting a page from the backend when the previous
+// one is exhausted.  Services created or deleted during the enumeration may
+// or may not be returned.
+type ServiceIterator struct {
+	pager  ServicePager
+	opts   EnumOptions
+	page   []EnumServiceStatusProcess
+	cur    EnumServiceStatusProcess
+	resume uint32
+	done   bool
+	err    error
+}
+
+// EnumerateServices returns an iterator over the services of b matching
+// opts.  Backends that are not a ServicePager are enumerated in a single
+// page with ListServices and do not support the Group and NoGroup options.
+func EnumerateServices(b SCMBackend, opts EnumOptions) *ServiceIterator {
+	p, ok := b.(ServicePager)
+	if !ok {
+		p = listPager{b}
+	}
+	return &ServiceIterator{pager: p, opts: opts}
+}
+
+// Next advances the iterator to the next service, it returns false when
+// the enumeration is complete or fails.
+func (it *ServiceIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		page, next, err := it.pager.EnumServicesPage(it.opts, it.resume)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if len(page) == 0 && next != 0 && next == it.resume {
+			it.err = errNoProgress
+			return false
+		}
+		it.page, it.resume, it.done = page, next, next == 0
+	}
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Service returns the service the iterator is positioned at.
+func (it *ServiceIterator) Service() EnumServiceStatusProcess { return it.cur }
+
+// Err returns the error that stopped the enumeration, if any.
+func (it *ServiceIterator) Err() error {
+	return listenerError("", "EnumServicesStatusEx", it.err)
+}
+
+// All returns the remaining services.
+func (it *ServiceIterator) All() ([]EnumServiceStatusProcess, error) {
+	var procs []EnumServiceStatusProcess
+	for it.Next() {
+		procs = append(procs, it.Service())
+	}
+	return procs, it.Err()
+}
+
+// listPager enumerates the services of an SCMBackend that is not a
+// ServicePager in a single page.
+type listPager struct {
+	b SCMBackend
+}
+
+func (p listPager) EnumServicesPage(opts EnumOptions, _ uint32) ([]EnumServiceStatusProcess, uint32, error) {
+	if opts.Group != "" || opts.NoGroup {
+		return nil, 0, ErrNotSupported
+	}
+	procs, err := p.b.ListServices(opts.serviceType())
+	if err != nil {
+		return nil, 0, err
+	}
+	page := procs[:0]
+	for _, proc := range procs {
+		if opts.match(&proc.ServiceStatusProcess, "") {
+			page = append(page, proc)
+		}
+	}
+	return page, 0, nil
+}
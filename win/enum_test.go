@@ -0,0 +1,143 @@
+package win
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func enumNames(it *ServiceIterator) []string {
+	var names []string
+	for it.Next() {
+		names = append(names, it.Service().ServiceName)
+	}
+	Expect(it.Err()).To(BeNil())
+	return names
+}
+
+// stuckPager returns the same empty page forever.
+type stuckPager struct {
+	SCMBackend
+}
+
+func (stuckPager) EnumServicesPage(EnumOptions, uint32) ([]EnumServiceStatusProcess, uint32, error) {
+	return nil, 7, nil
+}
+
+var _ = Describe("EnumerateServices", func() {
+	var (
+		backend *FakeBackend
+		stopped = SERVICE_STATUS_PROCESS{
+			ServiceType:  SERVICE_WIN32_OWN_PROCESS,
+			CurrentState: SERVICE_STOPPED,
+		}
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		own := Config{ServiceType: SERVICE_WIN32_OWN_PROCESS}
+		for i := 0; i < 10; i++ {
+			status := running(SERVICE_WIN32_OWN_PROCESS)
+			if i%2 == 1 {
+				status = stopped
+			}
+			backend.AddService(fmt.Sprintf("svc-%d", i), own, status)
+		}
+		backend.AddService("tcpip", Config{ServiceType: SERVICE_KERNEL_DRIVER, LoadOrderGroup: "PNP_TDI"},
+			running(SERVICE_KERNEL_DRIVER))
+		backend.AddService("dhcp", Config{ServiceType: SERVICE_WIN32_SHARE_PROCESS, LoadOrderGroup: "TDI"},
+			running(SERVICE_WIN32_SHARE_PROCESS))
+		backend.AddService("netbt", Config{ServiceType: SERVICE_KERNEL_DRIVER, LoadOrderGroup: "PNP_TDI"},
+			stopped)
+	})
+
+	DescribeTable("pages through every service",
+		func(pageSize int) {
+			backend.SetPageSize(pageSize)
+			procs, err := backend.ListServices(SERVICE_WIN32)
+			Expect(err).To(BeNil())
+			var expected []string
+			for _, p := range procs {
+				expected = append(expected, p.ServiceName)
+			}
+			Expect(enumNames(EnumerateServices(backend, EnumOptions{}))).To(Equal(expected))
+		},
+		Entry("single page", 0),
+		Entry("one service per page", 1),
+		Entry("partial last page", 3),
+		Entry("exact pages", 11),
+		Entry("larger than the enumeration", 100),
+	)
+
+	It("selects services by type", func() {
+		backend.SetPageSize(2)
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{Type: SERVICE_DRIVER}))).
+			To(Equal([]string{"netbt", "tcpip"}))
+	})
+
+	It("selects active or inactive services", func() {
+		backend.SetPageSize(2)
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{State: SERVICE_ACTIVE}))).
+			To(Equal([]string{"dhcp", "svc-0", "svc-2", "svc-4", "svc-6", "svc-8"}))
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{
+			Type:  SERVICE_DRIVER | SERVICE_WIN32,
+			State: SERVICE_INACTIVE,
+		}))).To(Equal([]string{"netbt", "svc-1", "svc-3", "svc-5", "svc-7", "svc-9"}))
+	})
+
+	It("selects services by load order group", func() {
+		all := SERVICE_DRIVER | SERVICE_WIN32
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{Type: all, Group: "pnp_tdi"}))).
+			To(Equal([]string{"netbt", "tcpip"}))
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{Type: all, Group: "TDI"}))).
+			To(Equal([]string{"dhcp"}))
+		Expect(enumNames(EnumerateServices(backend, EnumOptions{Type: all, NoGroup: true}))).
+			To(HaveLen(10))
+	})
+
+	It("returns the remaining services", func() {
+		backend.SetPageSize(4)
+		it := EnumerateServices(backend, EnumOptions{})
+		Expect(it.Next()).To(BeTrue())
+		Expect(it.Service().ServiceName).To(Equal("dhcp"))
+		procs, err := it.All()
+		Expect(err).To(BeNil())
+		Expect(procs).To(HaveLen(10))
+		Expect(it.Next()).To(BeFalse())
+	})
+
+	It("stops at the first error", func() {
+		backend.SetPageSize(4)
+		it := EnumerateServices(backend, EnumOptions{})
+		Expect(it.Next()).To(BeTrue())
+		backend.Disconnect()
+		for it.Next() {
+		}
+		var le *ListenerError
+		Expect(errors.As(it.Err(), &le)).To(BeTrue())
+		Expect(le.Op).To(Equal("EnumServicesStatusEx"))
+		Expect(it.Next()).To(BeFalse())
+	})
+
+	It("fails if the resume handle does not advance", func() {
+		it := EnumerateServices(stuckPager{backend}, EnumOptions{})
+		Expect(it.Next()).To(BeFalse())
+		Expect(errors.Is(it.Err(), errNoProgress)).To(BeTrue())
+	})
+
+	Context("with a backend that is not a ServicePager", func() {
+		It("enumerates a single page with ListServices", func() {
+			Expect(enumNames(EnumerateServices(noClose{backend}, EnumOptions{State: SERVICE_INACTIVE}))).
+				To(Equal([]string{"svc-1", "svc-3", "svc-5", "svc-7", "svc-9"}))
+		})
+
+		It("does not support groups", func() {
+			it := EnumerateServices(noClose{backend}, EnumOptions{Group: "TDI"})
+			Expect(it.Next()).To(BeFalse())
+			Expect(errors.Is(it.Err(), ErrNotSupported)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package win
+
+import (
+	"errors"
+	"syscall"
+
+	"monitor/errno"
+)
+
+// A ListenerError records a failed Service Control Manager operation.  Op
+// is the name of the Windows API function, such as OpenService or
+// NotifyServiceStatusChange.  Service is empty for operations on the
+// Service Control Manager.
+type ListenerError struct {
+	Service string
+	Op      string
+	Errno   errno.Errno
+	Err     error // Error returned by the operation
+}
+
+func (e *ListenerError) Error() string {
+	if e.Service == "" {
+		return "win: " + e.Op + ": " + e.Err.Error()
+	}
+	return "win: " + e.Op + " " + e.Service + ": " + e.Err.Error()
+}
+
+func (e *ListenerError) Unwrap() error { return e.Err }
+
+// listenerError returns err as a *ListenerError, errors that already are
+// a *ListenerError are returned unchanged.
+func listenerError(svcName, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var le *ListenerError
+	if errors.As(err, &le) {
+		return err
+	}
+	en, _ := toErrno(err)
+	return &ListenerError{Service: svcName, Op: op, Errno: en, Err: err}
+}
+
+// toErrno returns the Windows error code of err, the backends return
+// either errno.Errno or syscall.Errno values.
+func toErrno(err error) (errno.Errno, bool) {
+	var en errno.Errno
+	if errors.As(err, &en) {
+		return en, true
+	}
+	var se syscall.Errno
+	if errors.As(err, &se) {
+		return errno.Errno(se), true
+	}
+	return 0, false
+}
+
+// isErrno reports whether err is the Windows error code e.
+func isErrno(err error, e errno.Errno) bool {
+	en, ok := toErrno(err)
+	return ok && en == e
+}
+
+// isDeleted reports whether err indicates that a service was deleted.
+func isDeleted(err error) bool {
+	return isErrno(err, errno.ERROR_SERVICE_MARKED_FOR_DELETE) ||
+		isErrno(err, errno.ERROR_SERVICE_DOES_NOT_EXIST)
+}
@@ -0,0 +1,134 @@
+package win
+
+import (
+	"errors"
+	"syscall"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ListenerError", func() {
+	It("formats the operation and service", func() {
+		err := listenerError("svc-1", "OpenService", errno.Errno(errno.ERROR_ACCESS_DENIED))
+		Expect(err.Error()).To(Equal("win: OpenService svc-1: " + errno.Errno(errno.ERROR_ACCESS_DENIED).Error()))
+
+		err = listenerError("", "NotifyServiceStatusChange", errno.Errno(errno.ERROR_INVALID_HANDLE))
+		Expect(err.Error()).To(HavePrefix("win: NotifyServiceStatusChange: "))
+	})
+
+	It("records the errno of Windows errors", func() {
+		var le *ListenerError
+		err := listenerError("svc-1", "OpenService", syscall.Errno(errno.ERROR_ACCESS_DENIED))
+		Expect(errors.As(err, &le)).To(BeTrue())
+		Expect(le.Errno).To(Equal(errno.Errno(errno.ERROR_ACCESS_DENIED)))
+		Expect(isErrno(err, errno.ERROR_ACCESS_DENIED)).To(BeTrue())
+
+		err = listenerError("svc-1", "OpenService", errors.New("boom"))
+		Expect(errors.As(err, &le)).To(BeTrue())
+		Expect(le.Errno).To(BeZero())
+	})
+
+	It("does not wrap a ListenerError twice", func() {
+		err := listenerError("svc-1", "OpenService", errno.Errno(errno.ERROR_ACCESS_DENIED))
+		Expect(listenerError("svc-1", "QueryServiceConfig", err)).To(BeIdenticalTo(err))
+		Expect(listenerError("svc-1", "OpenService", nil)).To(BeNil())
+	})
+})
+
+var _ = Describe("Supervisor errors", func() {
+	var (
+		backend *FakeBackend
+		conf    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+		filter  = func(_ string, c *Config) bool { return c.Description == "vcap" }
+	)
+
+	nextError := func(s *Supervisor) *ListenerError {
+		var err error
+		Eventually(s.Errors()).Should(Receive(&err))
+		le, ok := err.(*ListenerError)
+		Expect(ok).To(BeTrue())
+		return le
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
+	})
+
+	It("isolates services that fail when starting", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_INVALID_NAME))
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		Expect(serviceNames(s)).To(ConsistOf("svc-2"))
+		var le *ListenerError
+		Expect(errors.As(s.StartupError(), &le)).To(BeTrue())
+		Expect(le.Service).To(Equal("svc-1"))
+		Expect(le.Op).To(Equal("OpenService"))
+		Expect(le.Errno).To(Equal(errno.Errno(errno.ERROR_INVALID_NAME)))
+	})
+
+	It("isolates created services that fail", func() {
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		backend.SetOpenError("svc-3", errno.Errno(errno.ERROR_INVALID_NAME))
+		backend.AddService("svc-3", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-4", conf, running(SERVICE_WIN32_OWN_PROCESS))
+
+		Expect(nextError(s).Service).To(Equal("svc-3"))
+		Eventually(func() []string { return serviceNames(s) }).
+			Should(ConsistOf("svc-1", "svc-2", "svc-4"))
+	})
+
+	It("reports notification failures", func() {
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+		// A service may be reported twice if the SERVICE_NOTIFY_CREATED
+		// notification from adding it is handled after its listener failed.
+		seen := map[string]bool{}
+		for len(seen) < 3 {
+			le := nextError(s)
+			Expect(le.Op).To(Equal("NotifyServiceStatusChange"))
+			Expect(le.Errno).To(Equal(errno.Errno(errno.ERROR_ACCESS_DENIED)))
+			seen[le.Service] = true
+		}
+		Expect(seen).To(Equal(map[string]bool{"": true, "svc-1": true, "svc-2": true}))
+	})
+
+	It("does not report deleted services", func() {
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		backend.DeleteService("svc-1")
+		Eventually(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-2"))
+		Consistently(s.Errors(), 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("fails when the services cannot be enumerated", func() {
+		backend.Close()
+		_, err := NewSupervisor(filter, WithBackend(backend))
+		var le *ListenerError
+		Expect(errors.As(err, &le)).To(BeTrue())
+		Expect(le.Op).To(Equal("EnumServicesStatusEx"))
+		Expect(le.Service).To(BeEmpty())
+	})
+
+	It("closes the Errors channel", func() {
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		Expect(s.Close()).To(Succeed())
+		Eventually(s.Errors()).Should(BeClosed())
+	})
+})
@@ -0,0 +1,53 @@
+package win
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type EventType int
+
+const (
+	ServiceAdded    EventType = iota // Service started being monitored
+	ServiceRemoved                   // Service was deleted
+	StateChanged                     // Service status changed
+	WatchStarted                     // Service matches a filter added to the Supervisor
+	WatchStopped                     // Service no longer matches the Supervisor's filters
+	ServiceReloaded                  // Service handle was reopened
+)
+
+var eventTypeStr = [...]string{
+	"ServiceAdded",
+	"ServiceRemoved",
+	"StateChanged",
+	"WatchStarted",
+	"WatchStopped",
+	"ServiceReloaded",
+}
+
+func (t EventType) String() string {
+	if int(t) < len(eventTypeStr) {
+		return eventTypeStr[t]
+	}
+	return strconv.Itoa(int(t))
+}
+
+// An Event describes a change to a monitored service.
+type Event struct {
+	Type     EventType
+	Service  string
+	Previous SERVICE_STATUS_PROCESS // Status before the event
+	Status   SERVICE_STATUS_PROCESS // Status after the event
+	Time     time.Time
+
+	// Seq is the per-service sequence number of the event, it starts
+	// at 1 when the service is first monitored.
+	Seq uint64
+}
+
+func (e Event) String() string {
+	const format = "{Type: %s, Service: %s, Previous: %s, Status: %s, Time: %s, Seq: %d}"
+	return fmt.Sprintf(format, e.Type, e.Service, e.Previous.CurrentState,
+		e.Status.CurrentState, e.Time.Format(time.RFC3339Nano), e.Seq)
+}
@@ -0,0 +1,118 @@
+package win
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+	var (
+		backend *FakeBackend
+		s       *Supervisor
+		conf    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+	)
+
+	nextEvent := func() Event {
+		var e Event
+		Eventually(s.Events()).Should(Receive(&e))
+		return e
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+
+		var err error
+		s, err = startSupervisor(func(_ string, c *Config) bool {
+			return c.Description == "vcap"
+		}, WithBackend(backend))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("does not send events for pre-existing services", func() {
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("sends ServiceAdded for created services", func() {
+		start := time.Now()
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceAdded))
+		Expect(e.Service).To(Equal("svc-2"))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(e.Seq).To(Equal(uint64(1)))
+		Expect(e.Time).To(BeTemporally(">=", start))
+	})
+
+	It("sends StateChanged with the previous and new status", func() {
+		backend.SetState("svc-1", SERVICE_STOP_PENDING)
+		backend.SetState("svc-1", SERVICE_STOPPED)
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-1"))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOP_PENDING))
+		Expect(e.Seq).To(Equal(uint64(1)))
+
+		e = nextEvent()
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_STOP_PENDING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		Expect(e.Seq).To(Equal(uint64(2)))
+
+		Expect(s.Services()[0].Status.CurrentState).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("publishes the events of a service in Seq order", func() {
+		sub, err := s.Subscribe(SubscribeOptions{Buffer: 1000})
+		Expect(err).To(BeNil())
+		defer sub.Close()
+		l := s.Services()[0]
+		s.mu.RLock()
+		listener := s.serviceListeners[l.Name]
+		s.mu.RUnlock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					s.emit(listener, StateChanged, l.Status, l.Status)
+				}
+			}()
+		}
+		wg.Wait()
+		for seq := uint64(1); seq <= 400; seq++ {
+			var e Event
+			Eventually(sub.Events()).Should(Receive(&e))
+			Expect(e.Seq).To(Equal(seq))
+		}
+	})
+
+	It("sends a single ServiceRemoved for deleted services", func() {
+		backend.DeleteService("svc-1")
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceRemoved))
+		Expect(e.Service).To(Equal("svc-1"))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+		Expect(s.Services()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("EventType", func() {
+	It("has a String representation", func() {
+		Expect(StateChanged.String()).To(Equal("StateChanged"))
+		Expect(EventType(42).String()).To(Equal("42"))
+	})
+})
@@ -0,0 +1,877 @@
+package win
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"monitor/errno"
+)
+
+// FakeBackend is an in-memory SCMBackend.  Services are added, removed and
+// updated with AddService, DeleteService and SetStatus, which deliver the
+// same notifications the Service Control Manager would.
+type FakeBackend struct {
+	mu       sync.Mutex
+	services map[string]*fakeService
+	scm      *fakeQueue
+	closed   bool
+	notifErr error            // Returned by WaitNotify
+	openErrs map[string]error // Returned by OpenService
+
+	gen          int           // Connection generation, incremented by Disconnect
+	disconnected bool          // Set by Disconnect, cleared by Reconnect
+	reconnectErr error         // Returned by Reconnect
+	latency      time.Duration // Added to every call, see SetLatency
+	pageSize     int           // Services per page, see SetPageSize
+
+	engine *notifyEngine // Delivers notifications if set, see useNotifyEngine
+	notify notifyWaiter
+}
+
+type fakeService struct {
+	config  Config
+	config2 ServiceConfig2
+	status  SERVICE_STATUS_PROCESS
+	handles []*fakeHandle
+
+	// waitHint is the WaitHint of the pending states Start and Control
+	// leave the service in, see SetPending.
+	waitHint uint32
+
+	// accepts are the ControlsAccepted of the service when Start and
+	// Control leave it running or paused.
+	accepts ServiceControl
+}
+
+// transition returns the status of the service changing to state, or to
+// pending if it was set pending by SetPending.
+func (svc *fakeService) transition(pending, state ServiceState) SERVICE_STATUS_PROCESS {
+	status := svc.status
+	status.CurrentState = state
+	status.CheckPoint = 0
+	status.WaitHint = 0
+	status.ControlsAccepted = 0
+	if state != SERVICE_STOPPED {
+		status.ControlsAccepted = svc.accepts
+	}
+	if svc.waitHint != 0 {
+		status.CurrentState = pending
+		status.CheckPoint = 1
+		status.WaitHint = svc.waitHint
+		status.ControlsAccepted = 0
+	}
+	return status
+}
+
+// NewFakeBackend returns an empty FakeBackend.
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		services: make(map[string]*fakeService),
+		scm:      newFakeQueue(),
+		openErrs: make(map[string]error),
+	}
+}
+
+// useNotifyEngine makes the backend and its handles deliver notifications
+// from a single notifyEngine goroutine, like the Windows backend, instead
+// of waiting in the goroutine calling WaitNotify.  It must be called before
+// the backend is used.
+func (b *FakeBackend) useNotifyEngine() {
+	b.engine = newNotifyEngine(newFakeNotifySource())
+}
+
+// AddService adds a service and sends a SERVICE_NOTIFY_CREATED notification.
+// Once started the service accepts the ControlsAccepted of status, or
+// SERVICE_ACCEPT_STOP if there are none.
+func (b *FakeBackend) AddService(name string, conf Config, status SERVICE_STATUS_PROCESS) {
+	accepts := status.ControlsAccepted
+	if accepts == 0 {
+		accepts = SERVICE_ACCEPT_STOP
+	}
+	b.mu.Lock()
+	b.services[name] = &fakeService{config: conf, status: status, accepts: accepts}
+	b.mu.Unlock()
+	b.NotifySCM("/" + name)
+}
+
+// SetConfig sets the configuration of a service.
+func (b *FakeBackend) SetConfig(name string, conf Config) {
+	b.mu.Lock()
+	if svc := b.services[name]; svc != nil {
+		svc.config = conf
+	}
+	b.mu.Unlock()
+}
+
+// SetConfig2 sets the extended configuration of a service.
+func (b *FakeBackend) SetConfig2(name string, conf ServiceConfig2) {
+	b.mu.Lock()
+	if svc := b.services[name]; svc != nil {
+		svc.config2 = conf
+	}
+	b.mu.Unlock()
+}
+
+// DeleteService removes a service, sends SERVICE_NOTIFY_DELETE_PENDING to
+// its open handles and a SERVICE_NOTIFY_DELETED notification.
+func (b *FakeBackend) DeleteService(name string) {
+	b.mu.Lock()
+	svc := b.services[name]
+	delete(b.services, name)
+	b.mu.Unlock()
+	if svc == nil {
+		return
+	}
+	for _, h := range svc.handles {
+		h.deleted(svc.status)
+	}
+	b.NotifySCM(name)
+}
+
+// NotifySCM sends an SCM notification for names, as delivered by the SCM:
+// created services have a '/' prefix, the others were deleted.  The
+// services of the backend are not changed.
+func (b *FakeBackend) NotifySCM(names ...string) {
+	n := &ServiceNotify{}
+	n.setServiceNames(names)
+	for _, c := range n.changes {
+		if c.Type == ServiceCreated {
+			n.NotificationTriggered |= SERVICE_NOTIFY_CREATED
+		} else {
+			n.NotificationTriggered |= SERVICE_NOTIFY_DELETED
+		}
+	}
+	b.pushSCM(n)
+}
+
+// SetStatus sets the status of a service and notifies its open handles.
+func (b *FakeBackend) SetStatus(name string, status SERVICE_STATUS_PROCESS) {
+	b.mu.Lock()
+	svc := b.services[name]
+	if svc == nil {
+		b.mu.Unlock()
+		return
+	}
+	svc.status = status
+	handles := append([]*fakeHandle(nil), svc.handles...)
+	b.mu.Unlock()
+	for _, h := range handles {
+		h.queue.push(&ServiceNotify{
+			ServiceStatus:         status,
+			NotificationTriggered: notificationForState(status.CurrentState),
+		})
+	}
+}
+
+// Notify sends the notification n to the open handles of a service, unlike
+// SetStatus it does not change the service's status.
+func (b *FakeBackend) Notify(name string, n ServiceNotify) {
+	b.mu.Lock()
+	var handles []*fakeHandle
+	if svc := b.services[name]; svc != nil {
+		handles = append(handles, svc.handles...)
+	}
+	b.mu.Unlock()
+	for _, h := range handles {
+		n := n
+		h.queue.push(&n)
+	}
+}
+
+// InvalidateHandles makes the open handles of a service fail with
+// ERROR_INVALID_HANDLE, as if they had gone stale.
+func (b *FakeBackend) InvalidateHandles(name string) {
+	b.mu.Lock()
+	var handles []*fakeHandle
+	if svc := b.services[name]; svc != nil {
+		handles = svc.handles
+		svc.handles = nil
+	}
+	for _, h := range handles {
+		h.invalid = true
+	}
+	b.mu.Unlock()
+	for _, h := range handles {
+		h.queue.push(nil)
+	}
+}
+
+// SetState sets the CurrentState of a service and notifies its open handles.
+func (b *FakeBackend) SetState(name string, state ServiceState) {
+	b.mu.Lock()
+	var status SERVICE_STATUS_PROCESS
+	if svc := b.services[name]; svc != nil {
+		status = svc.status
+	}
+	b.mu.Unlock()
+	status.CurrentState = state
+	b.SetStatus(name, status)
+}
+
+// SetPending makes Start and stop, pause and continue controls leave a
+// service in the pending state with WaitHint waitHint (milliseconds)
+// instead of completing immediately, SetState completes them.  A zero
+// waitHint restores immediate completion.
+func (b *FakeBackend) SetPending(name string, waitHint uint32) {
+	b.mu.Lock()
+	if svc := b.services[name]; svc != nil {
+		svc.waitHint = waitHint
+	}
+	b.mu.Unlock()
+}
+
+// SetNotifyError makes WaitNotify of the backend and all service handles
+// return err, a nil err restores notifications.  Pending calls to WaitNotify
+// return err immediately.
+func (b *FakeBackend) SetNotifyError(err error) {
+	b.mu.Lock()
+	b.notifErr = err
+	q := b.scm
+	var handles []*fakeHandle
+	for _, svc := range b.services {
+		handles = append(handles, svc.handles...)
+	}
+	b.mu.Unlock()
+	if err == nil {
+		return
+	}
+	for _, h := range handles {
+		h.queue.push(nil)
+	}
+	q.push(nil)
+}
+
+// SetOpenError makes OpenService return err for the service name, a nil
+// err restores access to the service.
+func (b *FakeBackend) SetOpenError(name string, err error) {
+	b.mu.Lock()
+	if err != nil {
+		b.openErrs[name] = err
+	} else {
+		delete(b.openErrs, name)
+	}
+	b.mu.Unlock()
+}
+
+// Disconnect simulates losing the connection to the Service Control
+// Manager, the backend and all open handles fail with ERROR_INVALID_HANDLE
+// and notifications are lost until Reconnect is called.
+func (b *FakeBackend) Disconnect() {
+	b.mu.Lock()
+	b.gen++
+	b.disconnected = true
+	var handles []*fakeHandle
+	for _, svc := range b.services {
+		handles = append(handles, svc.handles...)
+		svc.handles = nil
+	}
+	b.mu.Unlock()
+	for _, h := range handles {
+		h.queue.push(nil)
+	}
+	b.scm.push(nil)
+}
+
+// SetLatency delays ListServices, OpenService and the Config and Query
+// calls of service handles by d, to simulate the round trip to the Service
+// Control Manager.
+func (b *FakeBackend) SetLatency(d time.Duration) {
+	b.mu.Lock()
+	b.latency = d
+	b.mu.Unlock()
+}
+
+// delay sleeps for the latency set with SetLatency.
+func (b *FakeBackend) delay() {
+	b.mu.Lock()
+	d := b.latency
+	b.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// SetReconnectError makes Reconnect return err, a nil err allows
+// reconnecting.
+func (b *FakeBackend) SetReconnectError(err error) {
+	b.mu.Lock()
+	b.reconnectErr = err
+	b.mu.Unlock()
+}
+
+// Reconnect restores the connection after Disconnect.
+func (b *FakeBackend) Reconnect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	if b.reconnectErr != nil {
+		return b.reconnectErr
+	}
+	if b.disconnected {
+		b.disconnected = false
+		b.scm = newFakeQueue()
+		if b.engine != nil {
+			// Registered with the previous queue.
+			defer b.notify.close(b.engine)
+		}
+	}
+	return nil
+}
+
+// pushSCM sends an SCM notification unless the backend is disconnected.
+func (b *FakeBackend) pushSCM(n *ServiceNotify) {
+	b.mu.Lock()
+	q, ok := b.scm, !b.disconnected
+	b.mu.Unlock()
+	if ok {
+		q.push(n)
+	}
+}
+
+// connErr returns the error of operations on a closed or disconnected
+// backend, b.mu must be held.
+func (b *FakeBackend) connErr() error {
+	if b.closed || b.disconnected {
+		return errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	return nil
+}
+
+func (b *FakeBackend) notifyError() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.connErr(); err != nil {
+		return err
+	}
+	return b.notifErr
+}
+
+func (b *FakeBackend) ListServices(typ ServiceType) ([]EnumServiceStatusProcess, error) {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.connErr(); err != nil {
+		return nil, err
+	}
+	procs := make([]EnumServiceStatusProcess, 0, len(b.services))
+	for name, svc := range b.services {
+		if svc.config.ServiceType != 0 && svc.config.ServiceType&typ == 0 {
+			continue
+		}
+		procs = append(procs, EnumServiceStatusProcess{
+			ServiceName:          name,
+			DisplayName:          svc.config.DisplayName,
+			ServiceStatusProcess: svc.status,
+		})
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].ServiceName < procs[j].ServiceName
+	})
+	return procs, nil
+}
+
+// SetPageSize sets the number of services returned by each call to
+// EnumServicesPage, all matching services are returned if n is zero.
+func (b *FakeBackend) SetPageSize(n int) {
+	b.mu.Lock()
+	b.pageSize = n
+	b.mu.Unlock()
+}
+
+// EnumServicesPage returns the services matching opts sorted by name, the
+// resume handle is one more than the index of the next service.
+func (b *FakeBackend) EnumServicesPage(opts EnumOptions, resume uint32) ([]EnumServiceStatusProcess, uint32, error) {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.connErr(); err != nil {
+		return nil, 0, err
+	}
+	var procs []EnumServiceStatusProcess
+	for name, svc := range b.services {
+		if svc.config.ServiceType != 0 && svc.config.ServiceType&opts.serviceType() == 0 {
+			continue
+		}
+		if !opts.match(&svc.status, svc.config.LoadOrderGroup) {
+			continue
+		}
+		procs = append(procs, EnumServiceStatusProcess{
+			ServiceName:          name,
+			DisplayName:          svc.config.DisplayName,
+			ServiceStatusProcess: svc.status,
+		})
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		return procs[i].ServiceName < procs[j].ServiceName
+	})
+
+	start := 0
+	if resume != 0 {
+		start = int(resume) - 1
+	}
+	if start > len(procs) {
+		start = len(procs)
+	}
+	procs = procs[start:]
+	if b.pageSize == 0 || len(procs) <= b.pageSize {
+		return procs, 0, nil
+	}
+	return procs[:b.pageSize], uint32(start + b.pageSize + 1), nil
+}
+
+func (b *FakeBackend) OpenService(name string) (ServiceHandle, error) {
+	b.delay()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.connErr(); err != nil {
+		return nil, err
+	}
+	if err := b.openErrs[name]; err != nil {
+		return nil, err
+	}
+	svc := b.services[name]
+	if svc == nil {
+		return nil, errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST)
+	}
+	h := &fakeHandle{backend: b, name: name, gen: b.gen, queue: newFakeQueue()}
+	svc.handles = append(svc.handles, h)
+	return h, nil
+}
+
+func (b *FakeBackend) WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error) {
+	if b.engine != nil {
+		return b.notify.wait(b.engine, b, mask, timeout)
+	}
+	if err := b.notifyError(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	q := b.scm
+	b.mu.Unlock()
+	n := q.wait(mask, timeout)
+	if n == nil {
+		return nil, b.notifyError()
+	}
+	return n, nil
+}
+
+func (b *FakeBackend) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	q := b.scm
+	b.mu.Unlock()
+	q.push(nil)
+	if b.engine != nil {
+		b.notify.close(b.engine)
+		b.engine.close()
+	}
+	return nil
+}
+
+func (b *FakeBackend) notifyQueue() *fakeQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.scm
+}
+
+func (b *FakeBackend) notifyErr() error { return b.notifyError() }
+
+type fakeHandle struct {
+	backend *FakeBackend
+	name    string
+	gen     int  // Connection generation the handle was opened in
+	invalid bool // Set by InvalidateHandles, guarded by backend.mu
+	queue   *fakeQueue
+	notify  notifyWaiter // Used with FakeBackend.engine
+
+	mu            sync.Mutex
+	closed        bool
+	markedDeleted bool
+}
+
+func (h *fakeHandle) deleted(status SERVICE_STATUS_PROCESS) {
+	h.mu.Lock()
+	h.markedDeleted = true
+	h.mu.Unlock()
+	h.queue.push(&ServiceNotify{
+		ServiceStatus:         status,
+		NotificationTriggered: SERVICE_NOTIFY_DELETE_PENDING,
+	})
+}
+
+func (h *fakeHandle) service() (*fakeService, error) {
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if closed || h.invalid || h.gen != h.backend.gen {
+		return nil, errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	svc := h.backend.services[h.name]
+	if svc == nil {
+		return nil, errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE)
+	}
+	return svc, nil
+}
+
+func (h *fakeHandle) Name() string { return h.name }
+
+func (h *fakeHandle) Config() (Config, error) {
+	h.backend.delay()
+	h.backend.mu.Lock()
+	defer h.backend.mu.Unlock()
+	svc, err := h.service()
+	if err != nil {
+		return Config{}, err
+	}
+	return svc.config, nil
+}
+
+func (h *fakeHandle) Config2() (ServiceConfig2, error) {
+	h.backend.delay()
+	h.backend.mu.Lock()
+	defer h.backend.mu.Unlock()
+	svc, err := h.service()
+	if err != nil {
+		return ServiceConfig2{}, err
+	}
+	return svc.config2, nil
+}
+
+func (h *fakeHandle) Query() (SERVICE_STATUS_PROCESS, error) {
+	h.backend.delay()
+	h.backend.mu.Lock()
+	defer h.backend.mu.Unlock()
+	svc, err := h.service()
+	if err != nil {
+		return SERVICE_STATUS_PROCESS{}, err
+	}
+	return svc.status, nil
+}
+
+// Start starts a stopped service, it fails with ERROR_SERVICE_DISABLED if
+// the StartType of the service is SERVICE_DISABLED.
+func (h *fakeHandle) Start(args ...string) error {
+	h.backend.mu.Lock()
+	svc, err := h.service()
+	switch {
+	case err != nil:
+	case svc.config.StartType == SERVICE_DISABLED:
+		err = errno.Errno(errno.ERROR_SERVICE_DISABLED)
+	case svc.status.CurrentState != SERVICE_STOPPED:
+		err = errno.Errno(errno.ERROR_SERVICE_ALREADY_RUNNING)
+	}
+	if err != nil {
+		h.backend.mu.Unlock()
+		return err
+	}
+	status := svc.transition(SERVICE_START_PENDING, SERVICE_RUNNING)
+	h.backend.mu.Unlock()
+	h.backend.SetStatus(h.name, status)
+	return nil
+}
+
+func (h *fakeHandle) Control(c ControlCode) error {
+	h.backend.mu.Lock()
+	svc, err := h.service()
+	if err != nil {
+		h.backend.mu.Unlock()
+		return err
+	}
+	var status SERVICE_STATUS_PROCESS
+	switch c {
+	case SERVICE_CONTROL_STOP:
+		status = svc.transition(SERVICE_STOP_PENDING, SERVICE_STOPPED)
+	case SERVICE_CONTROL_PAUSE:
+		status = svc.transition(SERVICE_PAUSE_PENDING, SERVICE_PAUSED)
+	case SERVICE_CONTROL_CONTINUE:
+		status = svc.transition(SERVICE_CONTINUE_PENDING, SERVICE_RUNNING)
+	case SERVICE_CONTROL_INTERROGATE:
+		h.backend.mu.Unlock()
+		return nil
+	default:
+		h.backend.mu.Unlock()
+		return errno.Errno(errno.ERROR_INVALID_SERVICE_CONTROL)
+	}
+	h.backend.mu.Unlock()
+	h.backend.SetStatus(h.name, status)
+	return nil
+}
+
+func (h *fakeHandle) WaitNotify(mask ServiceNotification, timeout time.Duration) (*ServiceNotify, error) {
+	h.mu.Lock()
+	closed, deleted := h.closed, h.markedDeleted
+	h.mu.Unlock()
+	if closed || h.stale() {
+		return nil, errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	if e := h.backend.engine; e != nil {
+		return h.notify.wait(e, h, mask, timeout)
+	}
+	if err := h.backend.notifyError(); err != nil {
+		return nil, err
+	}
+	n := h.queue.wait(mask, timeout)
+	if n == nil && h.stale() {
+		return nil, errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	if n == nil && deleted {
+		return nil, errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE)
+	}
+	if n == nil {
+		return nil, h.backend.notifyError()
+	}
+	return n, nil
+}
+
+// stale reports whether the handle was invalidated or opened before a
+// Disconnect.
+func (h *fakeHandle) stale() bool {
+	h.backend.mu.Lock()
+	defer h.backend.mu.Unlock()
+	return h.invalid || h.gen != h.backend.gen
+}
+
+func (h *fakeHandle) Close() error {
+	h.backend.mu.Lock()
+	if svc := h.backend.services[h.name]; svc != nil {
+		for i, x := range svc.handles {
+			if x == h {
+				svc.handles = append(svc.handles[:i], svc.handles[i+1:]...)
+				break
+			}
+		}
+	}
+	h.backend.mu.Unlock()
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	h.closed = true
+	h.mu.Unlock()
+	if e := h.backend.engine; e != nil {
+		h.notify.close(e)
+	}
+	h.queue.push(nil) // Wake WaitNotify
+	return nil
+}
+
+func (h *fakeHandle) notifyQueue() *fakeQueue { return h.queue }
+
+func (h *fakeHandle) notifyErr() error {
+	h.mu.Lock()
+	closed := h.closed
+	h.mu.Unlock()
+	if closed || h.stale() {
+		return errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	return h.backend.notifyError()
+}
+
+// fakeQueue is an unbounded queue of notifications, which are either
+// waited for or delivered to a registration armed by a fakeNotifySource.
+type fakeQueue struct {
+	mu   sync.Mutex
+	list []*ServiceNotify
+	wake chan struct{}
+	reg  *notifyRegistration
+	src  *fakeNotifySource
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{wake: make(chan struct{}, 1)}
+}
+
+// push adds n to the queue, a nil n only wakes the waiter.
+func (q *fakeQueue) push(n *ServiceNotify) {
+	if n != nil {
+		q.mu.Lock()
+		q.list = append(q.list, n)
+		q.mu.Unlock()
+	}
+	q.deliver(n == nil)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns the first queued notification matching mask, notifications
+// that do not match are discarded.  Nil is returned if the timeout elapses
+// or the queue was woken without a notification.
+func (q *fakeQueue) wait(mask ServiceNotification, timeout time.Duration) *ServiceNotify {
+	var timer <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		q.mu.Lock()
+		if n := q.pop(mask); n != nil {
+			q.mu.Unlock()
+			return n
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.wake:
+			q.mu.Lock()
+			empty := len(q.list) == 0
+			q.mu.Unlock()
+			if empty {
+				return nil
+			}
+		case <-timer:
+			return nil
+		}
+	}
+}
+
+// pop removes and returns the first queued notification matching mask,
+// notifications that do not match are discarded.  q.mu must be held.
+func (q *fakeQueue) pop(mask ServiceNotification) *ServiceNotify {
+	for len(q.list) != 0 {
+		n := q.list[0]
+		q.list = q.list[1:]
+		if n.NotificationTriggered&mask != 0 {
+			return n
+		}
+	}
+	return nil
+}
+
+// arm registers r for the next notification matching its mask.
+func (q *fakeQueue) arm(r *notifyRegistration, src *fakeNotifySource) {
+	q.mu.Lock()
+	q.reg, q.src = r, src
+	q.mu.Unlock()
+	q.deliver(false)
+}
+
+func (q *fakeQueue) disarm(r *notifyRegistration) {
+	q.mu.Lock()
+	if q.reg == r {
+		q.reg = nil
+	}
+	q.mu.Unlock()
+}
+
+// deliver fires the armed registration with the first matching
+// notification, if check is set and there is none it fires the
+// registration with the error of its target, if any.
+func (q *fakeQueue) deliver(check bool) {
+	q.mu.Lock()
+	r, src := q.reg, q.src
+	if r == nil {
+		q.mu.Unlock()
+		return
+	}
+	if n := q.pop(r.mask); n != nil {
+		q.reg = nil
+		q.mu.Unlock()
+		src.ready(r, n, nil)
+		return
+	}
+	q.mu.Unlock()
+	if !check {
+		return
+	}
+	if err := r.target.(fakeTarget).notifyErr(); err != nil {
+		q.mu.Lock()
+		armed := q.reg == r
+		if armed {
+			q.reg = nil
+		}
+		q.mu.Unlock()
+		if armed {
+			src.ready(r, nil, err)
+		}
+	}
+}
+
+// fakeTarget is the target of the registrations of a fakeNotifySource,
+// either a FakeBackend or a fakeHandle.
+type fakeTarget interface {
+	notifyQueue() *fakeQueue
+	notifyErr() error
+}
+
+// fakeNotifySource is the notifySource of a FakeBackend, notifications are
+// delivered by the goroutine pushing them and fired by wait.
+type fakeNotifySource struct {
+	signal chan struct{}
+
+	mu    sync.Mutex
+	fired []fakeFired
+}
+
+type fakeFired struct {
+	r   *notifyRegistration
+	n   *ServiceNotify
+	err error
+}
+
+func newFakeNotifySource() *fakeNotifySource {
+	return &fakeNotifySource{signal: make(chan struct{}, 1)}
+}
+
+func (s *fakeNotifySource) start() error { return nil }
+
+func (s *fakeNotifySource) stop() {}
+
+func (s *fakeNotifySource) arm(r *notifyRegistration) error {
+	t := r.target.(fakeTarget)
+	if err := t.notifyErr(); err != nil {
+		return err
+	}
+	q := t.notifyQueue()
+	r.sys = q
+	q.arm(r, s)
+	return nil
+}
+
+func (s *fakeNotifySource) disarm(r *notifyRegistration) {
+	if q, ok := r.sys.(*fakeQueue); ok {
+		q.disarm(r)
+	}
+}
+
+// ready queues a notification for the next wait.
+func (s *fakeNotifySource) ready(r *notifyRegistration, n *ServiceNotify, err error) {
+	s.mu.Lock()
+	s.fired = append(s.fired, fakeFired{r: r, n: n, err: err})
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *fakeNotifySource) wait(timeout time.Duration, fire func(*notifyRegistration, *ServiceNotify, error)) {
+	s.mu.Lock()
+	empty := len(s.fired) == 0
+	s.mu.Unlock()
+	if empty {
+		t := time.NewTimer(timeout)
+		select {
+		case <-s.signal:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	s.mu.Lock()
+	fired := s.fired
+	s.fired = nil
+	s.mu.Unlock()
+	for _, f := range fired {
+		fire(f.r, f.n, f.err)
+	}
+}
+
+func (s *fakeNotifySource) wake() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
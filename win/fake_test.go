@@ -0,0 +1,99 @@
+package win
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"monitor/errno"
+)
+
+func running(typ ServiceType) SERVICE_STATUS_PROCESS {
+	return SERVICE_STATUS_PROCESS{
+		ServiceType:  typ,
+		CurrentState: SERVICE_RUNNING,
+	}
+}
+
+var _ = Describe("FakeBackend", func() {
+	var b *FakeBackend
+	BeforeEach(func() {
+		b = NewFakeBackend()
+		b.AddService("b", Config{ServiceType: SERVICE_WIN32_OWN_PROCESS}, running(SERVICE_WIN32_OWN_PROCESS))
+		b.AddService("a", Config{ServiceType: SERVICE_WIN32_OWN_PROCESS}, running(SERVICE_WIN32_OWN_PROCESS))
+		b.AddService("drv", Config{ServiceType: SERVICE_KERNEL_DRIVER}, running(SERVICE_KERNEL_DRIVER))
+	})
+
+	It("lists services of the requested type in name order", func() {
+		procs, err := b.ListServices(SERVICE_WIN32)
+		Expect(err).To(BeNil())
+		Expect(procs).To(HaveLen(2))
+		Expect(procs[0].ServiceName).To(Equal("a"))
+		Expect(procs[1].ServiceName).To(Equal("b"))
+	})
+
+	It("returns ERROR_SERVICE_DOES_NOT_EXIST for unknown services", func() {
+		_, err := b.OpenService("nope")
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST)))
+	})
+
+	It("delivers SCM create and delete notifications", func() {
+		n, err := b.WaitNotify(SERVICE_NOTIFY_CREATED, 0)
+		Expect(err).To(BeNil())
+		Expect(n.ServiceNames).To(Equal([]string{"b"}))
+
+		b.DeleteService("a")
+		n, err = b.WaitNotify(SERVICE_NOTIFY_DELETED, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.ServiceNames).To(Equal([]string{"a"}))
+	})
+
+	It("delivers status notifications to open handles", func() {
+		h, err := b.OpenService("a")
+		Expect(err).To(BeNil())
+		defer h.Close()
+
+		b.SetState("a", SERVICE_STOPPED)
+		n, err := h.WaitNotify(SERVICE_NOTIFY_STOPPED, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.NotificationTriggered).To(Equal(SERVICE_NOTIFY_STOPPED))
+		Expect(n.ServiceStatus.CurrentState).To(Equal(SERVICE_STOPPED))
+
+		st, err := h.Query()
+		Expect(err).To(BeNil())
+		Expect(st.CurrentState).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("times out when there are no notifications", func() {
+		h, err := b.OpenService("a")
+		Expect(err).To(BeNil())
+		defer h.Close()
+
+		n, err := h.WaitNotify(SERVICE_NOTIFY_STOPPED, 10*time.Millisecond)
+		Expect(err).To(BeNil())
+		Expect(n).To(BeNil())
+	})
+
+	It("marks open handles of deleted services", func() {
+		h, err := b.OpenService("a")
+		Expect(err).To(BeNil())
+		defer h.Close()
+
+		b.DeleteService("a")
+		n, err := h.WaitNotify(SERVICE_NOTIFY_DELETE_PENDING, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.NotificationTriggered).To(Equal(SERVICE_NOTIFY_DELETE_PENDING))
+
+		_, err = h.WaitNotify(SERVICE_NOTIFY_STOPPED, 0)
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE)))
+	})
+
+	It("invalidates closed handles", func() {
+		h, err := b.OpenService("a")
+		Expect(err).To(BeNil())
+		Expect(h.Close()).To(Succeed())
+		_, err = h.Query()
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_INVALID_HANDLE)))
+	})
+})
@@ -0,0 +1,178 @@
+package win
+
+import (
+	"errors"
+
+	"monitor/errno"
+)
+
+// ErrUnknownFilter is returned when removing a filter that was not added
+// to the Supervisor.
+var ErrUnknownFilter = errors.New("win: unknown filter")
+
+// A Filter reports whether the service svcName with configuration conf
+// should be monitored.  A Supervisor calls its filters from one goroutine
+// at a time unless WithStartupParallelism allows more than one, filters
+// must then be safe for concurrent use.
+type Filter func(svcName string, conf *Config) bool
+
+// MatchAll returns a Filter that matches services matched by all filters,
+// it matches every service if filters is empty.
+func MatchAll(filters ...Filter) Filter {
+	return func(svcName string, conf *Config) bool {
+		for _, fn := range filters {
+			if !fn(svcName, conf) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny returns a Filter that matches services matched by any of
+// filters, it matches no service if filters is empty.
+func MatchAny(filters ...Filter) Filter {
+	return func(svcName string, conf *Config) bool {
+		for _, fn := range filters {
+			if fn(svcName, conf) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A FilterID identifies a filter added to a Supervisor.
+type FilterID uint64
+
+// InitialFilter is the FilterID of the filter passed to NewSupervisor.
+const InitialFilter FilterID = 0
+
+type filterEntry struct {
+	id     FilterID
+	filter Filter
+}
+
+// AddFilter adds filter to the Supervisor, services that match any of the
+// Supervisor's filters are monitored, like Manager.AddFilters.  Unlike a
+// Manager, a Supervisor without filters monitors no services.  Services
+// that start matching emit a WatchStarted event.
+func (s *Supervisor) AddFilter(filter Filter) (FilterID, error) {
+	s.filterMu.Lock()
+	defer s.filterMu.Unlock()
+	s.mu.Lock()
+	id := s.nextFilterID
+	s.nextFilterID++
+	s.filters = append(s.filters, filterEntry{id, filter})
+	s.mu.Unlock()
+	return id, s.refilter()
+}
+
+// RemoveFilter removes the filter id from the Supervisor.  Services that no
+// longer match any filter stop being monitored and emit a WatchStopped
+// event.
+func (s *Supervisor) RemoveFilter(id FilterID) error {
+	s.filterMu.Lock()
+	defer s.filterMu.Unlock()
+	s.mu.Lock()
+	found := false
+	for i, f := range s.filters {
+		if f.id == id {
+			s.filters = append(s.filters[:i:i], s.filters[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+	if !found {
+		return ErrUnknownFilter
+	}
+	return s.refilter()
+}
+
+// SetFilter replaces all of the Supervisor's filters with filter, see
+// AddFilter and RemoveFilter for the events emitted.
+func (s *Supervisor) SetFilter(filter Filter) (FilterID, error) {
+	s.filterMu.Lock()
+	defer s.filterMu.Unlock()
+	s.mu.Lock()
+	id := s.nextFilterID
+	s.nextFilterID++
+	s.filters = []filterEntry{{id, filter}}
+	s.mu.Unlock()
+	return id, s.refilter()
+}
+
+// match reports whether svcName matches any of the Supervisor's filters.
+func (s *Supervisor) match(svcName string, conf *Config) bool {
+	s.mu.RLock()
+	filters := s.filters
+	s.mu.RUnlock()
+	for _, f := range filters {
+		if f.filter(svcName, conf) {
+			return true
+		}
+	}
+	return false
+}
+
+// refilter re-evaluates the filters for every service, monitoring newly
+// matching services and closing the listeners of services that no longer
+// match.  The first error is returned after all services are evaluated.
+func (s *Supervisor) refilter() error {
+	if s.closed() {
+		return ErrClosed
+	}
+	procs, err := s.backend.ListServices(SERVICE_WIN32)
+	if err != nil {
+		return listenerError("", "EnumServicesStatusEx", err)
+	}
+	var first error
+	for _, p := range procs {
+		s.mu.RLock()
+		l := s.serviceListeners[p.ServiceName]
+		s.mu.RUnlock()
+
+		if l == nil {
+			l, err = s.monitorService(p.ServiceName)
+			if err == nil && l != nil {
+				s.emit(l, WatchStarted, l.Status, l.Status)
+			}
+		} else if h := l.handle(); h != nil { // Nil while reloading
+			var conf Config
+			conf, err = h.Config()
+			err = listenerError(p.ServiceName, "QueryServiceConfig", err)
+			if err == nil {
+				s.mu.Lock()
+				l.Config = conf
+				s.mu.Unlock()
+				if !s.match(p.ServiceName, &conf) {
+					err = s.stopMonitoring(p.ServiceName, WatchStopped)
+				}
+			}
+		}
+		if err != nil && first == nil && !isDeleted(err) {
+			first = err
+		}
+	}
+	return first
+}
+
+// lookupService opens svcName and returns its handle and configuration.
+// A nil handle is returned if access to the service is denied, errors are
+// returned as a *ListenerError.
+func lookupService(b SCMBackend, svcName string) (ServiceHandle, *Config, error) {
+	svc, err := b.OpenService(svcName)
+	if err != nil {
+		if isErrno(err, errno.ERROR_ACCESS_DENIED) {
+			return nil, nil, nil
+		}
+		return nil, nil, listenerError(svcName, "OpenService", err)
+	}
+	conf, err := svc.Config()
+	if err != nil {
+		svc.Close()
+		return nil, nil, listenerError(svcName, "QueryServiceConfig", err)
+	}
+	return svc, &conf, nil
+}
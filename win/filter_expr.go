@@ -0,0 +1,493 @@
+package win
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A FilterSyntaxError is returned by ParseFilter for invalid expressions.
+type FilterSyntaxError struct {
+	Expr string
+	Pos  int // Byte offset of the error in Expr
+	Msg  string
+}
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("win: filter: column %d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseFilter compiles a filter expression into a Filter, for example:
+//
+//	description == "vcap" && start_type in (auto, demand) && name =~ "^garden"
+//
+// A comparison is a field, an operator and a value.  The fields are:
+//
+//	name, display_name, description, account, binary_path, dependencies,
+//	start_type, start_mode and service_type
+//
+// The operators are == and != for equality, =~ and !~ for regular
+// expression matches and in for membership in a parenthesized list of
+// values.  String comparisons ignore case, regular expressions are case
+// sensitive unless they start with (?i).  A dependencies comparison is
+// true if any dependency matches, != and !~ are true if none match.
+//
+// Values are double quoted Go strings, identifiers or unsigned integers.
+// start_type values are boot, system, auto, demand and disabled.
+// start_mode is a string field that is trigger for demand start services
+// that are started by a trigger and the start_type otherwise, see
+// Config.StartMode.
+// service_type values are kernel_driver, file_system_driver, driver,
+// own_process, share_process and win32, a service_type matches if any of
+// the value's bits are set.  The constant names, such as
+// SERVICE_AUTO_START, may also be used.
+//
+// Comparisons are combined with &&, || and !, and grouped with
+// parentheses.  && binds tighter than ||.
+func ParseFilter(expr string) (Filter, error) {
+	p := &filterParser{lex: filterLexer{src: expr}}
+	p.next()
+	fn, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	}
+	return fn, nil
+}
+
+// MustParseFilter is like ParseFilter but panics if expr is invalid.
+func MustParseFilter(expr string) Filter {
+	fn, err := ParseFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return fn
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp // == != =~ !~ && || ! ( ) ,
+)
+
+type token struct {
+	kind tokenKind
+	pos  int
+	text string // Unquoted for tokString
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+func (l *filterLexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r, n := utf8.DecodeRuneInString(l.src[l.pos:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += n
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	rest := l.src[l.pos:]
+	for _, op := range [...]string{"==", "!=", "=~", "!~", "&&", "||"} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			return token{kind: tokOp, pos: start, text: op}, nil
+		}
+	}
+	r, n := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '!' || r == '(' || r == ')' || r == ',':
+		l.pos += n
+		return token{kind: tokOp, pos: start, text: string(r)}, nil
+	case r == '"':
+		return l.lexString()
+	case '0' <= r && r <= '9':
+		for l.pos < len(l.src) && isIdentRune(rune(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokNumber, pos: start, text: l.src[start:l.pos]}, nil
+	case isIdentRune(r):
+		for l.pos < len(l.src) {
+			r, n := utf8.DecodeRuneInString(l.src[l.pos:])
+			if !isIdentRune(r) {
+				break
+			}
+			l.pos += n
+		}
+		return token{kind: tokIdent, pos: start, text: l.src[start:l.pos]}, nil
+	}
+	return token{}, &FilterSyntaxError{Expr: l.src, Pos: start,
+		Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+func (l *filterLexer) lexString() (token, error) {
+	start := l.pos
+	for i := start + 1; i < len(l.src); i++ {
+		switch l.src[i] {
+		case '\\':
+			i++
+		case '"':
+			l.pos = i + 1
+			s, err := strconv.Unquote(l.src[start:l.pos])
+			if err != nil {
+				return token{}, &FilterSyntaxError{Expr: l.src, Pos: start,
+					Msg: "invalid string " + l.src[start:l.pos]}
+			}
+			return token{kind: tokString, pos: start, text: s}, nil
+		}
+	}
+	return token{}, &FilterSyntaxError{Expr: l.src, Pos: start, Msg: "unterminated string"}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type filterParser struct {
+	lex filterLexer
+	tok token
+	err error // Lexer error, reported when the token is consumed
+}
+
+func (p *filterParser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *filterParser) errorf(pos int, format string, args ...interface{}) error {
+	return &FilterSyntaxError{Expr: p.lex.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isOp reports whether the current token is the operator op.
+func (p *filterParser) isOp(op string) bool {
+	return p.err == nil && p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *filterParser) expectOp(op string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.isOp(op) {
+		return p.errorf(p.tok.pos, "expected %q, found %s", op, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	fn, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		fn = MatchAny(fn, rhs)
+	}
+	return fn, p.err
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	fn, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		fn = MatchAll(fn, rhs)
+	}
+	return fn, p.err
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch {
+	case p.isOp("!"):
+		p.next()
+		fn, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(name string, conf *Config) bool { return !fn(name, conf) }, nil
+	case p.isOp("("):
+		p.next()
+		fn, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return fn, p.expectOp(")")
+	case p.tok.kind == tokIdent:
+		return p.parseComparison()
+	case p.tok.kind == tokEOF:
+		return nil, p.errorf(p.tok.pos, "unexpected end of expression")
+	}
+	return nil, p.errorf(p.tok.pos, "expected field name, found %s", p.tok)
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	fieldTok := p.tok
+	f, ok := filterFields[strings.ToLower(fieldTok.text)]
+	if !ok {
+		return nil, p.errorf(fieldTok.pos, "unknown field %s", fieldTok)
+	}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	opTok := p.tok
+	switch {
+	case opTok.kind == tokOp && (opTok.text == "==" || opTok.text == "!="):
+		p.next()
+		v, err := p.parseValue(f)
+		if err != nil {
+			return nil, err
+		}
+		return f.compare([]filterValue{v}, opTok.text == "!="), nil
+	case opTok.kind == tokOp && (opTok.text == "=~" || opTok.text == "!~"):
+		if f.kind != stringField {
+			return nil, p.errorf(opTok.pos, "operator %s is not supported by field %s", opTok.text, fieldTok.text)
+		}
+		p.next()
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.tok.kind != tokString {
+			return nil, p.errorf(p.tok.pos, "expected regular expression string, found %s", p.tok)
+		}
+		re, err := regexp.Compile(p.tok.text)
+		if err != nil {
+			return nil, p.errorf(p.tok.pos, "invalid regular expression: %s", err)
+		}
+		p.next()
+		return f.match(re, opTok.text == "!~"), p.err
+	case opTok.kind == tokIdent && strings.EqualFold(opTok.text, "in"):
+		p.next()
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		var values []filterValue
+		for {
+			v, err := p.parseValue(f)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return f.compare(values, false), nil
+	}
+	return nil, p.errorf(opTok.pos, "expected operator after %s, found %s", fieldTok.text, opTok)
+}
+
+// parseValue parses a value of field f.
+func (p *filterParser) parseValue(f *filterField) (filterValue, error) {
+	if p.err != nil {
+		return filterValue{}, p.err
+	}
+	tok := p.tok
+	if tok.kind != tokIdent && tok.kind != tokString && tok.kind != tokNumber {
+		return filterValue{}, p.errorf(tok.pos, "expected value, found %s", tok)
+	}
+	p.next()
+	if f.kind == stringField {
+		return filterValue{str: tok.text}, nil
+	}
+	if tok.kind == tokNumber {
+		n, err := strconv.ParseUint(tok.text, 0, 32)
+		if err != nil {
+			return filterValue{}, p.errorf(tok.pos, "invalid number %s", tok.text)
+		}
+		return filterValue{num: uint32(n)}, nil
+	}
+	n, ok := f.names[strings.ToLower(tok.text)]
+	if !ok {
+		return filterValue{}, p.errorf(tok.pos, "invalid %s %s", f.name, tok)
+	}
+	return filterValue{num: n}, nil
+}
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	enumField             // Equal values
+	flagField             // Any bit of the value is set
+)
+
+type filterValue struct {
+	str string
+	num uint32
+}
+
+type filterField struct {
+	name    string
+	kind    fieldKind
+	strings func(svcName string, conf *Config) []string
+	number  func(conf *Config) uint32
+	names   map[string]uint32 // Lower case value names of enum and flag fields
+}
+
+// compare returns a Filter that matches if the field equals any of values,
+// the result is inverted if negate is true.
+func (f *filterField) compare(values []filterValue, negate bool) Filter {
+	return func(svcName string, conf *Config) bool {
+		matched := false
+		switch f.kind {
+		case stringField:
+		Strings:
+			for _, s := range f.strings(svcName, conf) {
+				for _, v := range values {
+					if strings.EqualFold(s, v.str) {
+						matched = true
+						break Strings
+					}
+				}
+			}
+		case enumField:
+			n := f.number(conf)
+			for _, v := range values {
+				if n == v.num {
+					matched = true
+					break
+				}
+			}
+		case flagField:
+			n := f.number(conf)
+			for _, v := range values {
+				if n&v.num != 0 {
+					matched = true
+					break
+				}
+			}
+		}
+		return matched != negate
+	}
+}
+
+// match returns a Filter that matches if the field matches re, the result
+// is inverted if negate is true.
+func (f *filterField) match(re *regexp.Regexp, negate bool) Filter {
+	return func(svcName string, conf *Config) bool {
+		for _, s := range f.strings(svcName, conf) {
+			if re.MatchString(s) {
+				return !negate
+			}
+		}
+		return negate
+	}
+}
+
+func stringValue(fn func(svcName string, conf *Config) string) func(string, *Config) []string {
+	return func(svcName string, conf *Config) []string {
+		return []string{fn(svcName, conf)}
+	}
+}
+
+var filterFields = map[string]*filterField{
+	"name": {
+		name:    "name",
+		strings: stringValue(func(svcName string, _ *Config) string { return svcName }),
+	},
+	"display_name": {
+		name:    "display_name",
+		strings: stringValue(func(_ string, c *Config) string { return c.DisplayName }),
+	},
+	"description": {
+		name:    "description",
+		strings: stringValue(func(_ string, c *Config) string { return c.Description }),
+	},
+	"account": {
+		name:    "account",
+		strings: stringValue(func(_ string, c *Config) string { return c.ServiceStartName }),
+	},
+	"binary_path": {
+		name:    "binary_path",
+		strings: stringValue(func(_ string, c *Config) string { return c.BinaryPathName }),
+	},
+	"dependencies": {
+		name:    "dependencies",
+		strings: func(_ string, c *Config) []string { return c.Dependencies },
+	},
+	"start_type": {
+		name:   "start_type",
+		kind:   enumField,
+		number: func(c *Config) uint32 { return uint32(c.StartType) },
+		names: map[string]uint32{
+			"boot":                 uint32(SERVICE_BOOT_START),
+			"system":               uint32(SERVICE_SYSTEM_START),
+			"auto":                 uint32(SERVICE_AUTO_START),
+			"demand":               uint32(SERVICE_DEMAND_START),
+			"disabled":             uint32(SERVICE_DISABLED),
+			"service_boot_start":   uint32(SERVICE_BOOT_START),
+			"service_system_start": uint32(SERVICE_SYSTEM_START),
+			"service_auto_start":   uint32(SERVICE_AUTO_START),
+			"service_demand_start": uint32(SERVICE_DEMAND_START),
+			"service_disabled":     uint32(SERVICE_DISABLED),
+		},
+	},
+	"start_mode": {
+		name:    "start_mode",
+		strings: stringValue(func(_ string, c *Config) string { return c.StartMode() }),
+	},
+	"service_type": {
+		name:   "service_type",
+		kind:   flagField,
+		number: func(c *Config) uint32 { return uint32(c.ServiceType) },
+		names: map[string]uint32{
+			"kernel_driver":               uint32(SERVICE_KERNEL_DRIVER),
+			"file_system_driver":          uint32(SERVICE_FILE_SYSTEM_DRIVER),
+			"driver":                      uint32(SERVICE_DRIVER),
+			"own_process":                 uint32(SERVICE_WIN32_OWN_PROCESS),
+			"share_process":               uint32(SERVICE_WIN32_SHARE_PROCESS),
+			"win32":                       uint32(SERVICE_WIN32),
+			"service_kernel_driver":       uint32(SERVICE_KERNEL_DRIVER),
+			"service_file_system_driver":  uint32(SERVICE_FILE_SYSTEM_DRIVER),
+			"service_driver":              uint32(SERVICE_DRIVER),
+			"service_win32_own_process":   uint32(SERVICE_WIN32_OWN_PROCESS),
+			"service_win32_share_process": uint32(SERVICE_WIN32_SHARE_PROCESS),
+			"service_win32":               uint32(SERVICE_WIN32),
+		},
+	},
+}
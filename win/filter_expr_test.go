@@ -0,0 +1,103 @@
+package win
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseFilter", func() {
+	conf := &Config{
+		ServiceType:      SERVICE_WIN32_OWN_PROCESS,
+		StartType:        SERVICE_AUTO_START,
+		BinaryPathName:   `C:\var\vcap\bosh\bin\garden.exe`,
+		Dependencies:     []string{"Tcpip", "Afd"},
+		ServiceStartName: "LocalSystem",
+		DisplayName:      "Garden Windows",
+		Description:      "vcap",
+	}
+
+	DescribeTable("evaluates expressions",
+		func(expr string, expected bool) {
+			fn, err := ParseFilter(expr)
+			Expect(err).To(BeNil())
+			Expect(fn("garden-windows", conf)).To(Equal(expected))
+		},
+		Entry("name equality", `name == "garden-windows"`, true),
+		Entry("identifier values", `name == garden-windows`, true),
+		Entry("case insensitive equality", `name == "GARDEN-WINDOWS"`, true),
+		Entry("inequality", `name != "garden-windows"`, false),
+		Entry("display name", `display_name == "Garden Windows"`, true),
+		Entry("description", `description == "vcap"`, true),
+		Entry("account", `account == "localsystem"`, true),
+		Entry("binary path regexp", `binary_path =~ "\\\\garden\\.exe$"`, true),
+		Entry("regexp", `name =~ "^garden"`, true),
+		Entry("case sensitive regexp", `name =~ "^GARDEN"`, false),
+		Entry("case insensitive regexp", `name =~ "(?i)^GARDEN"`, true),
+		Entry("negated regexp", `name !~ "^garden"`, false),
+		Entry("any dependency", `dependencies == "afd"`, true),
+		Entry("no dependency", `dependencies != "afd"`, false),
+		Entry("dependency regexp", `dependencies =~ "^Tcp"`, true),
+		Entry("start type", `start_type == auto`, true),
+		Entry("start type constant", `start_type == SERVICE_AUTO_START`, true),
+		Entry("start type number", `start_type == 2`, true),
+		Entry("start type in", `start_type in (demand, disabled)`, false),
+		Entry("service type", `service_type == own_process`, true),
+		Entry("service type bits", `service_type == win32`, true),
+		Entry("service type mismatch", `service_type == driver`, false),
+		Entry("string in", `name in ("a", "garden-windows")`, true),
+		Entry("and", `description == "vcap" && start_type in (auto, demand) && name =~ "^garden"`, true),
+		Entry("or", `name == "a" || name == "garden-windows"`, true),
+		Entry("precedence", `name == "a" && name == "b" || description == "vcap"`, true),
+		Entry("parentheses", `name == "a" && (name == "b" || description == "vcap")`, false),
+		Entry("not", `!(description == "vcap")`, false),
+		Entry("double not", `!!(description == "vcap")`, true),
+		Entry("field case", `NAME == "garden-windows"`, true),
+	)
+
+	It("compiles to a Filter usable by a Supervisor", func() {
+		backend := NewFakeBackend()
+		backend.AddService("garden", Config{Description: "vcap"}, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("other", Config{Description: "other"}, running(SERVICE_WIN32_OWN_PROCESS))
+
+		s, err := startSupervisor(MustParseFilter(`description == "vcap"`), WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("garden"))
+	})
+
+	DescribeTable("reports the position of errors",
+		func(expr string, pos int, msg string) {
+			_, err := ParseFilter(expr)
+			Expect(err).To(HaveOccurred())
+			serr, ok := err.(*FilterSyntaxError)
+			Expect(ok).To(BeTrue())
+			Expect(serr.Pos).To(Equal(pos))
+			Expect(serr.Msg).To(ContainSubstring(msg))
+			Expect(serr.Expr).To(Equal(expr))
+		},
+		Entry("empty", ``, 0, "unexpected end of expression"),
+		Entry("unknown field", `name == "a" && colour == "red"`, 15, "unknown field"),
+		Entry("missing operator", `name "a"`, 5, "expected operator"),
+		Entry("missing value", `name ==`, 7, "expected value"),
+		Entry("unterminated string", `name == "abc`, 8, "unterminated string"),
+		Entry("invalid character", `name == $`, 8, "unexpected character"),
+		Entry("invalid regexp", `name =~ "("`, 8, "invalid regular expression"),
+		Entry("regexp on enum", `start_type =~ "auto"`, 11, "not supported"),
+		Entry("unknown enum value", `start_type == sometimes`, 14, "invalid start_type"),
+		Entry("invalid number", `start_type == 99999999999`, 14, "invalid number"),
+		Entry("unclosed paren", `(name == "a"`, 12, `expected ")"`),
+		Entry("unclosed list", `name in ("a", "b"`, 17, `expected ")"`),
+		Entry("trailing tokens", `name == "a" name`, 12, "unexpected"),
+		Entry("dangling and", `name == "a" &&`, 14, "unexpected end of expression"),
+	)
+
+	It("formats errors with a column", func() {
+		_, err := ParseFilter(`name == $`)
+		Expect(err.Error()).To(Equal(`win: filter: column 9: unexpected character '$'`))
+	})
+
+	It("panics in MustParseFilter for invalid expressions", func() {
+		Expect(func() { MustParseFilter(`name ==`) }).To(Panic())
+	})
+})
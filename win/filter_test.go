@@ -0,0 +1,138 @@
+package win
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	yes := func(string, *Config) bool { return true }
+	no := func(string, *Config) bool { return false }
+
+	It("combines filters with MatchAll", func() {
+		Expect(MatchAll()("svc", &Config{})).To(BeTrue())
+		Expect(MatchAll(yes, yes)("svc", &Config{})).To(BeTrue())
+		Expect(MatchAll(yes, no)("svc", &Config{})).To(BeFalse())
+	})
+
+	It("combines filters with MatchAny", func() {
+		Expect(MatchAny()("svc", &Config{})).To(BeFalse())
+		Expect(MatchAny(no, yes)("svc", &Config{})).To(BeTrue())
+		Expect(MatchAny(no, no)("svc", &Config{})).To(BeFalse())
+	})
+})
+
+var _ = Describe("Supervisor filters", func() {
+	var (
+		backend *FakeBackend
+		s       *Supervisor
+		vcap    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+		other   = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+	)
+
+	description := func(d string) Filter {
+		return func(_ string, c *Config) bool { return c.Description == d }
+	}
+
+	nextEvent := func() Event {
+		var e Event
+		Eventually(s.Events()).Should(Receive(&e))
+		return e
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", other, running(SERVICE_WIN32_OWN_PROCESS))
+
+		var err error
+		s, err = startSupervisor(description("vcap"), WithBackend(backend))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		s.Close()
+	})
+
+	It("starts watching services matching an added filter", func() {
+		id, err := s.AddFilter(description("other"))
+		Expect(err).To(BeNil())
+		Expect(id).NotTo(Equal(InitialFilter))
+		Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-2"))
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(WatchStarted))
+		Expect(e.Service).To(Equal("svc-2"))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_RUNNING))
+
+		backend.SetState("svc-2", SERVICE_STOPPED)
+		e = nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-2"))
+	})
+
+	It("stops watching services that no longer match", func() {
+		Expect(s.RemoveFilter(InitialFilter)).To(Succeed())
+		Expect(s.Services()).To(BeEmpty())
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(WatchStopped))
+		Expect(e.Service).To(Equal("svc-1"))
+
+		backend.mu.Lock()
+		Expect(backend.services["svc-1"].handles).To(BeEmpty())
+		backend.mu.Unlock()
+
+		backend.AddService("svc-3", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("records the current configuration of monitored services", func() {
+		backend.SetConfig("svc-1", Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap",
+			StartType: SERVICE_AUTO_START})
+		_, err := s.AddFilter(description("none"))
+		Expect(err).To(BeNil())
+		Expect(s.Services()[0].Config.StartType).To(Equal(SERVICE_AUTO_START))
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		Eventually(s.Unhealthy).Should(HaveLen(1))
+	})
+
+	It("replaces all filters with SetFilter", func() {
+		_, err := s.SetFilter(description("other"))
+		Expect(err).To(BeNil())
+		Expect(serviceNames(s)).To(ConsistOf("svc-2"))
+
+		var types []string
+		for i := 0; i < 2; i++ {
+			e := nextEvent()
+			types = append(types, e.Service+":"+e.Type.String())
+		}
+		Expect(types).To(ConsistOf("svc-1:WatchStopped", "svc-2:WatchStarted"))
+	})
+
+	It("does not emit events for unchanged services", func() {
+		_, err := s.AddFilter(description("vcap"))
+		Expect(err).To(BeNil())
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("applies the current filters to created services", func() {
+		id, err := s.AddFilter(description("new"))
+		Expect(err).To(BeNil())
+		Expect(s.RemoveFilter(id)).To(Succeed())
+
+		backend.AddService("svc-3", Config{Description: "new"}, running(SERVICE_WIN32_OWN_PROCESS))
+		Consistently(func() []string { return serviceNames(s) }, 50*time.Millisecond).
+			Should(ConsistOf("svc-1"))
+	})
+
+	It("returns ErrUnknownFilter for unknown filters", func() {
+		Expect(s.RemoveFilter(42)).To(Equal(ErrUnknownFilter))
+		Expect(s.RemoveFilter(InitialFilter)).To(Succeed())
+		Expect(s.RemoveFilter(InitialFilter)).To(Equal(ErrUnknownFilter))
+	})
+})
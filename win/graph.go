@@ -0,0 +1,479 @@
+package win
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"monitor/errno"
+)
+
+// SC_GROUP_IDENTIFIER prefixes the load-order groups in the Dependencies of
+// a service.
+const SC_GROUP_IDENTIFIER = '+'
+
+// A DependencyGraph is the graph of the dependencies between services and
+// the load-order groups they depend on.  Groups are named with their
+// SC_GROUP_IDENTIFIER prefix, for example +NetworkProvider, and depend on
+// their member services.  Names are matched ignoring case, like the
+// Service Control Manager does, and are returned as first seen.
+type DependencyGraph struct {
+	nodes map[string]*graphNode // Keyed by lower case name
+	keys  []string              // Sorted keys of nodes
+}
+
+type graphNode struct {
+	name       string
+	group      bool
+	missing    bool     // Depended on, but not enumerated
+	unknown    bool     // Enumerated, but its configuration could not be read
+	deps       []string // Keys of the nodes this node depends on
+	dependents []string // Keys of the nodes that depend on this node
+}
+
+// NewDependencyGraph returns the dependency graph of services.  Only the
+// groups that a service depends on are part of the graph, services and
+// groups that are depended on but not in services are included as
+// missing.
+func NewDependencyGraph(services []Service) *DependencyGraph {
+	return newDependencyGraph(services, nil)
+}
+
+// newDependencyGraph is like NewDependencyGraph, the services named in
+// unknown are added without dependencies or group.
+func newDependencyGraph(services []Service, unknown []string) *DependencyGraph {
+	g := &DependencyGraph{nodes: make(map[string]*graphNode)}
+	for i := range services {
+		g.node(services[i].Name).missing = false
+	}
+	for _, name := range unknown {
+		n := g.node(name)
+		n.missing, n.unknown = false, true
+	}
+	for i := range services {
+		s := &services[i]
+		for _, dep := range s.Config.Dependencies {
+			if dep != "" && dep != string(SC_GROUP_IDENTIFIER) {
+				g.addEdge(s.Name, dep)
+			}
+		}
+	}
+	for i := range services {
+		s := &services[i]
+		if s.Config.LoadOrderGroup == "" {
+			continue
+		}
+		group := string(SC_GROUP_IDENTIFIER) + s.Config.LoadOrderGroup
+		if n := g.nodes[strings.ToLower(group)]; n != nil {
+			n.missing = false
+			g.addEdge(group, s.Name)
+		}
+	}
+	for k, n := range g.nodes {
+		g.keys = append(g.keys, k)
+		sort.Strings(n.deps)
+		sort.Strings(n.dependents)
+	}
+	sort.Strings(g.keys)
+	return g
+}
+
+// BuildDependencyGraph returns the dependency graph of all of the services
+// enumerated by b.  Services deleted after the enumeration are not part of
+// the graph.  Services whose configuration cannot be read, for example
+// because access is denied, are Unknown.  The errors of those other than
+// access denied are joined and returned with the graph, which is nil only
+// if the enumeration fails.
+func BuildDependencyGraph(b SCMBackend) (*DependencyGraph, error) {
+	procs, err := b.ListServices(SERVICE_WIN32)
+	if err != nil {
+		return nil, listenerError("", "EnumServicesStatusEx", err)
+	}
+	services := make([]Service, 0, len(procs))
+	var (
+		unknown []string
+		errs    []error
+	)
+	for _, p := range procs {
+		svc, conf, err := lookupService(b, p.ServiceName)
+		switch {
+		case isDeleted(err):
+			continue
+		case err != nil:
+			if !isErrno(err, errno.ERROR_ACCESS_DENIED) {
+				errs = append(errs, err)
+			}
+			fallthrough
+		case svc == nil: // Access denied
+			unknown = append(unknown, p.ServiceName)
+			continue
+		}
+		svc.Close()
+		services = append(services, Service{
+			Name:        p.ServiceName,
+			DisplayName: p.DisplayName,
+			Config:      *conf,
+			Status:      p.ServiceStatusProcess,
+		})
+	}
+	return newDependencyGraph(services, unknown), errors.Join(errs...)
+}
+
+// node returns the node named name, which is added as missing if it is not
+// in the graph.
+func (g *DependencyGraph) node(name string) *graphNode {
+	k := strings.ToLower(name)
+	n := g.nodes[k]
+	if n == nil {
+		n = &graphNode{
+			name:    name,
+			group:   strings.HasPrefix(name, string(SC_GROUP_IDENTIFIER)),
+			missing: true,
+		}
+		g.nodes[k] = n
+	}
+	return n
+}
+
+func (g *DependencyGraph) addEdge(from, to string) {
+	f, t := g.node(from), g.node(to)
+	fk, tk := strings.ToLower(from), strings.ToLower(to)
+	for _, k := range f.deps {
+		if k == tk {
+			return
+		}
+	}
+	f.deps = append(f.deps, tk)
+	t.dependents = append(t.dependents, fk)
+}
+
+func (g *DependencyGraph) names(keys []string) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = g.nodes[k].name
+	}
+	return names
+}
+
+// Nodes returns the names of the services and groups in the graph, ordered
+// by name.
+func (g *DependencyGraph) Nodes() []string { return g.names(g.keys) }
+
+// Contains reports whether name is a service or group in the graph.
+func (g *DependencyGraph) Contains(name string) bool {
+	return g.nodes[strings.ToLower(name)] != nil
+}
+
+// Missing reports whether name is depended on but was not enumerated, or
+// is a group without members.
+func (g *DependencyGraph) Missing(name string) bool {
+	n := g.nodes[strings.ToLower(name)]
+	return n != nil && n.missing
+}
+
+// Unknown reports whether name is a service whose configuration could not
+// be read, its dependencies and group are unknown.
+func (g *DependencyGraph) Unknown(name string) bool {
+	n := g.nodes[strings.ToLower(name)]
+	return n != nil && n.unknown
+}
+
+// Dependencies returns the services and groups that name directly depends
+// on, ordered by name.  The dependencies of a group are its members.
+func (g *DependencyGraph) Dependencies(name string) []string {
+	if n := g.nodes[strings.ToLower(name)]; n != nil {
+		return g.names(n.deps)
+	}
+	return nil
+}
+
+// Dependents returns the services and groups that directly depend on name,
+// ordered by name.
+func (g *DependencyGraph) Dependents(name string) []string {
+	if n := g.nodes[strings.ToLower(name)]; n != nil {
+		return g.names(n.dependents)
+	}
+	return nil
+}
+
+// AllDependencies returns the services and groups that name depends on
+// directly or indirectly, ordered by name.
+func (g *DependencyGraph) AllDependencies(name string) []string {
+	return g.names(g.reachable(name, func(n *graphNode) []string { return n.deps }))
+}
+
+// AllDependents returns the services and groups that depend on name
+// directly or indirectly, ordered by name.
+func (g *DependencyGraph) AllDependents(name string) []string {
+	return g.names(g.reachable(name, func(n *graphNode) []string { return n.dependents }))
+}
+
+// reachable returns the sorted keys of the nodes reachable from name
+// through edges, excluding name unless it is part of a cycle.
+func (g *DependencyGraph) reachable(name string, edges func(*graphNode) []string) []string {
+	n := g.nodes[strings.ToLower(name)]
+	if n == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	stack := append([]string(nil), edges(n)...)
+	for len(stack) != 0 {
+		k := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		stack = append(stack, edges(g.nodes[k])...)
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Impact returns the services that stop working if the services in names
+// stop, ordered by name: the services that depend on a stopped service or
+// on a group whose members are all stopped, directly or indirectly.  The
+// services in names are not included.
+func (g *DependencyGraph) Impact(names ...string) []string {
+	stopped := make(map[string]bool)
+	for _, name := range names {
+		if k := strings.ToLower(name); g.nodes[k] != nil {
+			stopped[k] = true
+		}
+	}
+	impacted := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, k := range g.keys {
+			n := g.nodes[k]
+			if stopped[k] || impacted[k] || len(n.deps) == 0 {
+				continue
+			}
+			// A service breaks if any of its dependencies does, a
+			// group if all of its members do.
+			down := 0
+			for _, d := range n.deps {
+				if stopped[d] || impacted[d] {
+					down++
+				}
+			}
+			if down != 0 && (!n.group || down == len(n.deps)) {
+				impacted[k] = true
+				changed = true
+			}
+		}
+	}
+	var keys []string
+	for _, k := range g.keys {
+		if impacted[k] && !g.nodes[k].group {
+			keys = append(keys, k)
+		}
+	}
+	return g.names(keys)
+}
+
+// StartOrder returns names ordered so that every service comes after the
+// services in names that it depends on, directly or through other services
+// and groups.  Services that do not depend on each other are ordered by
+// name.  An error naming the services that cannot be ordered is returned
+// if some of names depend on each other in a cycle.
+func (g *DependencyGraph) StartOrder(names ...string) ([]string, error) {
+	set := make(map[string]string, len(names)) // Key to name
+	for _, name := range names {
+		k := strings.ToLower(name)
+		if n := g.nodes[k]; n != nil {
+			name = n.name
+		}
+		set[k] = name
+	}
+	pending := make(map[string]int, len(set)) // Number of dependencies not yet ordered
+	dependents := make(map[string][]string, len(set))
+	for k := range set {
+		if g.nodes[k] == nil {
+			continue
+		}
+		for _, d := range g.reachable(k, func(n *graphNode) []string { return n.deps }) {
+			if _, ok := set[d]; ok && d != k {
+				pending[k]++
+				dependents[d] = append(dependents[d], k)
+			}
+		}
+	}
+	var ready, order []string
+	for k := range set {
+		if pending[k] == 0 {
+			ready = append(ready, k)
+		}
+	}
+	for len(ready) != 0 {
+		sort.Strings(ready)
+		k := ready[0]
+		ready = ready[1:]
+		order = append(order, set[k])
+		for _, d := range dependents[k] {
+			if pending[d]--; pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(order) != len(set) {
+		var cycle []string
+		for k, n := range pending {
+			if n != 0 {
+				cycle = append(cycle, set[k])
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("win: dependency cycle: cannot order %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+// StopOrder returns names ordered so that every service comes before the
+// services in names that it depends on, the reverse of StartOrder.
+func (g *DependencyGraph) StopOrder(names ...string) ([]string, error) {
+	order, err := g.StartOrder(names...)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
+
+// Cycles returns the dependency cycles of the graph, each is ordered by
+// name and the cycles are ordered by their first name.  The Service
+// Control Manager fails to start the services of a cycle.
+func (g *DependencyGraph) Cycles() [][]string {
+	// Tarjan's strongly connected components algorithm.
+	var (
+		index   = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		cycles  [][]string
+		visit   func(k string)
+	)
+	visit = func(k string) {
+		index[k] = len(index)
+		lowlink[k] = index[k]
+		stack = append(stack, k)
+		onStack[k] = true
+		selfLoop := false
+		for _, d := range g.nodes[k].deps {
+			if d == k {
+				selfLoop = true
+			}
+			if _, ok := index[d]; !ok {
+				visit(d)
+				if lowlink[d] < lowlink[k] {
+					lowlink[k] = lowlink[d]
+				}
+			} else if onStack[d] && index[d] < lowlink[k] {
+				lowlink[k] = index[d]
+			}
+		}
+		if lowlink[k] != index[k] {
+			return
+		}
+		var scc []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc = append(scc, top)
+			if top == k {
+				break
+			}
+		}
+		if len(scc) > 1 || selfLoop {
+			sort.Strings(scc)
+			cycles = append(cycles, g.names(scc))
+		}
+	}
+	for _, k := range g.keys {
+		if _, ok := index[k]; !ok {
+			visit(k)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return strings.ToLower(cycles[i][0]) < strings.ToLower(cycles[j][0])
+	})
+	return cycles
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language, an edge points
+// from a service to its dependency.  Groups are drawn as boxes and missing
+// services and groups are dashed.
+func (g *DependencyGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph dependencies {\n")
+	for _, k := range g.keys {
+		n := g.nodes[k]
+		var attrs []string
+		if n.group {
+			attrs = append(attrs, "shape=box")
+		}
+		if n.missing {
+			attrs = append(attrs, "style=dashed")
+		}
+		bw.WriteString("\t" + dotQuote(n.name))
+		if len(attrs) != 0 {
+			bw.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+		}
+		bw.WriteString(";\n")
+	}
+	for _, k := range g.keys {
+		n := g.nodes[k]
+		for _, d := range n.deps {
+			bw.WriteString("\t" + dotQuote(n.name) + " -> " + dotQuote(g.nodes[d].name) + ";\n")
+		}
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart, see WriteDOT.  The
+// nodes are identified by their index in Nodes.
+func (g *DependencyGraph) WriteMermaid(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("flowchart LR\n")
+	ids := make(map[string]string, len(g.keys))
+	for i, k := range g.keys {
+		n := g.nodes[k]
+		id := "n" + strconv.Itoa(i)
+		ids[k] = id
+		label := `"` + strings.ReplaceAll(n.name, `"`, "#quot;") + `"`
+		if n.group {
+			bw.WriteString("\t" + id + "[" + label + "]\n")
+		} else {
+			bw.WriteString("\t" + id + "(" + label + ")\n")
+		}
+		if n.missing {
+			bw.WriteString("\tstyle " + id + " stroke-dasharray: 5 5\n")
+		}
+	}
+	for _, k := range g.keys {
+		for _, d := range g.nodes[k].deps {
+			bw.WriteString("\t" + ids[k] + " --> " + ids[d] + "\n")
+		}
+	}
+	return bw.Flush()
+}
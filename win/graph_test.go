@@ -0,0 +1,145 @@
+package win
+
+import (
+	"bytes"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DependencyGraph", func() {
+	var graph *DependencyGraph
+
+	BeforeEach(func() {
+		backend := NewFakeBackend()
+		stopped := SERVICE_STATUS_PROCESS{ServiceType: SERVICE_WIN32_OWN_PROCESS, CurrentState: SERVICE_STOPPED}
+		for name, conf := range map[string]Config{
+			"Tcpip":             {LoadOrderGroup: "PNP_TDI"},
+			"Afd":               {LoadOrderGroup: "pnp_tdi"},
+			"NetBT":             {Dependencies: []string{"+PNP_TDI", "Tcpip"}},
+			"LanmanWorkstation": {Dependencies: []string{"NetBT", "Bowser"}},
+			"vcap-a":            {Dependencies: []string{"vcap-b"}},
+			"vcap-b":            {Dependencies: []string{"vcap-c"}},
+			"vcap-c":            {Dependencies: []string{"VCAP-A"}},
+			"solo":              {LoadOrderGroup: "Unused"},
+		} {
+			backend.AddService(name, conf, stopped)
+		}
+		var err error
+		graph, err = BuildDependencyGraph(backend)
+		Expect(err).To(BeNil())
+	})
+
+	It("includes services, the groups they depend on and missing services", func() {
+		Expect(graph.Nodes()).To(Equal([]string{"+PNP_TDI", "Afd", "Bowser", "LanmanWorkstation",
+			"NetBT", "solo", "Tcpip", "vcap-a", "vcap-b", "vcap-c"}))
+		Expect(graph.Contains("netbt")).To(BeTrue())
+		Expect(graph.Contains("+Unused")).To(BeFalse())
+		Expect(graph.Missing("Bowser")).To(BeTrue())
+		Expect(graph.Missing("+PNP_TDI")).To(BeFalse())
+		Expect(graph.Missing("NetBT")).To(BeFalse())
+	})
+
+	It("skips deleted services and keeps those that cannot be read as unknown", func() {
+		backend := NewFakeBackend()
+		stopped := SERVICE_STATUS_PROCESS{ServiceType: SERVICE_WIN32_OWN_PROCESS, CurrentState: SERVICE_STOPPED}
+		backend.AddService("a", Config{Dependencies: []string{"b", "c"}}, stopped)
+		backend.AddService("b", Config{}, stopped)
+		backend.AddService("c", Config{}, stopped)
+		backend.AddService("d", Config{}, stopped)
+		backend.AddService("e", Config{}, stopped)
+		backend.SetOpenError("b", errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST))
+		backend.SetOpenError("c", errno.Errno(errno.ERROR_ACCESS_DENIED))
+		backend.SetOpenError("d", errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE))
+
+		graph, err := BuildDependencyGraph(backend)
+		Expect(err).To(BeNil())
+		Expect(graph.Nodes()).To(Equal([]string{"a", "b", "c", "e"}))
+		Expect(graph.Missing("b")).To(BeTrue())
+		Expect(graph.Unknown("c")).To(BeTrue())
+		Expect(graph.Missing("c")).To(BeFalse())
+		Expect(graph.Unknown("a")).To(BeFalse())
+		Expect(graph.Dependencies("a")).To(Equal([]string{"b", "c"}))
+
+		backend.SetOpenError("e", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		graph, err = BuildDependencyGraph(backend)
+		Expect(isErrno(err, errno.ERROR_NOT_ENOUGH_MEMORY)).To(BeTrue())
+		Expect(graph.Unknown("e")).To(BeTrue())
+		Expect(graph.Dependencies("a")).To(Equal([]string{"b", "c"}))
+	})
+
+	It("returns direct dependencies and dependents", func() {
+		Expect(graph.Dependencies("NetBT")).To(Equal([]string{"+PNP_TDI", "Tcpip"}))
+		Expect(graph.Dependencies("+pnp_tdi")).To(Equal([]string{"Afd", "Tcpip"}))
+		Expect(graph.Dependents("tcpip")).To(Equal([]string{"+PNP_TDI", "NetBT"}))
+		Expect(graph.Dependents("LanmanWorkstation")).To(BeNil())
+		Expect(graph.Dependencies("unknown")).To(BeNil())
+	})
+
+	It("returns transitive dependencies and dependents", func() {
+		Expect(graph.AllDependencies("LanmanWorkstation")).To(Equal([]string{
+			"+PNP_TDI", "Afd", "Bowser", "NetBT", "Tcpip"}))
+		Expect(graph.AllDependents("Afd")).To(Equal([]string{"+PNP_TDI", "LanmanWorkstation", "NetBT"}))
+		Expect(graph.AllDependents("vcap-a")).To(Equal([]string{"vcap-a", "vcap-b", "vcap-c"}))
+	})
+
+	It("finds cycles", func() {
+		Expect(graph.Cycles()).To(Equal([][]string{{"vcap-a", "vcap-b", "vcap-c"}}))
+
+		graph = NewDependencyGraph([]Service{
+			{Name: "self", Config: Config{Dependencies: []string{"self"}}},
+			{Name: "grouped", Config: Config{LoadOrderGroup: "g", Dependencies: []string{"+g"}}},
+		})
+		Expect(graph.Cycles()).To(Equal([][]string{{"+g", "grouped"}, {"self"}}))
+	})
+
+	It("reports what breaks if services stop", func() {
+		Expect(graph.Impact("Tcpip")).To(Equal([]string{"LanmanWorkstation", "NetBT"}))
+		Expect(graph.Impact("Afd")).To(BeNil(), "Tcpip is still running in the group")
+		Expect(graph.Impact("afd", "tcpip")).To(Equal([]string{"LanmanWorkstation", "NetBT"}))
+		Expect(graph.Impact("Bowser")).To(Equal([]string{"LanmanWorkstation"}))
+		Expect(graph.Impact("vcap-b")).To(Equal([]string{"vcap-a", "vcap-c"}))
+		Expect(graph.Impact("solo", "unknown")).To(BeNil())
+	})
+
+	Context("exporting", func() {
+		BeforeEach(func() {
+			graph = NewDependencyGraph([]Service{
+				{Name: "a", Config: Config{Dependencies: []string{"+g", `x"y`}}},
+				{Name: "b", Config: Config{LoadOrderGroup: "g"}},
+			})
+		})
+
+		It("writes DOT", func() {
+			var buf bytes.Buffer
+			Expect(graph.WriteDOT(&buf)).To(Succeed())
+			Expect(buf.String()).To(Equal(`digraph dependencies {
+	"+g" [shape=box];
+	"a";
+	"b";
+	"x\"y" [style=dashed];
+	"+g" -> "b";
+	"a" -> "+g";
+	"a" -> "x\"y";
+}
+`))
+		})
+
+		It("writes Mermaid", func() {
+			var buf bytes.Buffer
+			Expect(graph.WriteMermaid(&buf)).To(Succeed())
+			Expect(buf.String()).To(Equal(`flowchart LR
+	n0["+g"]
+	n1("a")
+	n2("b")
+	n3("x#quot;y")
+	style n3 stroke-dasharray: 5 5
+	n0 --> n2
+	n1 --> n0
+	n1 --> n3
+`))
+		})
+	})
+})
@@ -0,0 +1,198 @@
+package win
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GroupOptions configures StartGroup and StopGroup.
+type GroupOptions struct {
+	// PollInterval is the shortest interval between queries of a service
+	// that is waited for, one second if zero.  A tenth of the service's
+	// WaitHint is used if it is longer.
+	PollInterval time.Duration
+
+	// NoRollback leaves the services that were started or stopped as they
+	// are when the group fails to start or stop.
+	NoRollback bool
+
+	// Changed, if not nil, is called when a service reaches the state it
+	// is waited for, including when rolling back.
+	Changed func(svcName string, state ServiceState)
+}
+
+func (o *GroupOptions) pollInterval() time.Duration {
+	if o == nil || o.PollInterval <= 0 {
+		return time.Second
+	}
+	return o.PollInterval
+}
+
+func (o *GroupOptions) changed(svcName string, state ServiceState) {
+	if o != nil && o.Changed != nil {
+		o.Changed(svcName, state)
+	}
+}
+
+// A GroupError is returned by StartGroup and StopGroup when a service fails
+// to start or stop.  Err is the error of Service, RolledBack the services
+// whose state was restored and RollbackErr the errors of those that could
+// not be restored.
+type GroupError struct {
+	Op          string // "start" or "stop"
+	Service     string
+	Err         error
+	RolledBack  []string
+	RollbackErr error
+}
+
+func (e *GroupError) Error() string {
+	msg := "win: " + e.Op + " group: " + e.Service + ": " + e.Err.Error()
+	if e.RollbackErr != nil {
+		msg += " (rollback: " + strings.ReplaceAll(e.RollbackErr.Error(), "\n", "; ") + ")"
+	}
+	return msg
+}
+
+func (e *GroupError) Unwrap() error { return e.Err }
+
+// GroupMembers returns the names of the services enumerated by b that match
+// filter.
+func GroupMembers(b SCMBackend, filter Filter) ([]string, error) {
+	services, err := ServiceStatuses(b, StatusOptions{Filter: filter})
+	names := make([]string, len(services))
+	for i, s := range services {
+		names[i] = s.Name
+	}
+	return names, err
+}
+
+// StartGroup starts the services in names, each after the services in
+// names it depends on, and waits for each to be running before starting
+// the next.  Services that are already running are skipped.  If a service
+// fails to start, the services started before it are stopped again unless
+// opts.NoRollback is set, and a *GroupError is returned.  Services deleted
+// while the dependency graph is built are ignored, see BuildDependencyGraph.
+// It fails before changing any service if the dependencies of one of names
+// are Unknown.  If ctx is done, the service being started fails and the
+// group is rolled back.
+func StartGroup(ctx context.Context, b SCMBackend, names []string, opts *GroupOptions) error {
+	order, err := groupOrder(b, "start", names, (*DependencyGraph).StartOrder)
+	if err != nil {
+		return err
+	}
+	return changeGroup(ctx, b, "start", order, opts, startService, stopService)
+}
+
+// StopGroup stops the services in names, each before the services in
+// names it depends on, and waits for each to be stopped before stopping
+// the next.  Services that are already stopped are skipped.  If a service
+// fails to stop, the services stopped before it are started again unless
+// opts.NoRollback is set, and a *GroupError is returned.  Services deleted
+// while the dependency graph is built are ignored, see BuildDependencyGraph.
+// It fails before changing any service if the dependencies of one of names
+// are Unknown.  If ctx is done, the service being stopped fails and the
+// group is rolled back.
+func StopGroup(ctx context.Context, b SCMBackend, names []string, opts *GroupOptions) error {
+	order, err := groupOrder(b, "stop", names, (*DependencyGraph).StopOrder)
+	if err != nil {
+		return err
+	}
+	return changeGroup(ctx, b, "stop", order, opts, stopService, startService)
+}
+
+// groupOrder orders names with order in the dependency graph of b.  The
+// configuration errors of services that are not in names are ignored.
+func groupOrder(b SCMBackend, op string, names []string,
+	order func(*DependencyGraph, ...string) ([]string, error)) ([]string, error) {
+	graph, err := BuildDependencyGraph(b)
+	if graph == nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if graph.Unknown(name) {
+			return nil, errors.Join(fmt.Errorf("win: %s group: dependencies of %s are unknown", op, name), err)
+		}
+	}
+	return order(graph, names...)
+}
+
+// A groupAction changes the state of a service and waits for it, changed
+// is false if the service already was in that state.
+type groupAction func(ctx context.Context, b SCMBackend, svcName string, opts *GroupOptions) (changed bool, err error)
+
+// changeGroup applies action to the services in order, if it fails undo is
+// applied to the services changed so far in reverse order, including the
+// service that failed if it was changed but did not reach its state.  undo
+// is not cancelled with ctx.
+func changeGroup(ctx context.Context, b SCMBackend, op string, order []string, opts *GroupOptions, action, undo groupAction) error {
+	var done []string
+	for _, name := range order {
+		changed, err := false, ctx.Err()
+		if err == nil {
+			changed, err = action(ctx, b, name, opts)
+		}
+		if changed {
+			done = append(done, name)
+		}
+		if err == nil {
+			continue
+		}
+		gerr := &GroupError{Op: op, Service: name, Err: err}
+		if opts != nil && opts.NoRollback {
+			return gerr
+		}
+		ctx := context.WithoutCancel(ctx)
+		var errs []error
+		for i := len(done) - 1; i >= 0; i-- {
+			if _, err := undo(ctx, b, done[i], opts); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			gerr.RolledBack = append(gerr.RolledBack, done[i])
+		}
+		gerr.RollbackErr = errors.Join(errs...)
+		return gerr
+	}
+	return nil
+}
+
+func startService(ctx context.Context, b SCMBackend, svcName string, opts *GroupOptions) (bool, error) {
+	return changeService(ctx, b, svcName, SERVICE_RUNNING, SERVICE_START_PENDING, opts, Start)
+}
+
+func stopService(ctx context.Context, b SCMBackend, svcName string, opts *GroupOptions) (bool, error) {
+	return changeService(ctx, b, svcName, SERVICE_STOPPED, SERVICE_STOP_PENDING, opts, Stop)
+}
+
+// changeService changes svcName to state with fn, unless the service
+// already is in state.  changed reports whether the service was changed
+// by fn, it is false if the service already was pending or if fn failed
+// before the service left its state.
+func changeService(ctx context.Context, b SCMBackend, svcName string, state, pending ServiceState, opts *GroupOptions,
+	fn func(context.Context, SCMBackend, string, *ControlOptions) error) (bool, error) {
+	h, err := b.OpenService(svcName)
+	if err != nil {
+		return false, listenerError(svcName, "OpenService", err)
+	}
+	status, err := h.Query()
+	h.Close()
+	if err != nil {
+		return false, listenerError(svcName, "QueryServiceStatusEx", err)
+	}
+	before := status.CurrentState
+	if before == state {
+		return false, nil
+	}
+	err = fn(ctx, b, svcName, &ControlOptions{PollInterval: opts.pollInterval()})
+	if err != nil {
+		var cerr *ControlError
+		changed := before != pending && errors.As(err, &cerr) && cerr.Status.CurrentState != before
+		return changed, err
+	}
+	opts.changed(svcName, state)
+	return before != pending, nil
+}
@@ -0,0 +1,245 @@
+package win
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Service groups", func() {
+	var (
+		backend *FakeBackend
+		opts    *GroupOptions
+		mu      sync.Mutex
+		changes []string
+	)
+	ctx := context.Background()
+
+	group := []string{"vcap-web", "vcap-db", "vcap-api"}
+
+	state := func(name string) ServiceState {
+		h, err := backend.OpenService(name)
+		Expect(err).To(BeNil())
+		defer h.Close()
+		status, err := h.Query()
+		Expect(err).To(BeNil())
+		return status.CurrentState
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		stopped := SERVICE_STATUS_PROCESS{ServiceType: SERVICE_WIN32_OWN_PROCESS, CurrentState: SERVICE_STOPPED}
+		backend.AddService("vcap-db", Config{StartType: SERVICE_DEMAND_START}, stopped)
+		backend.AddService("vcap-api", Config{StartType: SERVICE_DEMAND_START, Dependencies: []string{"vcap-db"}}, stopped)
+		backend.AddService("vcap-web", Config{StartType: SERVICE_DEMAND_START, Dependencies: []string{"vcap-api", "other"}}, stopped)
+		backend.AddService("other", Config{StartType: SERVICE_DEMAND_START}, stopped)
+
+		changes = nil
+		opts = &GroupOptions{
+			PollInterval: time.Millisecond,
+			Changed: func(name string, state ServiceState) {
+				mu.Lock()
+				changes = append(changes, name+" "+state.String())
+				mu.Unlock()
+			},
+		}
+	})
+
+	It("orders services by their dependencies", func() {
+		graph, err := BuildDependencyGraph(backend)
+		Expect(err).To(BeNil())
+		Expect(graph.StartOrder(group...)).To(Equal([]string{"vcap-db", "vcap-api", "vcap-web"}))
+		Expect(graph.StopOrder(group...)).To(Equal([]string{"vcap-web", "vcap-api", "vcap-db"}))
+		Expect(graph.StartOrder("vcap-web", "VCAP-DB", "unknown")).To(Equal([]string{"unknown", "vcap-db", "vcap-web"}))
+
+		graph = NewDependencyGraph([]Service{
+			{Name: "a", Config: Config{Dependencies: []string{"b"}}},
+			{Name: "b", Config: Config{Dependencies: []string{"a"}}},
+			{Name: "c", Config: Config{Dependencies: []string{"a"}}},
+		})
+		_, err = graph.StartOrder("a", "b", "c")
+		Expect(err).To(MatchError("win: dependency cycle: cannot order a, b, c"))
+	})
+
+	It("starts dependencies first and stops dependents first", func() {
+		Expect(StartGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{
+			"vcap-db SERVICE_RUNNING", "vcap-api SERVICE_RUNNING", "vcap-web SERVICE_RUNNING"}))
+		Expect(state("other")).To(Equal(SERVICE_STOPPED))
+
+		changes = nil
+		Expect(StopGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{
+			"vcap-web SERVICE_STOPPED", "vcap-api SERVICE_STOPPED", "vcap-db SERVICE_STOPPED"}))
+	})
+
+	It("ignores services that are deleted while the dependency graph is built", func() {
+		stopped := SERVICE_STATUS_PROCESS{ServiceType: SERVICE_WIN32_OWN_PROCESS, CurrentState: SERVICE_STOPPED}
+		backend.AddService("stale-1", Config{}, stopped)
+		backend.AddService("stale-2", Config{}, stopped)
+		backend.SetOpenError("other", errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+		b := &deletingBackend{FakeBackend: backend, names: []string{"stale-1", "stale-2"}}
+		Expect(StartGroup(ctx, b, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{
+			"vcap-db SERVICE_RUNNING", "vcap-api SERVICE_RUNNING", "vcap-web SERVICE_RUNNING"}))
+
+		changes = nil
+		Expect(StopGroup(ctx, b, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{
+			"vcap-web SERVICE_STOPPED", "vcap-api SERVICE_STOPPED", "vcap-db SERVICE_STOPPED"}))
+		Expect(b.names).To(BeEmpty())
+	})
+
+	It("fails only if the dependencies of a group member are unknown", func() {
+		backend.SetOpenError("other", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		Expect(StartGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{
+			"vcap-db SERVICE_RUNNING", "vcap-api SERVICE_RUNNING", "vcap-web SERVICE_RUNNING"}))
+
+		changes = nil
+		backend.SetOpenError("vcap-api", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		err := StopGroup(ctx, backend, group, opts)
+		Expect(err).To(MatchError(ContainSubstring("win: stop group: dependencies of vcap-api are unknown")))
+		Expect(errors.Is(err, errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))).To(BeTrue())
+
+		backend.SetOpenError("vcap-api", errno.Errno(errno.ERROR_ACCESS_DENIED))
+		err = StartGroup(ctx, backend, group, opts)
+		Expect(err).To(MatchError(ContainSubstring("win: start group: dependencies of vcap-api are unknown")))
+		Expect(changes).To(BeEmpty())
+		Expect(state("vcap-db")).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("skips services that are already in the target state", func() {
+		backend.SetState("vcap-api", SERVICE_RUNNING)
+		Expect(StartGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{"vcap-db SERVICE_RUNNING", "vcap-web SERVICE_RUNNING"}))
+	})
+
+	It("selects group members with a filter", func() {
+		names, err := GroupMembers(backend, MustParseFilter(`name =~ "^vcap-"`))
+		Expect(err).To(BeNil())
+		Expect(names).To(ConsistOf(group))
+	})
+
+	It("waits for pending services within their WaitHint", func() {
+		backend.SetPending("vcap-api", 1000)
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return state("vcap-api") }).Should(Equal(SERVICE_START_PENDING))
+			backend.SetState("vcap-api", SERVICE_RUNNING)
+		}()
+		Expect(StartGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(state("vcap-web")).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("rolls back when a service fails to start", func() {
+		backend.SetState("vcap-db", SERVICE_RUNNING)
+		backend.AddService("vcap-web", Config{StartType: SERVICE_DISABLED, Dependencies: []string{"vcap-api"}},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED})
+
+		err := StartGroup(ctx, backend, group, opts)
+		var gerr *GroupError
+		Expect(errors.As(err, &gerr)).To(BeTrue())
+		Expect(gerr.Op).To(Equal("start"))
+		Expect(gerr.Service).To(Equal("vcap-web"))
+		Expect(errors.Is(err, errno.Errno(errno.ERROR_SERVICE_DISABLED))).To(BeTrue())
+		Expect(gerr.RolledBack).To(Equal([]string{"vcap-api"}))
+		Expect(gerr.RollbackErr).To(BeNil())
+
+		Expect(state("vcap-api")).To(Equal(SERVICE_STOPPED))
+		Expect(state("vcap-db")).To(Equal(SERVICE_RUNNING), "was running before")
+	})
+
+	It("stops waiting and rolls back when ctx is cancelled", func() {
+		backend.SetPending("vcap-api", 1000)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return state("vcap-api") }).Should(Equal(SERVICE_START_PENDING))
+			cancel()
+		}()
+
+		err := StartGroup(ctx, backend, group, opts)
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		var gerr *GroupError
+		Expect(errors.As(err, &gerr)).To(BeTrue())
+		Expect(gerr.Service).To(Equal("vcap-api"))
+		Expect(gerr.RolledBack).To(Equal([]string{"vcap-db"}))
+		Expect(gerr.RollbackErr).To(MatchError(ContainSubstring("win: stop vcap-api")))
+		Expect(errors.Is(gerr.RollbackErr, context.Canceled)).To(BeFalse(), "rollback is not cancelled")
+		Expect(state("vcap-db")).To(Equal(SERVICE_STOPPED))
+		Expect(state("vcap-web")).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("times out services that make no progress", func() {
+		backend.SetPending("vcap-web", 20)
+		t := time.Now()
+		err := StartGroup(ctx, backend, group, opts)
+		Expect(time.Since(t)).To(BeNumerically(">=", 40*time.Millisecond))
+		Expect(errors.Is(err, errno.Errno(errno.ERROR_SERVICE_REQUEST_TIMEOUT))).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("vcap-web")))
+
+		var gerr *GroupError
+		Expect(errors.As(err, &gerr)).To(BeTrue())
+		Expect(gerr.RolledBack).To(Equal([]string{"vcap-api", "vcap-db"}))
+		Expect(errors.Is(gerr.RollbackErr, errno.Errno(errno.ERROR_SERVICE_REQUEST_TIMEOUT))).To(BeTrue())
+		Expect(gerr.RollbackErr).To(MatchError(ContainSubstring("win: stop vcap-web")))
+		Expect(state("vcap-api")).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("leaves services as they are without rollback", func() {
+		running := SERVICE_STATUS_PROCESS{CurrentState: SERVICE_RUNNING, ControlsAccepted: SERVICE_ACCEPT_STOP}
+		backend.AddService("vcap-db", Config{StartType: SERVICE_DEMAND_START}, running)
+		backend.AddService("vcap-api", Config{StartType: SERVICE_DISABLED, Dependencies: []string{"vcap-db"}},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED})
+		backend.SetStatus("vcap-web", running)
+		opts.NoRollback = true
+
+		Expect(StopGroup(ctx, backend, group, opts)).To(Succeed())
+		Expect(changes).To(Equal([]string{"vcap-web SERVICE_STOPPED", "vcap-db SERVICE_STOPPED"}))
+
+		err := StartGroup(ctx, backend, group, opts)
+		Expect(err).To(MatchError(ContainSubstring("win: start group: vcap-api")))
+		Expect(state("vcap-db")).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("reports start failures with the exit code", func() {
+		backend.SetPending("vcap-db", 1000)
+		go func() {
+			defer GinkgoRecover()
+			Eventually(func() ServiceState { return state("vcap-db") }).Should(Equal(SERVICE_START_PENDING))
+			backend.SetStatus("vcap-db", SERVICE_STATUS_PROCESS{
+				CurrentState:            SERVICE_STOPPED,
+				Win32ExitCode:           uint32(errno.ERROR_SERVICE_SPECIFIC_ERROR),
+				ServiceSpecificExitCode: 42,
+			})
+		}()
+		err := StartGroup(ctx, backend, group, opts)
+		Expect(errors.Is(err, errno.Errno(errno.ERROR_SERVICE_SPECIFIC_ERROR))).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring(": 42")))
+	})
+})
+
+// deletingBackend deletes the next of names after every enumeration, as if
+// it was deleted before it could be opened.
+type deletingBackend struct {
+	*FakeBackend
+	names []string
+}
+
+func (b *deletingBackend) ListServices(typ ServiceType) ([]EnumServiceStatusProcess, error) {
+	procs, err := b.FakeBackend.ListServices(typ)
+	if len(b.names) != 0 {
+		b.DeleteService(b.names[0])
+		b.names = b.names[1:]
+	}
+	return procs, err
+}
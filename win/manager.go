@@ -0,0 +1,134 @@
+package win
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Service is a service found by a Manager.
+type Service struct {
+	Name        string
+	DisplayName string
+	Config      Config
+	Status      SERVICE_STATUS_PROCESS
+}
+
+// Healthy reports whether the service is running or, if it is stopped,
+// whether it is expected to be: it did not exit with an error and it is
+// not an automatic service, or it is started by a trigger.
+func (s *Service) Healthy() bool { return healthy(&s.Config, &s.Status) }
+
+// A Manager enumerates the services that match its filters on demand,
+// unlike a Supervisor it does not register for notifications or keep
+// service handles open.
+type Manager struct {
+	backend SCMBackend
+
+	mu       sync.Mutex
+	filters  []Filter                   // Services matching any filter are returned
+	services map[string]Service         // Services matched by the last Update
+	snapshot []EnumServiceStatusProcess // Services matched by the last Update
+}
+
+// NewManager returns a Manager, only the WithBackend Option applies to a
+// Manager and other options are ignored.
+func NewManager(opts ...Option) (*Manager, error) {
+	o := newOptions(opts)
+	if o.backend == nil {
+		b, err := defaultBackend()
+		if err != nil {
+			return nil, err
+		}
+		o.backend = b
+	}
+	return &Manager{
+		backend:  o.backend,
+		services: make(map[string]Service),
+	}, nil
+}
+
+// AddFilters adds filters to the Manager, like Supervisor.AddFilter
+// services that match any of the Manager's filters are returned.  A
+// Manager without filters returns every service.  Use MatchAll to require
+// a service to match a set of filters.  The filters are applied by the
+// next call to Update.
+func (m *Manager) AddFilters(filters ...Filter) {
+	m.mu.Lock()
+	m.filters = append(m.filters, filters...)
+	m.mu.Unlock()
+}
+
+// Update enumerates the services and returns the changes to the matching
+// services since the previous call to Update.  The configuration of every
+// service is queried by each Update, so filters see configuration changes.
+func (m *Manager) Update() ([]Change, error) {
+	procs, err := m.backend.ListServices(SERVICE_WIN32)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filter := MatchAny(m.filters...)
+	if len(m.filters) == 0 {
+		filter = MatchAll()
+	}
+	matched := make([]EnumServiceStatusProcess, 0, len(m.snapshot))
+	services := make(map[string]Service, len(m.services))
+	for _, p := range procs {
+		conf, err := m.config(p.ServiceName)
+		if err != nil {
+			return nil, err
+		}
+		if conf == nil || !filter(p.ServiceName, conf) {
+			continue
+		}
+		matched = append(matched, p)
+		services[p.ServiceName] = Service{
+			Name:        p.ServiceName,
+			DisplayName: p.DisplayName,
+			Config:      *conf,
+			Status:      p.ServiceStatusProcess,
+		}
+	}
+	changes := Diff(m.snapshot, matched)
+	m.snapshot = matched
+	m.services = services
+	return changes, nil
+}
+
+// config looks up the configuration of svcName.  Nil is returned if the
+// service is inaccessible or has been deleted since it was enumerated.
+func (m *Manager) config(svcName string) (*Config, error) {
+	svc, conf, err := lookupService(m.backend, svcName)
+	if err != nil {
+		if isDeleted(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if svc != nil {
+		svc.Close()
+	}
+	return conf, nil
+}
+
+// Services returns the services matched by the last call to Update,
+// ordered by name.
+func (m *Manager) Services() []Service {
+	m.mu.Lock()
+	services := make([]Service, 0, len(m.services))
+	for _, svc := range m.services {
+		services = append(services, svc)
+	}
+	m.mu.Unlock()
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+// Close closes the Manager's SCMBackend.
+func (m *Manager) Close() error {
+	return m.backend.Close()
+}
@@ -0,0 +1,151 @@
+package win
+
+import (
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func managerNames(m *Manager) []string {
+	var names []string
+	for _, svc := range m.Services() {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+var _ = Describe("Manager", func() {
+	var (
+		backend *FakeBackend
+		m       *Manager
+		vcap    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+		other   = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", other, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("vsvc-3", other, running(SERVICE_WIN32_OWN_PROCESS))
+
+		var err error
+		m, err = NewManager(WithBackend(backend))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		m.Close()
+	})
+
+	It("returns no services before the first Update", func() {
+		Expect(m.Services()).To(BeEmpty())
+	})
+
+	It("returns all services without filters", func() {
+		changes, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(changes).To(HaveLen(3))
+		Expect(managerNames(m)).To(Equal([]string{"svc-1", "svc-2", "vsvc-3"}))
+		Expect(m.Services()[0].Config.Description).To(Equal("vcap"))
+		Expect(m.Services()[0].Status.CurrentState).To(Equal(SERVICE_RUNNING))
+	})
+
+	It("returns services that match any filter, like a Supervisor", func() {
+		m.AddFilters(
+			func(name string, _ *Config) bool { return name == "vsvc-3" },
+			func(_ string, c *Config) bool { return c.Description == "vcap" },
+		)
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(managerNames(m)).To(Equal([]string{"svc-1", "vsvc-3"}))
+	})
+
+	It("supports combining filters with MatchAll", func() {
+		m.AddFilters(MatchAll(
+			func(name string, _ *Config) bool { return name != "svc-2" },
+			func(_ string, c *Config) bool { return c.Description == "other" },
+		))
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(managerNames(m)).To(Equal([]string{"vsvc-3"}))
+	})
+
+	It("queries configuration changes on every Update", func() {
+		m.AddFilters(func(_ string, c *Config) bool { return c.StartType == SERVICE_AUTO_START })
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(m.Services()).To(BeEmpty())
+
+		backend.AddService("svc-2", Config{Description: "other", StartType: SERVICE_AUTO_START},
+			running(SERVICE_WIN32_OWN_PROCESS))
+		_, err = m.Update()
+		Expect(err).To(BeNil())
+		Expect(managerNames(m)).To(Equal([]string{"svc-2"}))
+		Expect(m.Services()[0].Config.StartType).To(Equal(SERVICE_AUTO_START))
+	})
+
+	It("returns the changes since the previous Update", func() {
+		m.AddFilters(func(_ string, c *Config) bool { return c.Description == "vcap" })
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+
+		changes, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(changes).To(BeEmpty())
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		backend.SetState("svc-2", SERVICE_STOPPED)
+		backend.AddService("svc-4", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		changes, err = m.Update()
+		Expect(err).To(BeNil())
+		Expect(changeTypes(changes)).To(Equal([]string{
+			"svc-1:CurrentStateChanged",
+			"svc-4:ServiceCreated",
+		}))
+
+		backend.DeleteService("svc-1")
+		changes, err = m.Update()
+		Expect(err).To(BeNil())
+		Expect(changeTypes(changes)).To(Equal([]string{"svc-1:ServiceDeleted"}))
+		Expect(managerNames(m)).To(Equal([]string{"svc-4"}))
+	})
+
+	It("applies filters added after an Update", func() {
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+
+		m.AddFilters(func(name string, _ *Config) bool { return name == "svc-2" })
+		changes, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(changeTypes(changes)).To(Equal([]string{
+			"svc-1:ServiceDeleted",
+			"vsvc-3:ServiceDeleted",
+		}))
+		Expect(managerNames(m)).To(Equal([]string{"svc-2"}))
+	})
+
+	It("skips services that are deleted while it updates", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE))
+		backend.SetOpenError("svc-2", errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST))
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+		Expect(managerNames(m)).To(Equal([]string{"vsvc-3"}))
+	})
+
+	It("does not keep service handles open", func() {
+		_, err := m.Update()
+		Expect(err).To(BeNil())
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		for _, svc := range backend.services {
+			Expect(svc.handles).To(BeEmpty())
+		}
+	})
+
+	It("returns enumeration errors", func() {
+		backend.Close()
+		_, err := m.Update()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,302 @@
+package win
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"monitor/errno"
+)
+
+// notifyQueueSize is the number of notifications queued per registration,
+// the oldest notification is dropped when the queue is full.
+const notifyQueueSize = 16
+
+// notifySource is the platform half of a notifyEngine.  All methods but
+// wake are called from the engine goroutine.
+type notifySource interface {
+	// start is called once from the locked engine goroutine.
+	start() error
+
+	// arm registers r for its next notification.
+	arm(r *notifyRegistration) error
+
+	// disarm is called when an armed registration is unregistered.
+	disarm(r *notifyRegistration)
+
+	// wait waits up to timeout for notifications and calls fire for
+	// each registration that fired, it returns early if wake is called.
+	wait(timeout time.Duration, fire func(r *notifyRegistration, n *ServiceNotify, err error))
+
+	// wake interrupts wait, it may be called from any goroutine.
+	wake()
+
+	// stop is called once when the engine exits.
+	stop()
+}
+
+// notifyEngine owns the notification registrations of many handles and
+// delivers their notifications from a single goroutine.  The goroutine is
+// locked to its OS thread because Windows queues notification callbacks to
+// the thread that registered them, and only runs them while that thread
+// waits in an alertable state.
+type notifyEngine struct {
+	src notifySource
+
+	mu      sync.Mutex
+	toArm   []*notifyRegistration // Registrations waiting to be armed
+	started bool
+	closed  bool
+	done    chan struct{}
+}
+
+// A notifyRegistration delivers the notifications of a handle to c, it is
+// re-armed after every notification until it is unregistered or arming
+// fails.
+type notifyRegistration struct {
+	target interface{} // Handle passed to the notifySource
+	mask   ServiceNotification
+	c      chan notifyResult
+
+	armed     bool        // Owned by the engine goroutine
+	cancelled bool        // Guarded by notifyEngine.mu
+	sys       interface{} // notifySource data, such as the SERVICE_NOTIFY buffer
+}
+
+type notifyResult struct {
+	notify *ServiceNotify
+	err    error
+}
+
+func newNotifyEngine(src notifySource) *notifyEngine {
+	return &notifyEngine{src: src, done: make(chan struct{})}
+}
+
+// register starts delivering the notifications in mask for target, the
+// engine goroutine is started by the first registration.
+func (e *notifyEngine) register(target interface{}, mask ServiceNotification) *notifyRegistration {
+	r := &notifyRegistration{
+		target: target,
+		mask:   mask,
+		c:      make(chan notifyResult, notifyQueueSize),
+	}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		r.c <- notifyResult{err: errno.Errno(errno.ERROR_INVALID_HANDLE)}
+		return r
+	}
+	e.toArm = append(e.toArm, r)
+	if !e.started {
+		e.started = true
+		go e.run()
+	}
+	e.mu.Unlock()
+	e.src.wake()
+	return r
+}
+
+// unregister stops delivering notifications to r, a pending wait returns
+// without a notification.
+func (e *notifyEngine) unregister(r *notifyRegistration) {
+	if r == nil {
+		return
+	}
+	e.mu.Lock()
+	r.cancelled = true
+	e.mu.Unlock()
+	send(r, notifyResult{})
+	e.src.wake()
+}
+
+// close stops the engine goroutine and waits for it to exit, pending
+// registrations receive ERROR_INVALID_HANDLE.
+func (e *notifyEngine) close() {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	started := e.started
+	pending := e.toArm
+	e.toArm = nil
+	e.mu.Unlock()
+	for _, r := range pending {
+		send(r, notifyResult{err: errno.Errno(errno.ERROR_INVALID_HANDLE)})
+	}
+	if started {
+		e.src.wake()
+		<-e.done
+	}
+}
+
+func (e *notifyEngine) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(e.done)
+
+	if err := e.src.start(); err != nil {
+		e.fail(err)
+		return
+	}
+	defer e.src.stop()
+
+	var armed []*notifyRegistration
+	for {
+		e.mu.Lock()
+		if e.closed {
+			e.mu.Unlock()
+			for _, r := range armed {
+				if r.armed {
+					e.src.disarm(r)
+				}
+				send(r, notifyResult{err: errno.Errno(errno.ERROR_INVALID_HANDLE)})
+			}
+			return
+		}
+		toArm := e.toArm
+		e.toArm = nil
+		e.mu.Unlock()
+
+		// Disarm cancelled registrations, arm new and fired ones.
+		live := armed[:0]
+		for _, r := range armed {
+			if e.isCancelled(r) {
+				if r.armed {
+					e.src.disarm(r)
+					r.armed = false
+				}
+				continue
+			}
+			live = append(live, r)
+		}
+		armed = live
+		for _, r := range toArm {
+			if !e.isCancelled(r) {
+				armed = append(armed, r)
+			}
+		}
+		live = armed[:0]
+		for _, r := range armed {
+			if !r.armed {
+				if err := e.src.arm(r); err != nil {
+					send(r, notifyResult{err: err})
+					continue
+				}
+				r.armed = true
+			}
+			live = append(live, r)
+		}
+		armed = live
+
+		e.src.wait(notifyTimeout, e.fire)
+	}
+}
+
+// fire delivers a notification to r, it is called by the notifySource
+// from the engine goroutine.  The registration is re-armed by run.
+func (e *notifyEngine) fire(r *notifyRegistration, n *ServiceNotify, err error) {
+	r.armed = false
+	if e.isCancelled(r) {
+		return
+	}
+	send(r, notifyResult{notify: n, err: err})
+	if err != nil {
+		e.mu.Lock()
+		r.cancelled = true // Not re-armed
+		e.mu.Unlock()
+	}
+}
+
+// fail reports err to every pending registration.
+func (e *notifyEngine) fail(err error) {
+	e.mu.Lock()
+	e.closed = true
+	pending := e.toArm
+	e.toArm = nil
+	e.mu.Unlock()
+	for _, r := range pending {
+		send(r, notifyResult{err: err})
+	}
+}
+
+func (e *notifyEngine) isCancelled(r *notifyRegistration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return r.cancelled
+}
+
+// send queues res for r without blocking the engine, the oldest queued
+// result is dropped if the queue is full.
+func send(r *notifyRegistration, res notifyResult) {
+	for {
+		select {
+		case r.c <- res:
+			return
+		default:
+		}
+		select {
+		case <-r.c:
+		default:
+		}
+	}
+}
+
+// wait waits up to timeout for a notification, a nil ServiceNotify and
+// error are returned if the timeout elapses.  A negative timeout waits
+// forever.
+func (r *notifyRegistration) wait(timeout time.Duration) (*ServiceNotify, error) {
+	if timeout < 0 {
+		res := <-r.c
+		return res.notify, res.err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case res := <-r.c:
+		return res.notify, res.err
+	case <-t.C:
+		return nil, nil
+	}
+}
+
+// notifyWaiter implements the blocking WaitNotify of a handle with a
+// notifyEngine registration.  The registration is made by the first wait,
+// later waits reuse it and therefore its mask.
+type notifyWaiter struct {
+	mu  sync.Mutex
+	reg *notifyRegistration
+}
+
+func (w *notifyWaiter) wait(e *notifyEngine, target interface{}, mask ServiceNotification,
+	timeout time.Duration) (*ServiceNotify, error) {
+
+	w.mu.Lock()
+	if w.reg == nil {
+		w.reg = e.register(target, mask)
+	}
+	r := w.reg
+	w.mu.Unlock()
+
+	n, err := r.wait(timeout)
+	if err != nil {
+		// The registration is not re-armed after an error.
+		w.mu.Lock()
+		if w.reg == r {
+			w.reg = nil
+		}
+		w.mu.Unlock()
+	}
+	return n, err
+}
+
+// close unregisters the waiter, a pending wait returns without a
+// notification.
+func (w *notifyWaiter) close(e *notifyEngine) {
+	w.mu.Lock()
+	r := w.reg
+	w.reg = nil
+	w.mu.Unlock()
+	e.unregister(r)
+}
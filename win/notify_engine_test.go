@@ -0,0 +1,239 @@
+package win
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const stateMask = SERVICE_NOTIFY_RUNNING | SERVICE_NOTIFY_STOPPED
+
+var _ = Describe("notifyEngine (fake backend)", func() {
+	var (
+		backend *FakeBackend
+		conf    Config
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.useNotifyEngine()
+		conf = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+	})
+
+	AfterEach(func() {
+		backend.Close()
+	})
+
+	open := func(name string) ServiceHandle {
+		backend.AddService(name, conf, running(SERVICE_WIN32_OWN_PROCESS))
+		h, err := backend.OpenService(name)
+		Expect(err).To(BeNil())
+		return h
+	}
+
+	It("delivers the notifications of many handles", func() {
+		var handles []ServiceHandle
+		for i := 0; i < 20; i++ {
+			handles = append(handles, open(fmt.Sprintf("svc-%d", i)))
+		}
+		for _, h := range handles {
+			backend.SetState(h.Name(), SERVICE_STOPPED)
+		}
+		for _, h := range handles {
+			n, err := h.WaitNotify(stateMask, time.Second)
+			Expect(err).To(BeNil())
+			Expect(n).NotTo(BeNil())
+			Expect(n.ServiceStatus.CurrentState).To(Equal(SERVICE_STOPPED))
+		}
+	})
+
+	It("re-arms registrations after each notification", func() {
+		h := open("svc-1")
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		backend.SetState("svc-1", SERVICE_RUNNING)
+
+		n, err := h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.NotificationTriggered).To(Equal(SERVICE_NOTIFY_STOPPED))
+		n, err = h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.NotificationTriggered).To(Equal(SERVICE_NOTIFY_RUNNING))
+	})
+
+	It("returns no notification when the timeout elapses", func() {
+		h := open("svc-1")
+		n, err := h.WaitNotify(stateMask, 10*time.Millisecond)
+		Expect(err).To(BeNil())
+		Expect(n).To(BeNil())
+	})
+
+	It("returns registration errors", func() {
+		h := open("svc-1")
+		backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+		_, err := h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_ACCESS_DENIED)))
+
+		backend.SetNotifyError(nil)
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		n, err := h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n).NotTo(BeNil())
+	})
+
+	It("returns ERROR_INVALID_HANDLE for stale handles", func() {
+		h := open("svc-1")
+		_, err := h.WaitNotify(stateMask, 10*time.Millisecond)
+		Expect(err).To(BeNil())
+
+		backend.InvalidateHandles("svc-1")
+		_, err = h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_INVALID_HANDLE)))
+	})
+
+	It("wakes a pending WaitNotify when the handle is closed", func() {
+		h := open("svc-1")
+		done := make(chan error, 1)
+		go func() {
+			_, err := h.WaitNotify(stateMask, -1)
+			done <- err
+		}()
+		Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+		Expect(h.Close()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("drops the oldest notification when a registration falls behind", func() {
+		h := open("svc-1").(*fakeHandle)
+		_, err := h.WaitNotify(stateMask, 10*time.Millisecond)
+		Expect(err).To(BeNil())
+
+		for i := 0; i < notifyQueueSize+1; i++ {
+			backend.Notify("svc-1", ServiceNotify{
+				ServiceStatus:         SERVICE_STATUS_PROCESS{CheckPoint: uint32(i)},
+				NotificationTriggered: SERVICE_NOTIFY_RUNNING,
+			})
+			// Re-armed once the notification was queued.
+			Eventually(func() bool {
+				h.queue.mu.Lock()
+				defer h.queue.mu.Unlock()
+				return h.queue.reg != nil
+			}).Should(BeTrue())
+		}
+		Expect(h.notify.reg.c).To(HaveLen(notifyQueueSize))
+		n, err := h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(BeNil())
+		Expect(n.ServiceStatus.CheckPoint).To(BeEquivalentTo(1))
+	})
+
+	It("fails registrations after it is closed", func() {
+		h := open("svc-1")
+		backend.engine.close()
+		_, err := h.WaitNotify(stateMask, time.Second)
+		Expect(err).To(Equal(errno.Errno(errno.ERROR_INVALID_HANDLE)))
+	})
+
+	It("supports a Supervisor", func() {
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		s, err := startSupervisor(func(string, *Config) bool { return true }, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		Eventually(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-1", "svc-2"))
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		Eventually(func() ServiceState {
+			for _, svc := range s.Services() {
+				if svc.Name == "svc-1" {
+					return svc.Status.CurrentState
+				}
+			}
+			return 0
+		}).Should(Equal(SERVICE_STOPPED))
+
+		backend.DeleteService("svc-2")
+		Eventually(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-1"))
+	})
+})
+
+// benchmarkNotify measures delivering a state change to each of n services
+// and receiving it in a goroutine per handle.
+func benchmarkNotify(b *testing.B, engine bool) {
+	for _, count := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("services=%d", count), func(b *testing.B) {
+			backend := NewFakeBackend()
+			if engine {
+				backend.useNotifyEngine()
+			}
+			defer backend.Close()
+
+			var (
+				names   = make([]string, count)
+				handles = make([]ServiceHandle, count)
+				results = make(chan struct{}, count)
+				halt    = make(chan struct{})
+				wg      sync.WaitGroup
+			)
+			for i := range names {
+				names[i] = fmt.Sprintf("svc-%d", i)
+				backend.AddService(names[i], Config{}, running(SERVICE_WIN32_OWN_PROCESS))
+				h, err := backend.OpenService(names[i])
+				if err != nil {
+					b.Fatal(err)
+				}
+				handles[i] = h
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						n, err := h.WaitNotify(stateMask, notifyTimeout)
+						select {
+						case <-halt:
+							return
+						default:
+						}
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						if n != nil {
+							results <- struct{}{}
+						}
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				state := SERVICE_STOPPED
+				if i%2 == 1 {
+					state = SERVICE_RUNNING
+				}
+				for _, name := range names {
+					backend.SetState(name, state)
+				}
+				for range names {
+					<-results
+				}
+			}
+			b.StopTimer()
+
+			close(halt)
+			for _, h := range handles {
+				h.Close()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkNotifyPerGoroutine(b *testing.B) { benchmarkNotify(b, false) }
+
+func BenchmarkNotifyEngine(b *testing.B) { benchmarkNotify(b, true) }
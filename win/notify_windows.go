@@ -0,0 +1,168 @@
+package win
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"monitor/errno"
+)
+
+var (
+	apcOnce   sync.Once
+	apcEngine *notifyEngine
+)
+
+// sharedNotifyEngine returns the engine that owns the notification
+// registrations of every Windows handle in the process, its thread is
+// started by the first registration.
+func sharedNotifyEngine() *notifyEngine {
+	apcOnce.Do(func() {
+		apcEngine = newNotifyEngine(newAPCSource())
+	})
+	return apcEngine
+}
+
+// apcSource registers SERVICE_NOTIFY buffers with NotifyServiceStatusChange
+// and waits for their callbacks, which Windows queues as APCs to the engine
+// thread, with an alertable SleepEx.
+type apcSource struct {
+	callback uintptr // SERVICE_NOTIFY callback shared by all buffers
+	wakeAPC  uintptr // No-op APC queued by wake
+
+	mu     sync.Mutex     // Guards thread
+	thread windows.Handle // Engine thread, for QueueUserAPC
+
+	// Owned by the engine thread, the callbacks run on it.
+	bufs    map[uintptr]*notifyRegistration // Armed buffers by address
+	fired   []apcFired
+	retired []uintptr // Disarmed buffers, removed from bufs after the next wait
+}
+
+type apcFired struct {
+	r   *notifyRegistration
+	n   *ServiceNotify
+	err error
+}
+
+// apcBuffer is the notifySource data of a registration.
+type apcBuffer struct {
+	buf SERVICE_NOTIFY
+}
+
+func newAPCSource() *apcSource {
+	s := &apcSource{bufs: make(map[uintptr]*notifyRegistration)}
+	s.callback = syscall.NewCallback(s.onNotify)
+	s.wakeAPC = syscall.NewCallback(func(uintptr) uintptr { return 0 })
+	return s
+}
+
+func (s *apcSource) start() error {
+	const THREAD_SET_CONTEXT = 0x0010
+	h, err := windows.OpenThread(THREAD_SET_CONTEXT, false, windows.GetCurrentThreadId())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.thread = h
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *apcSource) stop() {
+	s.mu.Lock()
+	h := s.thread
+	s.thread = 0
+	s.mu.Unlock()
+	windows.CloseHandle(h)
+}
+
+func (s *apcSource) arm(r *notifyRegistration) error {
+	h, ok := r.target.(windows.Handle)
+	if !ok {
+		return errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	b, _ := r.sys.(*apcBuffer)
+	if b == nil {
+		b = new(apcBuffer)
+		r.sys = b
+	}
+	b.buf = SERVICE_NOTIFY{
+		Version:        SERVICE_NOTIFY_STATUS_CHANGE,
+		NotifyCallback: s.callback,
+	}
+	p := uintptr(unsafe.Pointer(&b.buf))
+	r1, _, _ := syscall.Syscall(
+		procNotifyServiceStatusChange.Addr(),
+		3,
+		uintptr(h),      // hService
+		uintptr(r.mask), // dwNotifyMask
+		p,               // pNotifyBuffer
+	)
+	if e := errno.Errno(r1); e != errno.ERROR_SUCCESS {
+		return e
+	}
+	s.bufs[p] = r
+	return nil
+}
+
+// disarm removes the buffer from bufs after the next wait.  Registrations
+// are unregistered after their handle is closed, so Windows no longer
+// queues the callback, but one queued before runs during the next wait and
+// must find the buffer.
+func (s *apcSource) disarm(r *notifyRegistration) {
+	if b, _ := r.sys.(*apcBuffer); b != nil {
+		s.retired = append(s.retired, uintptr(unsafe.Pointer(&b.buf)))
+	}
+}
+
+// onNotify is called on the engine thread with a pointer to an armed
+// buffer.  The notification is copied out, and the service names freed,
+// before the buffer is re-armed.
+func (s *apcSource) onNotify(p uintptr) uintptr {
+	r := s.bufs[p]
+	if r == nil {
+		return 0
+	}
+	delete(s.bufs, p)
+	b := r.sys.(*apcBuffer)
+	if e := errno.Errno(b.buf.NotificationStatus); e != errno.ERROR_SUCCESS {
+		s.fired = append(s.fired, apcFired{r: r, err: e})
+	} else {
+		s.fired = append(s.fired, apcFired{r: r, n: newServiceNotify(&b.buf)})
+	}
+	return 0
+}
+
+func (s *apcSource) wait(timeout time.Duration, fire func(*notifyRegistration, *ServiceNotify, error)) {
+	const Alertable = 1
+	retired := s.retired
+	s.retired = nil
+	syscall.Syscall(
+		procSleepEx.Addr(),
+		uintptr(2),
+		uintptr(timeout/time.Millisecond),
+		uintptr(Alertable),
+		uintptr(0),
+	)
+	// The alertable wait ran every callback queued before it.
+	for _, p := range retired {
+		delete(s.bufs, p)
+	}
+	fired := s.fired
+	s.fired = nil
+	for _, f := range fired {
+		fire(f.r, f.n, f.err)
+	}
+}
+
+func (s *apcSource) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.thread != 0 {
+		procQueueUserAPC.Call(s.wakeAPC, uintptr(s.thread), 0)
+	}
+}
@@ -0,0 +1,145 @@
+package win
+
+import "time"
+
+// DefaultPollInterval is the polling interval used when WithPolling or
+// WithPollingFallback is passed an interval that is not positive.
+const DefaultPollInterval = 10 * time.Second
+
+// WithPolling makes the Supervisor poll the Service Control Manager every
+// interval instead of registering for notifications.  DefaultPollInterval
+// is used if interval is not positive.
+func WithPolling(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = validPollInterval(interval)
+		o.polling = true
+	}
+}
+
+// WithPollingFallback makes the Supervisor poll the Service Control Manager
+// every interval when registering for notifications fails.  Polling is
+// limited to the SCM or the services whose registration failed.
+// DefaultPollInterval is used if interval is not positive.
+func WithPollingFallback(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = validPollInterval(interval)
+		o.fallback = true
+	}
+}
+
+func validPollInterval(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return DefaultPollInterval
+	}
+	return interval
+}
+
+// startPolling starts the poller if it is not running, if scm is true
+// the poller also detects created and deleted services.
+func (s *Supervisor) startPolling(scm bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if scm {
+		s.pollSCM = true
+	}
+	s.pollOnce.Do(func() { s.goLocked(s.poll) })
+}
+
+// pollService switches svcName from notifications to polling.
+func (s *Supervisor) pollService(svcName string) {
+	s.mu.Lock()
+	if l := s.serviceListeners[svcName]; l != nil {
+		l.polled = true
+	}
+	s.mu.Unlock()
+	s.startPolling(false)
+}
+
+func (s *Supervisor) poll() {
+	t := time.NewTicker(s.pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.halt:
+			return
+		case <-t.C:
+			if err := s.pollServices(); err != nil {
+				s.report(err)
+			}
+		}
+	}
+}
+
+// pollServices enumerates the services and compares them to the previous
+// snapshot, emitting the same events as the notification listeners.
+func (s *Supervisor) pollServices() error {
+	s.mu.RLock()
+	reconnecting := s.reconnecting
+	s.mu.RUnlock()
+	if reconnecting {
+		return nil
+	}
+	procs, err := s.backend.ListServices(SERVICE_WIN32)
+	if err != nil {
+		if s.shouldReconnect(err) {
+			s.startReconnect()
+		}
+		return listenerError("", "EnumServicesStatusEx", err)
+	}
+	s.mu.Lock()
+	prev := s.snapshot
+	pollSCM := s.pollSCM
+	s.mu.Unlock()
+
+	for _, c := range Diff(prev, procs) {
+		switch c.Type {
+		case ServiceCreated:
+			if pollSCM {
+				l, err := s.monitorService(c.ServiceName)
+				if err != nil {
+					s.report(err)
+				} else if l != nil {
+					s.emit(l, ServiceAdded, l.Status, l.Status)
+				}
+			}
+		case ServiceDeleted:
+			if pollSCM {
+				s.unmonitorService(c.ServiceName)
+			}
+		case CurrentStateChanged, ProcessIdChanged, ControlsAcceptedChanged:
+			s.pollStatus(c.ServiceName, c.New.ServiceStatusProcess)
+		}
+	}
+
+	s.mu.Lock()
+	s.snapshot = procs
+	s.mu.Unlock()
+	return nil
+}
+
+// pollStatus updates the status of a polled service and emits a
+// StateChanged event if its state changed.
+func (s *Supervisor) pollStatus(svcName string, status SERVICE_STATUS_PROCESS) {
+	s.mu.Lock()
+	l := s.serviceListeners[svcName]
+	if l == nil || !l.polled {
+		s.mu.Unlock()
+		return
+	}
+	if l.Status.CurrentState == status.CurrentState {
+		l.Status = status
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.updateServiceStatus(svcName, &ServiceNotify{
+		ServiceStatus:         status,
+		NotificationTriggered: notificationForState(status.CurrentState),
+	})
+}
+
+// fallbackToPolling reports whether a listener that failed with err
+// should be replaced by polling.
+func (s *Supervisor) fallbackToPolling(err error) bool {
+	return s.fallback && err != nil && !isDeleted(err)
+}
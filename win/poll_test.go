@@ -0,0 +1,98 @@
+package win
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"monitor/errno"
+)
+
+var _ = Describe("Polling", func() {
+	const interval = 10 * time.Millisecond
+	var (
+		backend *FakeBackend
+		conf    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS}
+		all     = func(string, *Config) bool { return true }
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+	})
+
+	expectEvents := func(s *Supervisor) {
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		var e Event
+		Eventually(s.Events()).Should(Receive(&e))
+		Expect(e.Type).To(Equal(ServiceAdded))
+		Expect(e.Service).To(Equal("svc-2"))
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		Eventually(s.Events()).Should(Receive(&e))
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-1"))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+
+		backend.DeleteService("svc-2")
+		Eventually(s.Events()).Should(Receive(&e))
+		Expect(e.Type).To(Equal(ServiceRemoved))
+		Expect(e.Service).To(Equal("svc-2"))
+
+		Consistently(s.Events(), 5*interval).ShouldNot(Receive())
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
+	}
+
+	It("synthesises events from snapshots when polling", func() {
+		// Notifications must not be used.
+		backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+		s, err := startSupervisor(all, WithBackend(backend), WithPolling(interval))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
+
+		expectEvents(s)
+	})
+
+	It("uses DefaultPollInterval for intervals that are not positive", func() {
+		for _, opt := range []Option{WithPolling(0), WithPolling(-time.Second), WithPollingFallback(0)} {
+			s, err := NewSupervisor(all, WithBackend(backend), opt)
+			Expect(err).To(BeNil())
+			Expect(s.pollInterval).To(Equal(DefaultPollInterval))
+			s.startPolling(true) // Panics with an invalid interval
+			Expect(s.Close()).To(Succeed())
+			backend = NewFakeBackend()
+		}
+	})
+
+	It("falls back to polling when notification registration fails", func() {
+		backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+		s, err := startSupervisor(all, WithBackend(backend), WithPollingFallback(interval))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
+
+		// Wait for the listeners to fail.
+		Eventually(func() bool {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.pollSCM && s.serviceListeners["svc-1"].polled
+		}).Should(BeTrue())
+
+		expectEvents(s)
+	})
+
+	It("stops monitoring services whose registration fails without a fallback", func() {
+		backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+		s, err := startSupervisor(all, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		Eventually(s.Services).Should(BeEmpty())
+	})
+})
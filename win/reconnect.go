@@ -0,0 +1,206 @@
+package win
+
+import (
+	"sort"
+	"time"
+
+	"monitor/errno"
+)
+
+// Default delays between attempts to reconnect to the Service Control
+// Manager, see WithReconnectBackoff.
+const (
+	DefaultReconnectBackoffMin = 100 * time.Millisecond
+	DefaultReconnectBackoffMax = 30 * time.Second
+)
+
+// WithReconnectBackoff sets the delay between attempts to reconnect to the
+// Service Control Manager or reopen a service handle, the delay starts at
+// min and doubles after every failed attempt up to max.
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		o.backoffMin = min
+		o.backoffMax = max
+	}
+}
+
+// isDisconnected reports whether err indicates that the connection to the
+// Service Control Manager was lost, for example because services.exe was
+// restarted.
+func isDisconnected(err error) bool {
+	return isErrno(err, errno.ERROR_INVALID_HANDLE) ||
+		isErrno(err, errno.RPC_S_SERVER_UNAVAILABLE) ||
+		isErrno(err, errno.RPC_S_CALL_FAILED) ||
+		isErrno(err, errno.RPC_S_CALL_FAILED_DNE)
+}
+
+// shouldReconnect reports whether the Supervisor should reconnect after an
+// operation failed with err.
+func (s *Supervisor) shouldReconnect(err error) bool {
+	_, ok := s.backend.(Reconnector)
+	return ok && !s.closed() && isDisconnected(err)
+}
+
+// startReconnect starts reconnecting to the Service Control Manager if it
+// is not already in progress.
+func (s *Supervisor) startReconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reconnecting || s.closed() {
+		return
+	}
+	s.reconnecting = true
+	s.goLocked(s.reconnect)
+}
+
+// reopenService opens svcName to reload the handle of its listener.  It
+// fails while reconnecting, resync replaces all listeners.
+func (s *Supervisor) reopenService(svcName string) (ServiceHandle, error) {
+	s.mu.RLock()
+	reconnecting := s.reconnecting
+	s.mu.RUnlock()
+	if reconnecting {
+		return nil, errno.Errno(errno.ERROR_INVALID_HANDLE)
+	}
+	svc, err := s.backend.OpenService(svcName)
+	if err != nil && s.shouldReconnect(err) {
+		s.startReconnect()
+	}
+	return svc, err
+}
+
+// reconnect reconnects with exponential backoff until it succeeds or the
+// Supervisor is closed.
+func (s *Supervisor) reconnect() {
+	r := s.backend.(Reconnector)
+	delay := s.backoffMin
+	for {
+		t := time.NewTimer(delay)
+		select {
+		case <-s.halt:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		err := s.reconnectOnce(r)
+		if err == nil {
+			break
+		}
+		s.report(err)
+		if delay *= 2; delay > s.backoffMax {
+			delay = s.backoffMax
+		}
+	}
+	s.mu.Lock()
+	s.reconnecting = false
+	s.mu.Unlock()
+}
+
+// reconnectOnce reconnects, restarts the SCM listener and resynchronizes
+// the monitored services.
+func (s *Supervisor) reconnectOnce(r Reconnector) error {
+	if err := r.Reconnect(); err != nil {
+		return listenerError("", "OpenSCManager", err)
+	}
+	// Start listening before enumerating so that no service is missed.
+	var old *SCMListener
+	s.mu.Lock()
+	if !s.polling && !s.closed() {
+		old = s.scmListener
+		s.scmListener = newSCMListener(s.backend)
+		s.goLocked(s.listenSCM)
+	}
+	s.mu.Unlock()
+	if old != nil {
+		old.Close()
+		<-old.done
+	}
+	return s.resync()
+}
+
+// resync reopens the monitored services after reconnecting and emits the
+// events that were missed while disconnected.  The last known state is
+// the previous enumeration updated with the status of the listeners.
+func (s *Supervisor) resync() error {
+	procs, err := s.backend.ListServices(SERVICE_WIN32)
+	if err != nil {
+		return listenerError("", "EnumServicesStatusEx", err)
+	}
+
+	s.mu.Lock()
+	old := s.serviceListeners
+	s.serviceListeners = make(map[string]*ServiceListener, len(old))
+	known := make(map[string]EnumServiceStatusProcess, len(s.snapshot))
+	for _, p := range s.snapshot {
+		known[p.ServiceName] = p
+	}
+	for name, l := range old {
+		p := known[name]
+		p.ServiceName = name
+		p.ServiceStatusProcess = l.Status
+		known[name] = p
+	}
+	s.mu.Unlock()
+	for _, l := range old {
+		l.Close() // The handles are no longer valid
+	}
+
+	prev := make([]EnumServiceStatusProcess, 0, len(known))
+	for _, p := range known {
+		prev = append(prev, p)
+	}
+	created := make(map[string]bool)
+	stateChanged := make(map[string]bool)
+	var deleted []string
+	for _, c := range Diff(prev, procs) {
+		switch c.Type {
+		case ServiceCreated:
+			created[c.ServiceName] = true
+		case ServiceDeleted:
+			deleted = append(deleted, c.ServiceName)
+		case CurrentStateChanged:
+			stateChanged[c.ServiceName] = true
+		}
+	}
+
+	for _, p := range procs {
+		prevListener := old[p.ServiceName]
+		l, started, err := s.remonitorService(p.ServiceName, prevListener)
+		if err != nil {
+			s.report(err)
+		}
+		switch {
+		case l != nil && !started:
+			// Already monitored again, the SCM listener re-added it.
+			s.mu.RLock()
+			status := l.Status
+			s.mu.RUnlock()
+			if prevListener != nil && status.CurrentState != prevListener.Status.CurrentState {
+				s.emit(l, StateChanged, prevListener.Status, status)
+			}
+		case l == nil && prevListener != nil:
+			s.emit(prevListener, WatchStopped, prevListener.Status, prevListener.Status)
+		case l == nil:
+			// Not monitored
+		case prevListener != nil:
+			if stateChanged[p.ServiceName] {
+				s.emit(l, StateChanged, prevListener.Status, l.Status)
+			}
+		case created[p.ServiceName]:
+			s.emit(l, ServiceAdded, l.Status, l.Status)
+		default:
+			s.emit(l, WatchStarted, l.Status, l.Status)
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		if l := old[name]; l != nil {
+			s.emit(l, ServiceRemoved, l.Status, l.Status)
+		}
+	}
+
+	s.mu.Lock()
+	s.snapshot = procs
+	s.mu.Unlock()
+	return nil
+}
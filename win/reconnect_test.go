@@ -0,0 +1,218 @@
+package win
+
+import (
+	"sync/atomic"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// noReconnect hides the Reconnect method of a backend.
+type noReconnect struct {
+	SCMBackend
+}
+
+// readdingBackend monitors a service again the first time the supervisor
+// reopens it after being armed, like the SCM listener would while resync
+// runs.
+type readdingBackend struct {
+	*FakeBackend
+	s     *Supervisor
+	name  string
+	armed int32
+}
+
+func (b *readdingBackend) OpenService(name string) (ServiceHandle, error) {
+	if name == b.name && atomic.CompareAndSwapInt32(&b.armed, 1, 0) {
+		b.s.monitorService(name)
+	}
+	return b.FakeBackend.OpenService(name)
+}
+
+var _ = Describe("Reconnect", func() {
+	var (
+		backend *FakeBackend
+		s       *Supervisor
+		conf    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+		filter  = func(_ string, c *Config) bool { return c.Description == "vcap" }
+		lost    = errno.Errno(errno.RPC_S_SERVER_UNAVAILABLE)
+	)
+
+	nextEvent := func() Event {
+		var e Event
+		Eventually(s.Events()).Should(Receive(&e))
+		return e
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-4", conf, running(SERVICE_WIN32_OWN_PROCESS))
+	})
+
+	AfterEach(func() {
+		if s != nil {
+			s.Close()
+		}
+	})
+
+	Context("with notifications", func() {
+		BeforeEach(func() {
+			var err error
+			s, err = startSupervisor(filter, WithBackend(backend),
+				WithReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+			Expect(err).To(BeNil())
+		})
+
+		It("emits the events missed while disconnected", func() {
+			backend.SetReconnectError(lost)
+			backend.Disconnect()
+
+			backend.SetState("svc-1", SERVICE_STOPPED)
+			backend.DeleteService("svc-2")
+			backend.AddService("svc-3", conf, running(SERVICE_WIN32_OWN_PROCESS))
+			backend.SetReconnectError(nil)
+
+			var events []string
+			for i := 0; i < 3; i++ {
+				e := nextEvent()
+				events = append(events, e.Service+":"+e.Type.String())
+				if e.Type == StateChanged {
+					Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+					Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+					Expect(e.Seq).To(Equal(uint64(1)))
+				}
+			}
+			Expect(events).To(Equal([]string{
+				"svc-1:StateChanged",
+				"svc-3:ServiceAdded",
+				"svc-2:ServiceRemoved",
+			}))
+			Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+			Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-3", "svc-4"))
+		})
+
+		It("resumes notifications after reconnecting", func() {
+			backend.Disconnect()
+			Eventually(func() error {
+				_, err := backend.ListServices(SERVICE_WIN32)
+				return err
+			}).Should(Succeed())
+
+			Eventually(func() int {
+				backend.mu.Lock()
+				defer backend.mu.Unlock()
+				return len(backend.services["svc-1"].handles)
+			}).Should(Equal(1))
+
+			backend.SetState("svc-1", SERVICE_STOPPED)
+			e := nextEvent()
+			Expect(e.Type).To(Equal(StateChanged))
+			Expect(e.Service).To(Equal("svc-1"))
+
+			backend.AddService("svc-5", conf, running(SERVICE_WIN32_OWN_PROCESS))
+			e = nextEvent()
+			Expect(e.Type).To(Equal(ServiceAdded))
+			Expect(e.Service).To(Equal("svc-5"))
+		})
+
+		It("reports failed attempts and keeps retrying", func() {
+			backend.SetReconnectError(lost)
+			backend.Disconnect()
+
+			var err error
+			Eventually(s.Errors()).Should(Receive(&err))
+			Eventually(func() string {
+				select {
+				case err := <-s.Errors():
+					return err.(*ListenerError).Op
+				default:
+					return ""
+				}
+			}).Should(Equal("OpenSCManager"))
+
+			backend.SetReconnectError(nil)
+			backend.SetState("svc-1", SERVICE_STOPPED)
+			e := nextEvent()
+			Expect(e.Service).To(Equal("svc-1"))
+			Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		})
+
+		It("stops reconnecting when closed", func() {
+			backend.SetReconnectError(lost)
+			backend.Disconnect()
+			time.Sleep(20 * time.Millisecond)
+			Expect(s.Close()).To(Succeed())
+		})
+	})
+
+	It("reconnects in polling mode", func() {
+		var err error
+		s, err = startSupervisor(filter, WithBackend(backend), WithPolling(time.Millisecond),
+			WithReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+		Expect(err).To(BeNil())
+
+		backend.SetReconnectError(lost)
+		backend.Disconnect()
+		time.Sleep(10 * time.Millisecond)
+		backend.DeleteService("svc-2")
+		backend.SetReconnectError(nil)
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceRemoved))
+		Expect(e.Service).To(Equal("svc-2"))
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		e = nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-1"))
+	})
+
+	It("does not stop watching services the SCM listener re-added", func() {
+		b := &readdingBackend{FakeBackend: backend, name: "svc-1"}
+		var err error
+		s, err = startSupervisor(filter, WithBackend(b),
+			WithReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+		Expect(err).To(BeNil())
+		b.s = s
+
+		backend.SetReconnectError(lost)
+		backend.Disconnect()
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		atomic.StoreInt32(&b.armed, 1)
+		backend.SetReconnectError(nil)
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-1"))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+		Expect(atomic.LoadInt32(&b.armed)).To(Equal(int32(0)))
+		Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-2", "svc-4"))
+	})
+
+	It("only reloads service handles for backends without Reconnect", func() {
+		var err error
+		s, err = startSupervisor(filter, WithBackend(noReconnect{backend}),
+			WithReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+		Expect(err).To(BeNil())
+
+		backend.Disconnect()
+		Consistently(s.Services, 50*time.Millisecond).Should(HaveLen(3))
+		Expect(backend.Reconnect()).To(Succeed())
+
+		var names []string
+		for i := 0; i < 3; i++ {
+			e := nextEvent()
+			Expect(e.Type).To(Equal(ServiceReloaded))
+			names = append(names, e.Service)
+		}
+		Expect(names).To(ConsistOf("svc-1", "svc-2", "svc-4"))
+	})
+})
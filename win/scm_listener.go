@@ -1,103 +1,44 @@
 package win
 
 import (
-	"fmt"
-	"monitor/errno"
 	"strings"
-	"sync"
-	"syscall"
-	"unsafe"
-
-	"golang.org/x/sys/windows/svc/mgr"
 )
 
 type SCMListener struct {
-	manager *mgr.Mgr
+	backend SCMBackend
 
 	updates chan Notification
 	halt    chan struct{}
-	ready   chan bool
-	once    sync.Once
+	done    chan struct{} // Closed when the listener goroutine exits
 }
 
-func newSCMListener() (*SCMListener, error) {
-	mgr, err := mgr.Connect()
-	if err != nil {
-		return nil, err
-	}
+func newSCMListener(backend SCMBackend) *SCMListener {
 	s := &SCMListener{
-		manager: mgr,
+		backend: backend,
 
 		updates: make(chan Notification, 10),
 		halt:    make(chan struct{}),
-		ready:   make(chan bool, 1),
+		done:    make(chan struct{}),
 	}
 	go s.notifyStatusChange()
-	// <-s.ready
-	// close(s.ready)
-
-	return s, nil
+	return s
 }
 
 func (s *SCMListener) notifyStatusChange() {
-	const (
-		Duration           = MaxUint32 // milliseconds
-		Alertable          = 1
-		WAIT_IO_COMPLETION = 192
-	)
-	mask := SERVICE_NOTIFY_CREATED | SERVICE_NOTIFY_DELETED
-	//mask := SERVICE_NOTIFY_CREATED
-	fmt.Println("THE MASK", uintptr(mask))
-
-	var notify *SERVICE_NOTIFY
-	callback := func(p uintptr) uintptr {
-		if p != 0 {
-			notify = (*SERVICE_NOTIFY)(unsafe.Pointer(p))
-		}
-		return 1
-	}
-
-	statusNotify := SERVICE_NOTIFY{
-		Version:        SERVICE_NOTIFY_STATUS_CHANGE,
-		NotifyCallback: syscall.NewCallback(callback),
-	}
+	const mask = SERVICE_NOTIFY_CREATED | SERVICE_NOTIFY_DELETED
 
+	defer close(s.done)
 	for {
 		if s.closed() {
 			break
 		}
-		fmt.Println("HANDLE:", s.manager.Handle)
-		r1, _, _ := syscall.Syscall(
-			procNotifyServiceStatusChange.Addr(),
-			3,
-			uintptr(s.manager.Handle),              // hService
-			uintptr(mask),                          // dwNotifyMask
-			uintptr(unsafe.Pointer(&statusNotify)), // pNotifyBuffer
-		)
-
-		var act MonitorAction
-		switch errno.Errno(r1) {
-		case errno.ERROR_SUCCESS:
-			act = ActionSuccess
-		default:
-			act = ActionDelete
-		}
-		if act != ActionSuccess {
-			// this sucks
-			s.notify(newServiceNotify(nil), act)
+		n, err := s.backend.WaitNotify(mask, notifyTimeout)
+		if err != nil {
+			s.notifyError(err)
 			break
 		}
-		//s.once.Do(func() { s.ready <- true })
-		r1, _, _ = syscall.Syscall(
-			procSleepEx.Addr(),
-			uintptr(2),
-			uintptr(Duration),
-			uintptr(Alertable),
-			uintptr(0),
-		)
-		fmt.Println("Woke up")
-		if r1 == WAIT_IO_COMPLETION {
-			s.notify(newServiceNotify(notify), act)
+		if n != nil {
+			s.notify(n, ActionSuccess)
 		}
 	}
 }
@@ -112,15 +53,25 @@ func (s *SCMListener) notify(n *ServiceNotify, act MonitorAction) {
 		Notify: n,
 		Action: act,
 	}
-	s.updates <- notify
+	select {
+	case s.updates <- notify:
+	case <-s.halt:
+	}
+}
+
+// notifyError reports that registering for notifications failed.
+func (s *SCMListener) notifyError(err error) {
+	select {
+	case s.updates <- Notification{Action: ActionDelete, Err: err}:
+	case <-s.halt:
+	}
 }
 
+// Close stops the listener, the SCMBackend is owned by the caller.
 func (s *SCMListener) Close() error {
-	err := s.manager.Disconnect()
-	if err != nil {
-		return err
+	if !s.closed() {
+		close(s.halt)
 	}
-	close(s.halt)
 	return nil
 }
 
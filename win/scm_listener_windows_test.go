@@ -1,9 +1,6 @@
 package win
 
 import (
-	"fmt"
-	"time"
-
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"golang.org/x/sys/windows"
@@ -14,13 +11,14 @@ var _ = Describe("SCMListener", func() {
 	XDescribe("closed", func() {
 		var scl *SCMListener
 		BeforeEach(func() {
-			var err error
-			scl, err = newSCMListener()
+			b, err := ConnectSCM()
 			Expect(err).To(BeNil())
+			scl = newSCMListener(b)
 		})
 
 		AfterEach(func() {
 			scl.Close()
+			scl.backend.Close()
 		})
 
 		It("is true when the halt channel has been closed", func() {
@@ -30,25 +28,27 @@ var _ = Describe("SCMListener", func() {
 		})
 
 		It("is false if the halt channel is still open", func() {
-			Expect(scl.manager.Handle).ToNot(Equal(windows.InvalidHandle))
+			Expect(handleOf(scl.backend)).ToNot(Equal(windows.InvalidHandle))
 			Expect(scl.closed()).To(BeFalse())
 		})
 	})
 
 	XDescribe("close", func() {
-		It("closes its manager", func() {
-			scl, err := newSCMListener()
+		It("does not close its backend", func() {
+			b, err := ConnectSCM()
 			Expect(err).To(BeNil())
+			defer b.Close()
+			scl := newSCMListener(b)
 			Expect(scl.Close()).To(Succeed())
-			Expect(isValidHandle(scl.manager.Handle)).To(BeFalse())
+			Expect(isValidHandle(handleOf(b))).To(BeTrue())
 		})
 	})
 
-	FIt("should notify for status creating", func() {
-		fmt.Println("before newSCMListener: ", time.Now().Unix())
-		scl, err := newSCMListener()
-		fmt.Println("after newSCMListener: ", time.Now().Unix())
+	It("should notify for status creating", func() {
+		b, err := ConnectSCM()
 		Expect(err).To(BeNil())
+		defer b.Close()
+		scl := newSCMListener(b)
 		defer scl.Close()
 
 		svcName := serviceName()
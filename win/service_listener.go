@@ -1,34 +1,39 @@
 package win
 
 import (
-	"fmt"
-	"runtime"
-	"strconv"
-	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
-
-	"golang.org/x/sys/windows/svc/mgr"
-
-	"monitor/errno"
 )
 
 type ServiceListener struct {
 	Name    string
 	State   ServiceNotification
-	Service *mgr.Service
+	Status  SERVICE_STATUS_PROCESS
+	Config  Config        // Configuration when monitoring started
+	Service ServiceHandle // Replaced when reloading, see handle
 
 	updates chan Notification
 	halt    chan struct{}
+	closing int32  // Set by Close
+	seq     uint64 // Event sequence number
+	polled  bool   // Status is updated by the Supervisor's poller
+
+	// reopen opens the service when reloading its handle, the handle
+	// is not reloaded if nil.
+	reopen     func(name string) (ServiceHandle, error)
+	backoffMin time.Duration
+	backoffMax time.Duration
+	mu         *sync.Mutex // Service mutex, a pointer so listeners can be copied
 }
 
-func newServiceListener(name string, svc *mgr.Service) *ServiceListener {
+func newServiceListener(name string, svc ServiceHandle, updates chan Notification) *ServiceListener {
 	s := &ServiceListener{
 		Name:    name,
 		Service: svc,
-		updates: make(chan Notification, 10),
+		updates: updates,
 		halt:    make(chan struct{}),
+		mu:      new(sync.Mutex),
 	}
 	return s
 }
@@ -45,20 +50,48 @@ func (s *ServiceListener) closed() bool {
 	}
 }
 
+// Close stops the listener and closes its service handle, it is safe to
+// call Close more than once.
 func (s *ServiceListener) Close() (err error) {
-	if !s.closed() && s.halt != nil {
-		fmt.Println("Before s.halt:", s.halt)
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		return nil
+	}
+	if s.halt != nil {
 		close(s.halt)
-		fmt.Println("After s.halt:", s.halt)
 	}
+	s.lock()
 	if s.Service != nil {
-		fmt.Println("closing service", goid())
 		err = s.Service.Close()
-		fmt.Println("closed service", goid())
 	}
+	s.unlock()
 	return err
 }
 
+// handle returns the current service handle.
+func (s *ServiceListener) handle() ServiceHandle {
+	s.lock()
+	defer s.unlock()
+	return s.Service
+}
+
+// Healthy reports whether the monitored service is running or expected to
+// be stopped, see Service.Healthy.
+func (s *ServiceListener) Healthy() bool { return healthy(&s.Config, &s.Status) }
+
+// lock locks the Service mutex, listeners that are not created by
+// newServiceListener have none and are never reloaded.
+func (s *ServiceListener) lock() {
+	if s.mu != nil {
+		s.mu.Lock()
+	}
+}
+
+func (s *ServiceListener) unlock() {
+	if s.mu != nil {
+		s.mu.Unlock()
+	}
+}
+
 // func (s ServiceListener) String() string {
 // const format = "{Name: %s Status: %s Service: %v}"
 // return fmt.Sprintf(format, s.Name, s.Status, s.Service != nil)
@@ -74,91 +107,137 @@ func (s *ServiceListener) notify(n *ServiceNotify, act MonitorAction) {
 		select {
 		case s.updates <- notify:
 			// Ok
-		case <-time.After(time.Millisecond * 50):
-			// Error
-			// WARN: Dev only
-			fmt.Printf("Notify Timout: %+v\n", notify)
+		case <-s.halt:
+			// Closed
 		}
 	}
 }
 
-func goid() int {
-	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
-	id, err := strconv.Atoi(idField)
-	if err != nil {
-		panic(fmt.Sprintf("cannot get goroutine id: %v", err))
+// notifyError reports that registering for notifications failed, errors
+// caused by closing the listener are not reported.
+func (s *ServiceListener) notifyError(err error) {
+	if s.closed() {
+		return
+	}
+	notify := Notification{
+		Name:   s.Name,
+		Action: ActionDelete,
+		Err:    err,
+	}
+	select {
+	case s.updates <- notify:
+	case <-s.halt:
 	}
-	return id
 }
 
 func (s *ServiceListener) notifyStatusChange() {
-	const (
-		Duration           = 1000 // milliseconds
-		Alertable          = 1
-		WAIT_IO_COMPLETION = 192
-	)
 	const mask = SERVICE_NOTIFY_CONTINUE_PENDING | SERVICE_NOTIFY_DELETE_PENDING |
 		SERVICE_NOTIFY_PAUSE_PENDING | SERVICE_NOTIFY_PAUSED |
 		SERVICE_NOTIFY_RUNNING | SERVICE_NOTIFY_START_PENDING |
 		SERVICE_NOTIFY_STOP_PENDING | SERVICE_NOTIFY_STOPPED
 
-	var notify *SERVICE_NOTIFY
-	callback := func(p uintptr) uintptr {
-		if p != 0 {
-			notify = (*SERVICE_NOTIFY)(unsafe.Pointer(p))
+	for {
+		if s.closed() {
+			break
+		}
+		n, err := s.handle().WaitNotify(mask, notifyTimeout)
+		if err != nil {
+			if s.reopen == nil || !isDisconnected(err) {
+				s.notifyError(err)
+				break
+			}
+			if !s.reload(err) {
+				break
+			}
+			continue
+		}
+		if n != nil {
+			s.notify(n, ActionSuccess)
+			if n.NotificationTriggered == SERVICE_NOTIFY_DELETE_PENDING {
+				break
+			}
 		}
-		return 1
 	}
+}
 
-	statusNotify := SERVICE_NOTIFY{
-		Version:        SERVICE_NOTIFY_STATUS_CHANGE,
-		NotifyCallback: syscall.NewCallback(callback),
+// reload closes the service handle and reopens it, retrying with
+// exponential backoff.  The status of the reopened service is sent as an
+// ActionReload notification with the cause of the reload.  An error that
+// persists once the backoff reaches backoffMax is sent, once, as an
+// ActionReload notification without a status.  Reload returns false if the
+// listener was closed or the service deleted.
+func (s *ServiceListener) reload(cause error) bool {
+	s.lock()
+	if s.Service != nil {
+		s.Service.Close() // Usually fails for stale handles
+		s.Service = nil
 	}
+	s.unlock()
 
-	defer s.Close()
+	delay := s.backoffMin
+	reported := false
 	for {
-		fmt.Println("ServiceListener called", goid())
-		if s.closed() {
-			fmt.Println("ServiceListener closed", goid())
-			break
+		t := time.NewTimer(delay)
+		select {
+		case <-s.halt:
+			t.Stop()
+			return false
+		case <-t.C:
 		}
-		fmt.Println("ServiceListener not closed", s.Service.Handle, goid())
-		r1, _, _ := syscall.Syscall(
-			procNotifyServiceStatusChange.Addr(),
-			3,
-			uintptr(s.Service.Handle),              // hService
-			uintptr(mask),                          // dwNotifyMask
-			uintptr(unsafe.Pointer(&statusNotify)), // pNotifyBuffer
-		)
-		var act MonitorAction
-		switch errno.Errno(r1) {
-		case errno.ERROR_SUCCESS:
-			act = ActionSuccess
-		default:
-			act = ActionDelete
+		svc, err := s.reopen(s.Name)
+		op := "OpenService"
+		var status SERVICE_STATUS_PROCESS
+		if err == nil {
+			if status, err = svc.Query(); err != nil {
+				op = "QueryServiceStatusEx"
+				svc.Close()
+			}
 		}
-		if act != ActionSuccess {
-			s.notify(newServiceNotify(nil), act)
-			fmt.Println("ServiceListener Not ActionSuccess")
-			break
+		if err == nil {
+			s.lock()
+			if s.closed() {
+				s.unlock()
+				svc.Close()
+				return false
+			}
+			s.Service = svc
+			s.unlock()
+			notify := Notification{
+				Name: s.Name,
+				Notify: &ServiceNotify{
+					ServiceStatus:         status,
+					NotificationTriggered: notificationForState(status.CurrentState),
+				},
+				Action: ActionReload,
+				Err:    cause,
+			}
+			select {
+			case s.updates <- notify:
+			case <-s.halt:
+				return false
+			}
+			return true
 		}
-
-		r1, _, _ = syscall.Syscall(
-			procSleepEx.Addr(),
-			uintptr(2),
-			uintptr(Duration),
-			uintptr(Alertable),
-			uintptr(0),
-		)
-		fmt.Println("ServiceListener woke up")
-		if r1 == WAIT_IO_COMPLETION {
-			s.notify(newServiceNotify(notify), act)
-			if notify.NotificationTriggered == SERVICE_NOTIFY_DELETE_PENDING {
-				fmt.Println("ServiceListener is deleting")
-				break
+		if isDeleted(err) {
+			s.notifyError(err)
+			return false
+		}
+		// Disconnects are reported by reconnecting to the SCM.
+		if delay >= s.backoffMax && !reported && !isDisconnected(err) {
+			reported = true
+			notify := Notification{
+				Name:   s.Name,
+				Action: ActionReload,
+				Err:    listenerError(s.Name, op, err),
 			}
+			select {
+			case s.updates <- notify:
+			case <-s.halt:
+				return false
+			}
+		}
+		if delay *= 2; delay > s.backoffMax {
+			delay = s.backoffMax
 		}
 	}
 }
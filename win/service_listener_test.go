@@ -1,149 +1,139 @@
 package win
 
 import (
-	"fmt"
+	"errors"
 	"time"
 
-	svcpkg "golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/mgr"
+	"monitor/errno"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-var _ = svcpkg.Stop
-
-func serviceName() string {
-	return fmt.Sprintf("test-%d", time.Now().UnixNano())
-}
-
-var _ = Describe("ServiceListener", func() {
-	const description = "vcap"
+var _ = Describe("ServiceListener reload", func() {
 	var (
-		svcName string
+		backend *FakeBackend
+		s       *Supervisor
+		conf    = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
 	)
-	var config = mgr.Config{
-		Description: description,
+
+	nextEvent := func() Event {
+		var e Event
+		Eventually(s.Events()).Should(Receive(&e))
+		return e
+	}
+
+	openHandles := func(name string) int {
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		return len(backend.services[name].handles)
 	}
 
-	var (
-		mgrMgr  *mgr.Mgr
-		service *mgr.Service
-	)
 	BeforeEach(func() {
-		svcName = serviceName()
-		config.DisplayName = svcName
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", conf, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", conf, running(SERVICE_WIN32_OWN_PROCESS))
 
 		var err error
-		mgrMgr, service, err = buildAndInstall(svcName, config)
-		Expect(err).ToNot(HaveOccurred())
+		s, err = startSupervisor(func(_ string, c *Config) bool {
+			return c.Description == "vcap"
+		}, WithBackend(backend), WithReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+		Expect(err).To(BeNil())
 	})
 
-	// AfterEach(func() {
-	// service.Close()
-	// //deleteService(mgrMgr, svcName)
-	// mgrMgr.Disconnect()
-	// })
-
-	FIt("should notify for status starting", func() {
-		svc := newServiceListener(svcName, &service)
-		svc := &ServiceListener{
-			Name:    svcName,
-			Service: service,
-
-			updates: make(chan Notification, 1),
-			halt:    make(chan struct{}),
-		}
-		defer svc.Close()
-
-		// Start service
-		Expect(service.Start()).To(Succeed())
+	AfterEach(func() {
+		s.Close()
+	})
 
-		defer func() {
-			service.Close()
-			deleteService(mgrMgr, svcName)
-			mgrMgr.Disconnect()
-		}()
+	It("reopens stale handles and keeps the state history", func() {
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		e := nextEvent()
+		Expect(e.Seq).To(Equal(uint64(1)))
+
+		backend.InvalidateHandles("svc-1")
+		e = nextEvent()
+		Expect(e.Type).To(Equal(ServiceReloaded))
+		Expect(e.Service).To(Equal("svc-1"))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_STOPPED))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		Expect(e.Seq).To(Equal(uint64(2)))
+		Expect(openHandles("svc-1")).To(Equal(1))
+
+		backend.SetState("svc-1", SERVICE_RUNNING)
+		e = nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_STOPPED))
+		Expect(e.Seq).To(Equal(uint64(3)))
+		Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-2"))
+	})
 
-		go svc.notifyStatusChange()
+	It("reports status changes missed while reloading", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		backend.InvalidateHandles("svc-1")
+		backend.SetState("svc-1", SERVICE_PAUSED)
+		Consistently(s.Events(), 30*time.Millisecond).ShouldNot(Receive())
+
+		backend.SetOpenError("svc-1", nil)
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceReloaded))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_PAUSED))
+	})
 
-		update := <-svc.updates
-		Expect(update.Name).To(Equal(svcName))
+	It("emits the status of notifications that report a later transition", func() {
+		// The service stopped and was restarted before the notification
+		// was delivered.
+		status := running(SERVICE_WIN32_OWN_PROCESS)
+		backend.Notify("svc-2", ServiceNotify{
+			ServiceStatus:         status,
+			NotificationTriggered: SERVICE_NOTIFY_STOPPED,
+		})
+		e := nextEvent()
+		Expect(e.Type).To(Equal(StateChanged))
+		Expect(e.Service).To(Equal("svc-2"))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_RUNNING))
+		Consistently(s.Events(), 30*time.Millisecond).ShouldNot(Receive())
+		Expect(openHandles("svc-2")).To(Equal(1))
+	})
 
-		Expect(update.Notify.NotificationTriggered).To(Or(
-			Equal(SERVICE_NOTIFY_RUNNING),
-			Equal(SERVICE_NOTIFY_START_PENDING),
-		))
+	It("reports errors that persist while reloading", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_ACCESS_DENIED))
+		backend.InvalidateHandles("svc-1")
 
+		var err error
+		Eventually(s.Errors()).Should(Receive(&err))
+		var lerr *ListenerError
+		Expect(errors.As(err, &lerr)).To(BeTrue())
+		Expect(lerr.Service).To(Equal("svc-1"))
+		Expect(lerr.Op).To(Equal("OpenService"))
+		Expect(lerr.Errno).To(Equal(errno.Errno(errno.ERROR_ACCESS_DENIED)))
+		Consistently(s.Errors(), 50*time.Millisecond).ShouldNot(Receive(), "reported once")
+		Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-2"))
+
+		backend.SetOpenError("svc-1", nil)
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceReloaded))
+		Expect(e.Service).To(Equal("svc-1"))
 	})
 
-	XIt("should notify for status deleting", func() {
-		svc := &ServiceListener{
-			Name:    svcName,
-			Service: service,
-
-			updates: make(chan Notification, 1),
-			halt:    make(chan struct{}),
-		}
-		defer svc.Close()
-
-		defer func() {
-			mgrMgr.Disconnect()
-		}()
-
-		// Start service
-		Expect(svc.Service.Start()).To(Succeed())
-		go svc.notifyStatusChange()
-		update := <-svc.updates
-		Expect(update.Name).To(Equal(svcName))
-
-		service.Close()
-		deleteService(mgrMgr, svcName)
-		//Expect(svc.Service.Delete()).To(Succeed())
-		Eventually(func() ServiceNotification {
-			update := <-svc.updates
-			return update.Notify.NotificationTriggered
-		}).Should(Equal(SERVICE_NOTIFY_DELETE_PENDING))
-
-		Eventually(func() bool {
-			return isValidHandle(svc.Service.Handle)
-		}).Should(BeFalse())
-
-		Expect(svc.closed()).To(BeTrue())
+	It("stops monitoring services deleted while reloading", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		backend.InvalidateHandles("svc-1")
+		time.Sleep(10 * time.Millisecond)
+		backend.SetOpenError("svc-1", nil)
+		backend.DeleteService("svc-1")
+
+		e := nextEvent()
+		Expect(e.Type).To(Equal(ServiceRemoved))
+		Expect(e.Service).To(Equal("svc-1"))
+		Consistently(s.Events(), 30*time.Millisecond).ShouldNot(Receive())
 	})
 
+	It("stops reloading when closed", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_NOT_ENOUGH_MEMORY))
+		backend.InvalidateHandles("svc-1")
+		time.Sleep(10 * time.Millisecond)
+		Expect(s.Close()).To(Succeed())
+		Expect(openHandles("svc-1")).To(BeZero())
+	})
 })
-
-// It("keeps in sync with state changes to the service", func() {
-// 	svcName := serviceName()
-// 	displayName := "display name"
-// 	const description = "a description"
-
-// 	manager, err := NewManager()
-// 	Expect(err).ToNot(HaveOccurred())
-// 	defer manager.Close()
-
-// 	err = manager.Monitor(svcName)
-// 	Expect(err).To(HaveOccurred())
-
-// 	exePath, err := build(svcName)
-// 	Expect(err).ToNot(HaveOccurred())
-
-// 	err = install(manager.mgr, svcName, exePath, mgr.Config{
-// 		DisplayName: displayName,
-// 		Description: description,
-// 	})
-// 	Expect(err).ToNot(HaveOccurred())
-
-// 	testServiceListener, err := manager.mgr.OpenService(svcName)
-// 	Expect(err).ToNot(HaveOccurred())
-// 	defer remove(testServiceListener)
-// 	Expect(testServiceListener.Start()).To(Succeed())
-
-// 	Expect(manager.Monitor(svcName)).To(Succeed())
-// 	Eventually(func() ServiceState {
-// 		return manager.Services()[0].State
-// 	}).Should(Equal(SERVICE_RUNNING))
-
-// 	testServiceListener.Control(svc.Stop)
-// })
@@ -0,0 +1,50 @@
+package win
+
+import "sync"
+
+// DefaultStartupParallelism is the number of services opened concurrently
+// by NewSupervisor, see WithStartupParallelism.  Services are opened one
+// at a time so that filters need not be safe for concurrent use.
+const DefaultStartupParallelism = 1
+
+// WithStartupParallelism sets the number of services NewSupervisor opens,
+// queries and filters concurrently, n < 1 is treated as 1.  Filters must
+// be safe for concurrent use when n > 1.  The services are monitored and
+// their errors returned by StartupError in enumeration order regardless
+// of n.
+func WithStartupParallelism(n int) Option {
+	return func(o *options) { o.parallelism = n }
+}
+
+// newListeners calls newListener for each of names with up to
+// s.parallelism workers.  The listeners, which are not started, and the
+// errors are returned in the order of names.
+func (s *Supervisor) newListeners(names []string) ([]*ServiceListener, []error) {
+	listeners := make([]*ServiceListener, len(names))
+	errs := make([]error, len(names))
+
+	workers := s.parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(names) {
+		workers = len(names)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				listeners[i], errs[i] = s.newListener(names[i], nil)
+			}
+		}()
+	}
+	for i := range names {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return listeners, errs
+}
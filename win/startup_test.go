@@ -0,0 +1,170 @@
+package win
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Startup enumeration", func() {
+	var (
+		backend *FakeBackend
+		vcap    Config
+		other   Config
+		filter  Filter
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		vcap = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap"}
+		other = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+		filter = func(_ string, conf *Config) bool {
+			return conf.Description == "vcap"
+		}
+	})
+
+	DescribeTable("monitors the same services with any parallelism",
+		func(n int) {
+			var expected []string
+			for i := 0; i < 40; i++ {
+				name := fmt.Sprintf("svc-%02d", i)
+				if i%3 == 0 {
+					backend.AddService(name, other, running(SERVICE_WIN32_OWN_PROCESS))
+					continue
+				}
+				backend.AddService(name, vcap, running(SERVICE_WIN32_OWN_PROCESS))
+				expected = append(expected, name)
+			}
+			s, err := startSupervisor(filter, WithBackend(backend), WithStartupParallelism(n))
+			Expect(err).To(BeNil())
+			defer s.Close()
+			Expect(serviceNames(s)).To(ConsistOf(expected))
+		},
+		Entry("default", DefaultStartupParallelism),
+		Entry("parallel", 8),
+		Entry("invalid", 0),
+		Entry("sequential", 1),
+		Entry("more workers than services", 64),
+	)
+
+	It("reports errors in enumeration order", func() {
+		for i := 0; i < 20; i++ {
+			backend.AddService(fmt.Sprintf("svc-%02d", i), vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		}
+		for _, name := range []string{"svc-17", "svc-03", "svc-09"} {
+			backend.SetOpenError(name, errno.Errno(errno.ERROR_SERVICE_DISABLED))
+		}
+		backend.SetLatency(time.Millisecond)
+
+		s, err := startSupervisor(filter, WithBackend(backend), WithStartupParallelism(8))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(s.Services()).To(HaveLen(17))
+
+		joined, ok := s.StartupError().(interface{ Unwrap() []error })
+		Expect(ok).To(BeTrue())
+		var failed []string
+		for _, err := range joined.Unwrap() {
+			lerr, ok := err.(*ListenerError)
+			Expect(ok).To(BeTrue())
+			Expect(lerr.Op).To(Equal("OpenService"))
+			failed = append(failed, lerr.Service)
+		}
+		Expect(failed).To(Equal([]string{"svc-03", "svc-09", "svc-17"}))
+		Expect(errors.Is(s.StartupError(), errno.Errno(errno.ERROR_SERVICE_DISABLED))).To(BeTrue())
+	})
+
+	It("does not return errors of deleted services", func() {
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.SetOpenError("svc-2", errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST))
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
+		Expect(s.StartupError()).To(BeNil())
+	})
+
+	It("opens at most the configured number of services at once", func() {
+		for i := 0; i < 20; i++ {
+			backend.AddService(fmt.Sprintf("svc-%02d", i), vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		}
+		var active, peak int32
+		counting := func(name string, conf *Config) bool {
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			return filter(name, conf)
+		}
+
+		s, err := startSupervisor(counting, WithBackend(backend), WithStartupParallelism(4))
+		Expect(err).To(BeNil())
+		Expect(s.Close()).To(Succeed())
+		Expect(atomic.LoadInt32(&peak)).To(BeNumerically(">", 1))
+		Expect(atomic.LoadInt32(&peak)).To(BeNumerically("<=", 4))
+
+		backend = NewFakeBackend()
+		for i := 0; i < 5; i++ {
+			backend.AddService(fmt.Sprintf("svc-%02d", i), vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		}
+		peak = 0
+		s, err = startSupervisor(counting, WithBackend(backend), WithStartupParallelism(1))
+		Expect(err).To(BeNil())
+		Expect(s.Close()).To(Succeed())
+		Expect(atomic.LoadInt32(&peak)).To(BeEquivalentTo(1))
+	})
+})
+
+// BenchmarkNewSupervisor measures starting a Supervisor on a host with 300
+// services, each call to the fake backend takes 50µs.  The Supervisor polls
+// so that closing it does not wait for notification timeouts.
+func BenchmarkNewSupervisor(b *testing.B) {
+	backend := NewFakeBackend()
+	for i := 0; i < 300; i++ {
+		conf := Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+		if i%10 == 0 {
+			conf.Description = "vcap"
+		}
+		backend.AddService(fmt.Sprintf("svc-%03d", i), conf, running(SERVICE_WIN32_OWN_PROCESS))
+	}
+	backend.SetLatency(50 * time.Microsecond)
+	filter := func(_ string, conf *Config) bool {
+		return strings.EqualFold(conf.Description, "vcap")
+	}
+
+	for _, n := range []int{1, 4, 8, 32} {
+		b.Run(fmt.Sprintf("parallelism=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s, err := NewSupervisor(filter, WithBackend(noClose{backend}),
+					WithPolling(time.Hour), WithStartupParallelism(n))
+				if err != nil {
+					b.Fatal(err)
+				}
+				s.Close()
+			}
+		})
+	}
+}
+
+// noClose is an SCMBackend that is not closed with the Supervisor, so it
+// can be reused across benchmark iterations.
+type noClose struct {
+	SCMBackend
+}
+
+func (noClose) Close() error { return nil }
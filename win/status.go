@@ -0,0 +1,91 @@
+package win
+
+import (
+	"errors"
+
+	"monitor/errno"
+)
+
+// StatusOptions selects the services returned by ServiceStatuses.
+type StatusOptions struct {
+	// Type is the type of the services enumerated, SERVICE_WIN32 if zero.
+	Type ServiceType
+
+	// Config looks up the configuration of each service, which requires
+	// opening a handle to it.  Config is implied by Filter.
+	Config bool
+
+	// Filter selects the services returned, all services are returned
+	// if it is nil.
+	Filter Filter
+}
+
+// ServiceStatuses returns the services enumerated by b.  The status of each
+// service is taken from the enumeration, a handle is only opened, and then
+// closed immediately, to look up the configuration.  Services that access
+// is denied to, and services deleted after the enumeration, are skipped.
+// The errors of other services are joined and returned with the services
+// that were looked up, which are valid even if the error is not nil.
+func ServiceStatuses(b SCMBackend, opts StatusOptions) ([]Service, error) {
+	typ := opts.Type
+	if typ == 0 {
+		typ = SERVICE_WIN32
+	}
+	procs, err := b.ListServices(typ)
+	if err != nil {
+		return nil, listenerError("", "EnumServicesStatusEx", err)
+	}
+	services := make([]Service, 0, len(procs))
+	if !opts.Config && opts.Filter == nil {
+		for _, p := range procs {
+			services = append(services, Service{
+				Name:        p.ServiceName,
+				DisplayName: p.DisplayName,
+				Status:      p.ServiceStatusProcess,
+			})
+		}
+		return services, nil
+	}
+
+	var errs []error
+	for _, p := range procs {
+		svc, conf, err := lookupService(b, p.ServiceName)
+		if err != nil {
+			if !isDeleted(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if svc == nil {
+			continue // Access denied
+		}
+		svc.Close()
+		if opts.Filter != nil && !opts.Filter(p.ServiceName, conf) {
+			continue
+		}
+		services = append(services, Service{
+			Name:        p.ServiceName,
+			DisplayName: p.DisplayName,
+			Config:      *conf,
+			Status:      p.ServiceStatusProcess,
+		})
+	}
+	return services, errors.Join(errs...)
+}
+
+// healthy reports whether a service with configuration conf is in a state
+// expected from its configuration.  A stopped service is unhealthy if it
+// exited with an error, or if it is an automatic service that is not
+// started by a trigger: trigger started services stop when they are idle
+// or a stop trigger fires.
+func healthy(conf *Config, status *SERVICE_STATUS_PROCESS) bool {
+	if status.CurrentState != SERVICE_STOPPED {
+		return true
+	}
+	switch errno.Errno(status.Win32ExitCode) {
+	case errno.ERROR_SUCCESS, errno.ERROR_SERVICE_NEVER_STARTED:
+	default:
+		return false
+	}
+	return conf.StartType != SERVICE_AUTO_START || conf.TriggerStart()
+}
@@ -0,0 +1,130 @@
+package win
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"monitor/errno"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceStatuses", func() {
+	var (
+		backend *FakeBackend
+		vcap    Config
+		other   Config
+	)
+
+	statusNames := func(services []Service) []string {
+		var names []string
+		for _, s := range services {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		vcap = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "vcap", DisplayName: "VCAP"}
+		other = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", other, SERVICE_STATUS_PROCESS{
+			ServiceType:  SERVICE_WIN32_OWN_PROCESS,
+			CurrentState: SERVICE_STOPPED,
+		})
+	})
+
+	It("returns the status from the enumeration without opening services", func() {
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_INVALID_NAME))
+		backend.SetOpenError("svc-2", errno.Errno(errno.ERROR_INVALID_NAME))
+
+		services, err := ServiceStatuses(backend, StatusOptions{})
+		Expect(err).To(BeNil())
+		Expect(statusNames(services)).To(Equal([]string{"svc-1", "svc-2"}))
+		Expect(services[0].DisplayName).To(Equal("VCAP"))
+		Expect(services[0].Status.CurrentState).To(Equal(SERVICE_RUNNING))
+		Expect(services[1].Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		Expect(services[0].Config).To(BeZero())
+	})
+
+	It("looks up the configuration and closes the handles", func() {
+		services, err := ServiceStatuses(backend, StatusOptions{Config: true})
+		Expect(err).To(BeNil())
+		Expect(services).To(HaveLen(2))
+		Expect(services[0].Config).To(Equal(vcap))
+		Expect(services[1].Config).To(Equal(other))
+
+		backend.mu.Lock()
+		defer backend.mu.Unlock()
+		for _, svc := range backend.services {
+			Expect(svc.handles).To(BeEmpty())
+		}
+	})
+
+	It("filters services", func() {
+		services, err := ServiceStatuses(backend, StatusOptions{
+			Filter: MustParseFilter(`description == "vcap"`),
+		})
+		Expect(err).To(BeNil())
+		Expect(statusNames(services)).To(Equal([]string{"svc-1"}))
+	})
+
+	It("joins the errors of services that cannot be looked up", func() {
+		backend.AddService("svc-3", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_INVALID_NAME))
+		backend.SetOpenError("svc-2", errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+		services, err := ServiceStatuses(backend, StatusOptions{Config: true})
+		Expect(statusNames(services)).To(Equal([]string{"svc-3"}))
+		var le *ListenerError
+		Expect(errors.As(err, &le)).To(BeTrue())
+		Expect(le.Service).To(Equal("svc-1"))
+		Expect(err.Error()).NotTo(ContainSubstring("svc-2"))
+	})
+
+	It("skips services deleted after the enumeration", func() {
+		backend.AddService("svc-3", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.SetOpenError("svc-1", errno.Errno(errno.ERROR_SERVICE_DOES_NOT_EXIST))
+		backend.SetOpenError("svc-2", errno.Errno(errno.ERROR_SERVICE_MARKED_FOR_DELETE))
+
+		services, err := ServiceStatuses(backend, StatusOptions{Config: true})
+		Expect(err).To(BeNil())
+		Expect(statusNames(services)).To(Equal([]string{"svc-3"}))
+	})
+
+	It("returns enumeration errors", func() {
+		backend.Close()
+		_, err := ServiceStatuses(backend, StatusOptions{})
+		Expect(isErrno(err, errno.ERROR_INVALID_HANDLE)).To(BeTrue())
+	})
+})
+
+// BenchmarkServiceStatuses compares reading the status of 300 services from
+// the enumeration with opening each service, each call to the fake backend
+// takes 50µs.
+func BenchmarkServiceStatuses(b *testing.B) {
+	backend := NewFakeBackend()
+	for i := 0; i < 300; i++ {
+		backend.AddService(fmt.Sprintf("svc-%03d", i), Config{}, running(SERVICE_WIN32_OWN_PROCESS))
+	}
+	backend.SetLatency(50 * time.Microsecond)
+
+	b.Run("enumeration", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ServiceStatuses(backend, StatusOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("open", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ServiceStatuses(backend, StatusOptions{Config: true}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
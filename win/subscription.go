@@ -0,0 +1,243 @@
+package win
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// ErrClosed is returned when subscribing to a closed Supervisor.
+var ErrClosed = errors.New("win: supervisor closed")
+
+// DefaultSubscriptionBuffer is the queue size of a Subscription when
+// SubscribeOptions.Buffer is zero.
+const DefaultSubscriptionBuffer = 64
+
+// OverflowPolicy determines what happens when an event is published to a
+// Subscription whose queue is full.
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // Wait for the subscriber to make room
+	OverflowDropOldest                       // Drop the oldest queued event
+	OverflowDropNewest                       // Drop the published event
+	OverflowCoalesce                         // Merge with the queued event of the same service
+)
+
+var overflowPolicyStr = [...]string{
+	"OverflowBlock",
+	"OverflowDropOldest",
+	"OverflowDropNewest",
+	"OverflowCoalesce",
+}
+
+func (p OverflowPolicy) String() string {
+	if p >= 0 && int(p) < len(overflowPolicyStr) {
+		return overflowPolicyStr[p]
+	}
+	return strconv.Itoa(int(p))
+}
+
+type SubscribeOptions struct {
+	// Buffer is the number of events that may be queued for the
+	// subscriber, DefaultSubscriptionBuffer is used if zero.
+	Buffer int
+
+	// Overflow is the policy applied when the queue is full.  With
+	// OverflowCoalesce an event published to a full queue is merged with
+	// the newest queued event of the same service, the oldest event is
+	// dropped if there is none.
+	Overflow OverflowPolicy
+}
+
+// SubscriptionStats are the event counters of a Subscription.
+type SubscriptionStats struct {
+	Delivered uint64
+	Dropped   uint64
+	Coalesced uint64
+}
+
+// A Subscription is a consumer of Supervisor events with its own queue.
+type Subscription struct {
+	opts   SubscribeOptions
+	out    chan Event
+	done   chan struct{}
+	cancel func(*Subscription)
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	stats  SubscriptionStats
+	closed bool
+}
+
+func newSubscription(opts SubscribeOptions, cancel func(*Subscription)) (*Subscription, error) {
+	if opts.Buffer < 0 {
+		return nil, errors.New("win: negative subscription buffer")
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = DefaultSubscriptionBuffer
+	}
+	if opts.Overflow < OverflowBlock || opts.Overflow > OverflowCoalesce {
+		return nil, errors.New("win: invalid overflow policy: " + opts.Overflow.String())
+	}
+	s := &Subscription{
+		opts:   opts,
+		out:    make(chan Event),
+		done:   make(chan struct{}),
+		cancel: cancel,
+		queue:  make([]Event, 0, opts.Buffer),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.deliver()
+	return s, nil
+}
+
+// Events returns the channel on which events are delivered, it is closed
+// when the Subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.out
+}
+
+// Stats returns the number of events delivered, dropped and coalesced.
+func (s *Subscription) Stats() SubscriptionStats {
+	s.mu.Lock()
+	st := s.stats
+	s.mu.Unlock()
+	return st
+}
+
+// Close stops delivery of events, queued events are discarded.
+func (s *Subscription) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel(s)
+	}
+	return nil
+}
+
+// publish queues e according to the Subscription's overflow policy.
+func (s *Subscription) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.Overflow == OverflowCoalesce && len(s.queue) >= s.opts.Buffer {
+		for i := len(s.queue) - 1; i >= 0; i-- {
+			if s.queue[i].Service == e.Service {
+				s.queue[i] = coalesce(s.queue[i], e)
+				s.stats.Coalesced++
+				return
+			}
+		}
+	}
+	for len(s.queue) >= s.opts.Buffer && !s.closed {
+		switch s.opts.Overflow {
+		case OverflowBlock:
+			s.cond.Wait()
+		case OverflowDropOldest, OverflowCoalesce:
+			s.queue = append(s.queue[:0], s.queue[1:]...)
+			s.stats.Dropped++
+		case OverflowDropNewest:
+			s.stats.Dropped++
+			return
+		}
+	}
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, e)
+	s.cond.Broadcast()
+}
+
+func (s *Subscription) deliver() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		e := s.queue[0]
+		s.queue = append(s.queue[:0], s.queue[1:]...)
+		s.cond.Broadcast() // Wake blocked publishers
+		s.mu.Unlock()
+
+		select {
+		case s.out <- e:
+			s.mu.Lock()
+			s.stats.Delivered++
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// coalesce merges the queued event old with the newer event e of the
+// same service.
+func coalesce(old, e Event) Event {
+	merged := e
+	merged.Previous = old.Previous
+	if (old.Type == ServiceAdded || old.Type == WatchStarted) && e.Type == StateChanged {
+		merged.Type = old.Type
+	}
+	return merged
+}
+
+// Subscribe returns a new Subscription to the Supervisor's events.
+func (s *Supervisor) Subscribe(opts SubscribeOptions) (*Subscription, error) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.subs == nil {
+		return nil, ErrClosed
+	}
+	sub, err := newSubscription(opts, s.unsubscribe)
+	if err != nil {
+		return nil, err
+	}
+	s.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+func (s *Supervisor) unsubscribe(sub *Subscription) {
+	s.subsMu.Lock()
+	if s.subs != nil {
+		delete(s.subs, sub)
+	}
+	s.subsMu.Unlock()
+}
+
+// publish sends e to all subscribers.
+func (s *Supervisor) publish(e Event) {
+	s.subsMu.Lock()
+	subs := make([]*Subscription, 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.subsMu.Unlock()
+	for _, sub := range subs {
+		sub.publish(e)
+	}
+}
+
+// closeSubscriptions closes all subscriptions, later calls to Subscribe
+// return ErrClosed.
+func (s *Supervisor) closeSubscriptions() {
+	s.subsMu.Lock()
+	subs := s.subs
+	s.subs = nil
+	s.subsMu.Unlock()
+	for sub := range subs {
+		sub.Close()
+	}
+}
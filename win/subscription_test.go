@@ -0,0 +1,220 @@
+package win
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func stateEvent(svc string, seq uint64, prev, cur ServiceState) Event {
+	return Event{
+		Type:     StateChanged,
+		Service:  svc,
+		Previous: SERVICE_STATUS_PROCESS{CurrentState: prev},
+		Status:   SERVICE_STATUS_PROCESS{CurrentState: cur},
+		Seq:      seq,
+	}
+}
+
+var _ = Describe("Subscription", func() {
+	var sub *Subscription
+
+	subscribe := func(opts SubscribeOptions) {
+		var err error
+		sub, err = newSubscription(opts, nil)
+		Expect(err).To(BeNil())
+	}
+
+	// publish publishes events while the deliver goroutine is blocked
+	// sending the first event.
+	publish := func(events ...Event) {
+		sub.publish(events[0])
+		Eventually(func() int {
+			sub.mu.Lock()
+			defer sub.mu.Unlock()
+			return len(sub.queue)
+		}).Should(BeZero())
+		for _, e := range events[1:] {
+			sub.publish(e)
+		}
+	}
+
+	receive := func() Event {
+		var e Event
+		Eventually(sub.Events()).Should(Receive(&e))
+		return e
+	}
+
+	AfterEach(func() {
+		sub.Close()
+	})
+
+	It("rejects invalid options", func() {
+		_, err := newSubscription(SubscribeOptions{Buffer: -1}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = newSubscription(SubscribeOptions{Overflow: OverflowPolicy(9)}, nil)
+		Expect(err).To(HaveOccurred())
+		subscribe(SubscribeOptions{})
+	})
+
+	It("drops the oldest events with OverflowDropOldest", func() {
+		subscribe(SubscribeOptions{Buffer: 2, Overflow: OverflowDropOldest})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 2, SERVICE_STOPPED, SERVICE_RUNNING),
+			stateEvent("a", 3, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 4, SERVICE_STOPPED, SERVICE_RUNNING),
+		)
+		Expect(receive().Seq).To(Equal(uint64(1)))
+		Expect(receive().Seq).To(Equal(uint64(3)))
+		Expect(receive().Seq).To(Equal(uint64(4)))
+		Eventually(sub.Stats).Should(Equal(SubscriptionStats{Delivered: 3, Dropped: 1}))
+	})
+
+	It("drops the newest events with OverflowDropNewest", func() {
+		subscribe(SubscribeOptions{Buffer: 2, Overflow: OverflowDropNewest})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 2, SERVICE_STOPPED, SERVICE_RUNNING),
+			stateEvent("a", 3, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 4, SERVICE_STOPPED, SERVICE_RUNNING),
+		)
+		Expect(receive().Seq).To(Equal(uint64(1)))
+		Expect(receive().Seq).To(Equal(uint64(2)))
+		Expect(receive().Seq).To(Equal(uint64(3)))
+		Eventually(sub.Stats).Should(Equal(SubscriptionStats{Delivered: 3, Dropped: 1}))
+	})
+
+	It("merges events of the same service with OverflowCoalesce", func() {
+		subscribe(SubscribeOptions{Buffer: 2, Overflow: OverflowCoalesce})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOP_PENDING),
+			stateEvent("b", 1, SERVICE_RUNNING, SERVICE_STOP_PENDING),
+			stateEvent("b", 2, SERVICE_STOP_PENDING, SERVICE_STOPPED),
+			stateEvent("b", 3, SERVICE_STOPPED, SERVICE_START_PENDING),
+		)
+		Expect(receive().Service).To(Equal("a"))
+		Expect(receive().Seq).To(Equal(uint64(1)))
+		e := receive()
+		Expect(e.Service).To(Equal("b"))
+		Expect(e.Seq).To(Equal(uint64(3)))
+		Expect(e.Previous.CurrentState).To(Equal(SERVICE_STOP_PENDING))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_START_PENDING))
+		Eventually(sub.Stats).Should(Equal(SubscriptionStats{Delivered: 3, Coalesced: 1}))
+	})
+
+	It("only coalesces when the queue is full", func() {
+		subscribe(SubscribeOptions{Buffer: 100, Overflow: OverflowCoalesce})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOP_PENDING),
+			stateEvent("a", 2, SERVICE_STOP_PENDING, SERVICE_STOPPED),
+			stateEvent("a", 3, SERVICE_STOPPED, SERVICE_START_PENDING),
+		)
+		for seq := uint64(1); seq <= 3; seq++ {
+			Expect(receive().Seq).To(Equal(seq))
+		}
+		Eventually(sub.Stats).Should(Equal(SubscriptionStats{Delivered: 3}))
+	})
+
+	It("keeps ServiceAdded when coalescing state changes", func() {
+		added := Event{Type: ServiceAdded, Service: "a"}
+		e := coalesce(added, stateEvent("a", 2, SERVICE_RUNNING, SERVICE_STOPPED))
+		Expect(e.Type).To(Equal(ServiceAdded))
+		Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+	})
+
+	It("blocks the publisher with OverflowBlock", func() {
+		subscribe(SubscribeOptions{Buffer: 1, Overflow: OverflowBlock})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 2, SERVICE_STOPPED, SERVICE_RUNNING),
+		)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sub.publish(stateEvent("a", 3, SERVICE_RUNNING, SERVICE_STOPPED))
+		}()
+		Consistently(done, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		Expect(receive().Seq).To(Equal(uint64(1)))
+		Eventually(done).Should(BeClosed())
+		Expect(receive().Seq).To(Equal(uint64(2)))
+		Expect(receive().Seq).To(Equal(uint64(3)))
+		Expect(sub.Stats().Dropped).To(BeZero())
+	})
+
+	It("closes its channel and unblocks publishers when closed", func() {
+		subscribe(SubscribeOptions{Buffer: 1, Overflow: OverflowBlock})
+		publish(
+			stateEvent("a", 1, SERVICE_RUNNING, SERVICE_STOPPED),
+			stateEvent("a", 2, SERVICE_STOPPED, SERVICE_RUNNING),
+		)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sub.publish(stateEvent("a", 3, SERVICE_RUNNING, SERVICE_STOPPED))
+		}()
+		Expect(sub.Close()).To(Succeed())
+		Eventually(done).Should(BeClosed())
+		Eventually(sub.Events()).Should(BeClosed())
+	})
+})
+
+var _ = Describe("Supervisor.Subscribe", func() {
+	var (
+		backend *FakeBackend
+		s       *Supervisor
+	)
+
+	BeforeEach(func() {
+		backend = NewFakeBackend()
+		backend.AddService("svc-1", Config{ServiceType: SERVICE_WIN32_OWN_PROCESS}, running(SERVICE_WIN32_OWN_PROCESS))
+
+		var err error
+		s, err = startSupervisor(func(string, *Config) bool { return true }, WithBackend(backend))
+		Expect(err).To(BeNil())
+	})
+
+	It("fans events out to every subscriber", func() {
+		sub1, err := s.Subscribe(SubscribeOptions{})
+		Expect(err).To(BeNil())
+		sub2, err := s.Subscribe(SubscribeOptions{Overflow: OverflowCoalesce})
+		Expect(err).To(BeNil())
+		defer s.Close()
+
+		backend.SetState("svc-1", SERVICE_STOPPED)
+
+		for _, sub := range []*Subscription{sub1, sub2} {
+			var e Event
+			Eventually(sub.Events()).Should(Receive(&e))
+			Expect(e.Type).To(Equal(StateChanged))
+			Expect(e.Status.CurrentState).To(Equal(SERVICE_STOPPED))
+		}
+	})
+
+	It("stops delivering to closed subscriptions", func() {
+		sub, err := s.Subscribe(SubscribeOptions{Buffer: 1, Overflow: OverflowBlock})
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(sub.Close()).To(Succeed())
+
+		// A closed blocking subscriber must not stall the Supervisor.
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		backend.SetState("svc-1", SERVICE_RUNNING)
+		backend.SetState("svc-1", SERVICE_STOPPED)
+		Eventually(func() ServiceState {
+			return s.Services()[0].Status.CurrentState
+		}).Should(Equal(SERVICE_STOPPED))
+	})
+
+	It("closes subscriptions when the Supervisor is closed", func() {
+		sub, err := s.Subscribe(SubscribeOptions{})
+		Expect(err).To(BeNil())
+		Expect(s.Close()).To(Succeed())
+
+		Eventually(sub.Events()).Should(BeClosed())
+		_, err = s.Subscribe(SubscribeOptions{})
+		Expect(err).To(Equal(ErrClosed))
+	})
+})
@@ -1,84 +1,301 @@
 package win
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"monitor/errno"
+	"sort"
 	"sync"
-	"syscall"
-	"unsafe"
-
-	"golang.org/x/sys/windows/svc/mgr"
+	"time"
 )
 
-type Filter func(svcName string, conf *mgr.Config) bool
-
 type Supervisor struct {
-	mgr              *mgr.Mgr
-	filter           Filter
+	options
+
+	filters          []filterEntry // Services matching any filter are monitored
+	nextFilterID     FilterID
+	filterMu         sync.Mutex // Serializes filter changes
 	serviceListeners map[string]*ServiceListener
 	scmListener      *SCMListener
 
-	updates chan Notification
-	halt    chan struct{}
+	updates chan Notification // ServiceListener notifications
+	events  *Subscription     // Returned by Events
+	errs    chan error        // Returned by Errors
+	halt    chan struct{}     // Closed by Close
 
 	mu sync.RWMutex // serviceListeners mutex
+	wg sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+
+	subs   map[*Subscription]struct{}
+	subsMu sync.Mutex
+	emitMu sync.Mutex // Publishes events in Seq order
+
+	pollSCM  bool                       // Poller detects created and deleted services
+	pollOnce sync.Once                  // Starts the poller
+	snapshot []EnumServiceStatusProcess // Last enumeration
+
+	reconnecting bool  // Reconnection in progress
+	startupErr   error // Returned by StartupError
+	running      bool  // Set by Run, goroutines are only started once running
 }
 
-func NewSupervisor(filter Filter) (*Supervisor, error) {
-	fmt.Println("NewSupervisor", 1)
-	mgr, err := mgr.Connect()
-	fmt.Println("NewSupervisor", 2)
-	if err != nil {
-		fmt.Println("err connecting")
-		return nil, err
+// ErrRunning is returned by Run if the Supervisor is already running.
+var ErrRunning = errors.New("win: supervisor already running")
+
+// options are the settings of a Supervisor or Manager.
+type options struct {
+	backend SCMBackend
+
+	pollInterval time.Duration
+	polling      bool // Poll instead of registering for notifications
+	fallback     bool // Poll when notification registration fails
+
+	backoffMin time.Duration // Initial delay between reconnection attempts
+	backoffMax time.Duration // Maximum delay between reconnection attempts
+
+	parallelism int // Services opened concurrently by NewSupervisor
+}
+
+// newOptions returns the default options with opts applied.
+func newOptions(opts []Option) options {
+	o := options{
+		backoffMin:  DefaultReconnectBackoffMin,
+		backoffMax:  DefaultReconnectBackoffMax,
+		parallelism: DefaultStartupParallelism,
 	}
-	fmt.Println("NewSupervisor", 3)
-	scmListener, err := newSCMListener()
-	fmt.Println("NewSupervisor", 4)
-	if err != nil {
-		fmt.Println("err making a newSCMListener")
-		return nil, err
+	for _, opt := range opts {
+		opt(&o)
 	}
-	fmt.Println("NewSupervisor", 5)
+	return o
+}
+
+// An Option configures a Supervisor or Manager.
+type Option func(*options)
+
+// WithBackend sets the SCMBackend used by the Supervisor or Manager, by
+// default it connects to the local Service Control Manager.  The backend
+// is closed when the Supervisor or Manager is closed.
+func WithBackend(b SCMBackend) Option {
+	return func(o *options) { o.backend = b }
+}
+
+// NewSupervisor returns a Supervisor that monitors the services matching
+// filter, the filter has the FilterID InitialFilter.  A nil filter matches
+// no services until one is added with AddFilter or SetFilter.  The
+// matching services are opened by NewSupervisor, but notifications and
+// polling only start when Run is called.
+func NewSupervisor(filter Filter, opts ...Option) (*Supervisor, error) {
 	s := &Supervisor{
-		mgr:              mgr,
-		filter:           filter,
+		options:          newOptions(opts),
+		nextFilterID:     InitialFilter + 1,
 		serviceListeners: make(map[string]*ServiceListener),
-		scmListener:      scmListener,
 
 		updates: make(chan Notification, 200),
-		halt:    make(chan struct{}, 1),
+		errs:    make(chan error, 64),
+		halt:    make(chan struct{}),
+		subs:    make(map[*Subscription]struct{}),
+	}
+	if filter != nil {
+		s.filters = []filterEntry{{InitialFilter, filter}}
 	}
+	if s.backend == nil {
+		b, err := defaultBackend()
+		if err != nil {
+			return nil, err
+		}
+		s.backend = b
+	}
+	s.events, _ = s.Subscribe(SubscribeOptions{
+		Buffer:   200,
+		Overflow: OverflowDropOldest,
+	})
 	if err := s.updateServiceListeners(); err != nil {
-		fmt.Println("err updateServiceListeners")
+		s.backend.Close()
 		return nil, err
 	}
-	fmt.Println("NewSupervisor", 6)
-	go s.listenSCM()
-	fmt.Println("NewSupervisor", 7)
 	return s, nil
 }
 
+// Errors returns the channel on which errors are delivered, they are of
+// type *ListenerError.  A failure only affects the service it was
+// reported for, errors caused by deleting a service are not reported.
+// Errors queues up to 64 errors and drops newer errors when full, the
+// channel is closed by Close.  The errors of services that could not be
+// monitored when the Supervisor was created are returned by StartupError
+// instead.
+func (s *Supervisor) Errors() <-chan error {
+	return s.errs
+}
+
+// StartupError returns the errors of the services that NewSupervisor could
+// not open, query or filter, in enumeration order and joined with
+// errors.Join, or nil.  The errors are of type *ListenerError, errors
+// caused by deleting a service are not included.
+func (s *Supervisor) StartupError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.startupErr
+}
+
+// report sends err to the Errors channel, it is dropped if the channel is
+// full or err is caused by deleting a service.
+func (s *Supervisor) report(err error) {
+	if err == nil || isDeleted(err) {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed() {
+		return
+	}
+	select {
+	case s.errs <- err:
+	default:
+	}
+}
+
+// Events returns the channel on which service events are delivered.
+// Services that match the filter when the Supervisor is created are
+// returned by Services and do not generate a ServiceAdded event.
+//
+// Events queues up to 200 events and drops the oldest when full, use
+// Subscribe to choose a different policy.
+func (s *Supervisor) Events() <-chan Event {
+	return s.events.Events()
+}
+
+// Close stops the Supervisor and closes its subscriptions, service handles
+// and backend.  Every handle is closed even if closing another fails, the
+// errors are joined.  Close returns after all of the Supervisor's
+// goroutines have exited, it is safe to call Close more than once.
 func (s *Supervisor) Close() error {
-	err := s.scmListener.Close()
-	if err != nil {
+	s.closeOnce.Do(func() { s.closeErr = s.shutdown() })
+	return s.closeErr
+}
+
+// Run starts monitoring the services, no events are delivered before Run
+// is called.  Run blocks until ctx is cancelled or the Supervisor is
+// closed and then closes the Supervisor, it returns the errors returned by
+// Close or ErrRunning if the Supervisor is already running.
+func (s *Supervisor) Run(ctx context.Context) error {
+	if err := s.start(); err != nil {
 		return err
 	}
+	select {
+	case <-ctx.Done():
+	case <-s.halt:
+	}
+	return s.Close()
+}
+
+// start starts the listeners and the poller.  Services created or deleted
+// after NewSupervisor enumerated them are detected once the SCM listener
+// has started.
+func (s *Supervisor) start() error {
 	s.mu.Lock()
-	for name, svc := range s.serviceListeners {
-		err = svc.Close()
-		if err != nil {
-			return err
+	if s.running {
+		s.mu.Unlock()
+		return ErrRunning
+	}
+	s.running = true
+	if !s.polling && !s.closed() {
+		s.scmListener = newSCMListener(s.backend)
+		s.goLocked(s.listenSCM)
+		for _, l := range s.serviceListeners {
+			s.goLocked(l.notifyStatusChange)
 		}
-		delete(s.serviceListeners, name)
 	}
+	s.goLocked(s.monitor)
 	s.mu.Unlock()
-	err = s.mgr.Disconnect()
+	if s.polling {
+		s.startPolling(true)
+		return nil
+	}
+	s.catchUp()
+	return nil
+}
+
+// catchUp monitors the services created and stops monitoring those deleted
+// since the last enumeration.
+func (s *Supervisor) catchUp() {
+	procs, err := s.backend.ListServices(SERVICE_WIN32)
 	if err != nil {
-		return err
+		s.report(listenerError("", "EnumServicesStatusEx", err))
+		return
 	}
+	s.mu.Lock()
+	prev := s.snapshot
+	s.snapshot = procs
+	s.mu.Unlock()
+	for _, c := range Diff(prev, procs) {
+		switch c.Type {
+		case ServiceCreated:
+			l, err := s.monitorService(c.ServiceName)
+			if err != nil {
+				s.report(err)
+			} else if l != nil {
+				s.emit(l, ServiceAdded, l.Status, l.Status)
+			}
+		case ServiceDeleted:
+			s.unmonitorService(c.ServiceName)
+		}
+	}
+}
+
+func (s *Supervisor) shutdown() error {
+	s.closeSubscriptions() // Unblock publishers
+
+	s.mu.Lock()
 	close(s.halt)
-	return nil
+	listeners := s.serviceListeners
+	s.serviceListeners = make(map[string]*ServiceListener)
+	scm := s.scmListener
+	s.mu.Unlock()
+
+	var errs []error
+	if scm != nil {
+		errs = append(errs, scm.Close())
+	}
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := listeners[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("win: closing service %s: %w", name, err))
+		}
+	}
+	errs = append(errs, s.backend.Close())
+
+	s.wg.Wait()
+	s.mu.RLock()
+	scm = s.scmListener // Replaced if reconnecting
+	s.mu.RUnlock()
+	if scm != nil {
+		scm.Close()
+		<-scm.done
+	}
+	s.mu.Lock()
+	close(s.errs)
+	s.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// goLocked runs fn in a goroutine that Close waits for, it does nothing
+// if the Supervisor is closed.  s.mu must be held.
+func (s *Supervisor) goLocked(fn func()) {
+	if s.closed() {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
 }
 
 func (s *Supervisor) closed() bool {
@@ -91,22 +308,40 @@ func (s *Supervisor) closed() bool {
 }
 
 func (s *Supervisor) listenSCM() {
+	s.mu.RLock()
+	scm := s.scmListener
+	s.mu.RUnlock()
 	for {
 		select {
 		case <-s.halt:
-			break
-		case n := <-s.scmListener.updates:
+			return
+		case <-scm.halt:
+			return // Replaced after reconnecting
+		case n := <-scm.updates:
 			switch n.Action {
+			case ActionDelete:
+				s.report(listenerError("", "NotifyServiceStatusChange", n.Err))
+				if s.shouldReconnect(n.Err) {
+					s.startReconnect()
+					return // Restarted after reconnecting
+				}
+				if s.fallbackToPolling(n.Err) {
+					s.startPolling(true)
+				}
 			case ActionSuccess:
-				// SCM Notifications.
-				switch n.Notify.NotificationTriggered {
-				case SERVICE_NOTIFY_CREATED:
-					for _, name := range n.Notify.ServiceNames {
-						s.monitorService(name)
-					}
-				case SERVICE_NOTIFY_DELETED:
-					for _, name := range n.Notify.ServiceNames {
-						delete(s.serviceListeners, name)
+				// SCM Notifications, which may mix created and
+				// deleted services.
+				for _, c := range n.Notify.Changes() {
+					switch c.Type {
+					case ServiceCreated:
+						l, err := s.monitorService(c.ServiceName)
+						if err != nil {
+							s.report(err)
+						} else if l != nil {
+							s.emit(l, ServiceAdded, l.Status, l.Status)
+						}
+					case ServiceDeleted:
+						s.unmonitorService(c.ServiceName)
 					}
 				}
 			}
@@ -114,6 +349,90 @@ func (s *Supervisor) listenSCM() {
 	}
 }
 
+// monitor handles the notifications of the ServiceListeners.
+func (s *Supervisor) monitor() {
+	for {
+		select {
+		case <-s.halt:
+			return
+		case n := <-s.updates:
+			switch n.Action {
+			case ActionSuccess:
+				if n.Notify.NotificationTriggered == SERVICE_NOTIFY_DELETE_PENDING {
+					s.unmonitorService(n.Name)
+					break
+				}
+				s.updateServiceStatus(n.Name, n.Notify)
+			case ActionReload:
+				if n.Notify == nil {
+					s.report(n.Err) // Reloading keeps failing
+					break
+				}
+				s.reloadServiceStatus(n.Name, n.Notify)
+			case ActionDelete:
+				s.report(listenerError(n.Name, "NotifyServiceStatusChange", n.Err))
+				if s.fallbackToPolling(n.Err) {
+					s.pollService(n.Name)
+					break
+				}
+				s.unmonitorService(n.Name)
+			}
+		}
+	}
+}
+
+// updateServiceStatus records the status of a service notification and
+// emits a StateChanged event.
+func (s *Supervisor) updateServiceStatus(svcName string, n *ServiceNotify) {
+	s.mu.Lock()
+	l := s.serviceListeners[svcName]
+	if l == nil {
+		s.mu.Unlock()
+		return
+	}
+	prev := l.Status
+	l.Status = n.ServiceStatus
+	l.State = n.NotificationTriggered
+	s.mu.Unlock()
+	s.emit(l, StateChanged, prev, n.ServiceStatus)
+}
+
+// reloadServiceStatus records the status of a service whose handle was
+// reopened and emits a ServiceReloaded event.
+func (s *Supervisor) reloadServiceStatus(svcName string, n *ServiceNotify) {
+	s.mu.Lock()
+	l := s.serviceListeners[svcName]
+	if l == nil {
+		s.mu.Unlock()
+		return
+	}
+	prev := l.Status
+	l.Status = n.ServiceStatus
+	l.State = n.NotificationTriggered
+	s.mu.Unlock()
+	s.emit(l, ServiceReloaded, prev, n.ServiceStatus)
+}
+
+// emit sends an event for the service monitored by l.  Events are
+// published in the order of their Seq, emitMu is held until every
+// subscriber has queued the event.
+func (s *Supervisor) emit(l *ServiceListener, typ EventType, prev, cur SERVICE_STATUS_PROCESS) {
+	s.emitMu.Lock()
+	defer s.emitMu.Unlock()
+	s.mu.Lock()
+	l.seq++
+	e := Event{
+		Type:     typ,
+		Service:  l.Name,
+		Previous: prev,
+		Status:   cur,
+		Time:     time.Now(),
+		Seq:      l.seq,
+	}
+	s.mu.Unlock()
+	s.publish(e)
+}
+
 // WARN: DEV ONLY
 // func (s *Supervisor) Update() ([]*ServiceListener, error) {
 // if err := s.updateServiceListeners(); err != nil {
@@ -131,233 +450,160 @@ func (s *Supervisor) Services() []ServiceListener {
 	serviceListeners := make([]ServiceListener, 0, len(s.serviceListeners))
 	for _, s := range s.serviceListeners {
 		if s != nil {
-			serviceListeners = append(serviceListeners, *s)
+			serviceListeners = append(serviceListeners, ServiceListener{
+				Name:    s.Name,
+				State:   s.State,
+				Status:  s.Status,
+				Config:  s.Config,
+				Service: s.handle(),
+			})
 		}
 	}
 	s.mu.RUnlock()
 	return serviceListeners
 }
 
-func (s *Supervisor) updateServiceListeners() error {
-	fmt.Println("updateServiceListeners", 1)
-	// TODO: Cleanup
-	procs, err := s.listServices(SERVICE_WIN32)
-	fmt.Println("updateServiceListeners", 2)
-	if err != nil {
-		fmt.Println("err listServices")
-		return err
-	}
-
-	// seen := make(map[string]bool, len(procs))
-	fmt.Println("updateServiceListeners", 3)
-	for _, p := range procs {
-		// seen[p.ServiceName] = true
-		fmt.Println("updateServiceListeners", 4, p.ServiceName)
-		if err := s.monitorService(p.ServiceName); err != nil {
-			fmt.Println("err monitorService", p.ServiceName)
-			return err
+// Unhealthy returns the monitored services that are not Healthy, ordered
+// by name.  Stopped trigger started services are not unhealthy.
+func (s *Supervisor) Unhealthy() []ServiceListener {
+	var unhealthy []ServiceListener
+	for _, l := range s.Services() {
+		if !l.Healthy() {
+			unhealthy = append(unhealthy, l)
 		}
 	}
-	fmt.Println("updateServiceListeners", 5)
-
-	// // Remove not seen
-	// s.mu.Lock()
-	// for _, svc := range s.serviceListeners {
-	// if !seen[svc.Name] {
-	// svc.Close()
-	// delete(s.serviceListeners, svc.Name)
-	// }
-	// }
-	// s.mu.Unlock()
-
-	return nil
+	sort.Slice(unhealthy, func(i, j int) bool {
+		return unhealthy[i].Name < unhealthy[j].Name
+	})
+	return unhealthy
 }
 
-func (s *Supervisor) monitorService(svcName string) error {
-	// TODO: Cleanup
-	const ERROR_ACCESS_DENIED = syscall.Errno(errno.ERROR_ACCESS_DENIED)
-
-	fmt.Println("monitorService", 1)
-
-	svc, err := s.mgr.OpenService(svcName)
-	fmt.Println("monitorService", 2)
+func (s *Supervisor) updateServiceListeners() error {
+	procs, err := s.backend.ListServices(SERVICE_WIN32)
 	if err != nil {
-		if err == ERROR_ACCESS_DENIED {
-			return nil
-		}
-		fmt.Println("openService failed")
-		return err
+		return listenerError("", "EnumServicesStatusEx", err)
 	}
-	fmt.Println("monitorService", 3)
-
-	conf, err := svc.Config()
-	fmt.Println("monitorService", 4)
-	if err != nil {
-		fmt.Println("monitorService", 5)
-		svc.Close()
-		fmt.Println("svc.Config failed")
-		return err
+	names := make([]string, len(procs))
+	for i, p := range procs {
+		names[i] = p.ServiceName
 	}
-	fmt.Println("monitorService", 6)
-	if !s.filter(svc.Name, &conf) {
-		svc.Close()
-		return nil
+	listeners, errs := s.newListeners(names)
+	var failed []error
+	for i, l := range listeners {
+		if errs[i] != nil && !isDeleted(errs[i]) {
+			failed = append(failed, errs[i]) // Keep monitoring the other services
+		}
+		if l != nil {
+			s.startListener(l)
+		}
 	}
 	s.mu.Lock()
-	s.serviceListeners[svc.Name] = newServiceListener(svc.Name, svc)
+	s.snapshot = procs
+	s.startupErr = errors.Join(failed...)
 	s.mu.Unlock()
 	return nil
 }
 
-// func (s *Supervisor) unmonitorService(svcName string) (err error) {
-// s.mu.Lock()
-// if svc := s.serviceListeners[svcName]; svc != nil {
-// err = svc.Close()
-// }
-// s.mu.Unlock()
-// return err
-// }
-
-func (s *Supervisor) listServices(typ ServiceType) ([]EnumServiceStatusProcess, error) {
-	var (
-		bytesNeeded      uint32
-		servicesReturned uint32
-		resumeHandle     uint32
-		groupName        uint32
-	)
-
-	_, _, e1 := syscall.Syscall12(
-		procEnumServicesStatusExW.Addr(),
-		uintptr(10),
-		uintptr(s.mgr.Handle),                      // hSCSupervisor,
-		uintptr(SC_ENUM_PROCESS_INFO),              // InfoLevel,
-		uintptr(typ),                               // dwServiceType,
-		uintptr(SERVICE_STATE_ALL),                 // dwServiceState,
-		uintptr(0),                                 // lpServices,
-		uintptr(0),                                 // cbBufSize,
-		uintptr(unsafe.Pointer(&bytesNeeded)),      // pcbBytesNeeded,
-		uintptr(unsafe.Pointer(&servicesReturned)), // lpServicesReturned,
-		uintptr(unsafe.Pointer(&resumeHandle)),     // lpResumeHandle,
-		uintptr(unsafe.Pointer(&groupName)),        // pszGroupName
-		uintptr(0),
-		uintptr(0),
-	)
-	if e1 != 0 {
-		switch e := errno.Errno(e1); e {
-		case errno.ERROR_MORE_DATA:
-			// Ok
-		case errno.ERROR_ACCESS_DENIED, errno.ERROR_INVALID_PARAMETER,
-			errno.ERROR_INVALID_HANDLE, errno.ERROR_INVALID_LEVEL:
-			// Programming error
-			return nil, e
-		case errno.ERROR_SHUTDOWN_IN_PROGRESS:
-			// Trigger stop
-			return nil, e
-		default:
-			return nil, syscall.EINVAL
-		}
+// monitorService starts monitoring svcName if it matches the filter and
+// is not already monitored, the new ServiceListener is returned.
+func (s *Supervisor) monitorService(svcName string) (*ServiceListener, error) {
+	l, started, err := s.remonitorService(svcName, nil)
+	if !started {
+		return nil, err
 	}
+	return l, nil
+}
 
-	var procs []EnumServiceStatusProcess
-
-	// TODO: Allocate buffer once, and advance pointer.
-	// TODO: Allocate to nearest ENUM_SERVICE_STATUS_PROCESS
-	// size boundry and set cap to prevent GC'ing of string
-	// memory.
-	if bytesNeeded > 256*1024 {
-		bytesNeeded = 256 * 1024
-	}
-	for bytesNeeded > 0 {
-		buffer := make([]byte, bytesNeeded)
-		_, _, e1 := syscall.Syscall12(
-			procEnumServicesStatusExW.Addr(),
-			uintptr(10),
-			uintptr(s.mgr.Handle),                      // hSCSupervisor,
-			uintptr(SC_ENUM_PROCESS_INFO),              // InfoLevel,
-			uintptr(typ),                               // dwServiceType,
-			uintptr(SERVICE_STATE_ALL),                 // dwServiceState,
-			uintptr(unsafe.Pointer(&buffer[0])),        // lpServices,
-			uintptr(len(buffer)),                       // cbBufSize,
-			uintptr(unsafe.Pointer(&bytesNeeded)),      // pcbBytesNeeded,
-			uintptr(unsafe.Pointer(&servicesReturned)), // lpServicesReturned,
-			uintptr(unsafe.Pointer(&resumeHandle)),     // lpResumeHandle,
-			uintptr(unsafe.Pointer(&groupName)),        // pszGroupName
-			uintptr(0),
-			uintptr(0),
-		)
-		if e1 != 0 {
-			switch e := errno.Errno(e1); e {
-			case errno.ERROR_MORE_DATA:
-				// Ok
-			case errno.ERROR_ACCESS_DENIED, errno.ERROR_INVALID_PARAMETER,
-				errno.ERROR_INVALID_HANDLE, errno.ERROR_INVALID_LEVEL:
-				// Programming error
-				return nil, e
-			case errno.ERROR_SHUTDOWN_IN_PROGRESS:
-				// Trigger stop
-				return nil, e
-			default:
-				return nil, syscall.EINVAL
-			}
-		}
-
-		var list []ENUM_SERVICE_STATUS_PROCESS
-		sp := (*sliceHeader)(unsafe.Pointer(&list))
-		sp.Data = unsafe.Pointer(&buffer[0])
-		sp.Len = int(servicesReturned)
-		sp.Cap = int(servicesReturned)
-
-		for _, p := range list {
-			procs = append(procs, EnumServiceStatusProcess{
-				ServiceName:          toString(p.ServiceName),
-				DisplayName:          toString(p.DisplayName),
-				ServiceStatusProcess: p.ServiceStatusProcess,
-			})
-		}
+// remonitorService is like monitorService, if prev is not nil the new
+// ServiceListener continues its event sequence.  If svcName is already
+// monitored its current ServiceListener is returned and started is false.
+func (s *Supervisor) remonitorService(svcName string, prev *ServiceListener) (l *ServiceListener, started bool, err error) {
+	if l := s.listener(svcName); l != nil {
+		return l, false, nil
 	}
-
-	return procs, nil
+	l, err = s.newListener(svcName, prev)
+	if l == nil || err != nil {
+		return nil, false, err
+	}
+	if !s.startListener(l) {
+		return s.listener(svcName), false, nil
+	}
+	return l, true, nil
 }
 
-// func (s *Supervisor) updateServiceListenerState(svcName string, state ServiceNotification) {
-// s.mu.Lock()
-// if svc := s.serviceListeners[svcName]; svc != nil {
-// svc.State = state
-// }
-// s.mu.Unlock()
-// }
-
-// WARN: RENAME
-// func (s *Supervisor) monitor() {
-// go func() {
-// for n := range s.updates {
-// switch n.Action {
-// case ActionSuccess:
-// // SCM Notifications.
-// switch n.Notify.NotificationTriggered {
-// case SERVICE_NOTIFY_CREATED:
-// for _, name := range n.Notify.ServiceNames {
-// s.monitorService(name)
-// }
+// listener returns the ServiceListener of svcName, or nil if it is not
+// monitored.
+func (s *Supervisor) listener(svcName string) *ServiceListener {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.serviceListeners[svcName]
+}
 
-// case SERVICE_NOTIFY_DELETED:
-// for _, name := range n.Notify.ServiceNames {
-// s.updateServiceListenerState(name, SERVICE_NOTIFY_DELETED)
-// s.unmonitorService(name)
-// }
+// newListener returns a ServiceListener for svcName, which is not started,
+// or nil if the service does not match the filter.  If prev is not nil the
+// new ServiceListener continues its event sequence.
+func (s *Supervisor) newListener(svcName string, prev *ServiceListener) (*ServiceListener, error) {
+	svc, conf, err := lookupService(s.backend, svcName)
+	if svc == nil || err != nil {
+		return nil, err
+	}
+	if !s.match(svcName, conf) {
+		svc.Close()
+		return nil, nil
+	}
+	status, err := svc.Query()
+	if err != nil {
+		svc.Close()
+		return nil, listenerError(svcName, "QueryServiceStatusEx", err)
+	}
+	l := newServiceListener(svcName, svc, s.updates)
+	l.reopen = s.reopenService
+	l.backoffMin = s.backoffMin
+	l.backoffMax = s.backoffMax
+	l.Status = status
+	l.Config = *conf
+	l.State = notificationForState(status.CurrentState)
+	if prev != nil {
+		l.seq = prev.seq
+	}
+	return l, nil
+}
 
-// // Service Notifications.
-// default:
-// s.updateServiceListenerState(n.Name, n.Notify.NotificationTriggered)
-// }
+// startListener starts monitoring with l, false is returned and the
+// listener closed if its service is already monitored or the Supervisor
+// is closed.
+func (s *Supervisor) startListener(l *ServiceListener) bool {
+	s.mu.Lock()
+	if s.serviceListeners[l.Name] != nil || s.closed() {
+		s.mu.Unlock()
+		l.Close()
+		return false
+	}
+	s.serviceListeners[l.Name] = l
+	l.polled = s.polling
+	if !s.polling && s.running {
+		s.goLocked(l.notifyStatusChange)
+	}
+	s.mu.Unlock()
+	return true
+}
 
-// case ActionDelete:
-// s.unmonitorService(n.Name)
+// unmonitorService stops monitoring svcName and emits a ServiceRemoved
+// event.
+func (s *Supervisor) unmonitorService(svcName string) error {
+	return s.stopMonitoring(svcName, ServiceRemoved)
+}
 
-// case ActionReload:
-// s.unmonitorService(n.Name)
-// s.monitorService(n.Name)
-// }
-// }
-// }()
-// }
+// stopMonitoring stops monitoring svcName and emits an event of type typ.
+func (s *Supervisor) stopMonitoring(svcName string, typ EventType) error {
+	s.mu.Lock()
+	l := s.serviceListeners[svcName]
+	delete(s.serviceListeners, svcName)
+	s.mu.Unlock()
+	if l == nil {
+		return nil
+	}
+	err := l.Close()
+	s.emit(l, typ, l.Status, l.Status)
+	return err
+}
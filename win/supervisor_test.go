@@ -1,157 +1,263 @@
 package win
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"time"
 
-	"golang.org/x/sys/windows/svc/mgr"
+	"monitor/errno"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
-var _ = XDescribe("Supervisor", func() {
+// startSupervisor returns a Supervisor that is started as by Run.
+func startSupervisor(filter Filter, opts ...Option) (*Supervisor, error) {
+	s, err := NewSupervisor(filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.start(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func serviceNames(s *Supervisor) []string {
+	var names []string
+	for _, svc := range s.Services() {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+var _ = Describe("Supervisor (fake backend)", func() {
 	const description = "vcap"
 	var (
-		svcName string
+		backend *FakeBackend
+		vcap    Config
+		other   Config
+		filter  Filter
 	)
-	var config = mgr.Config{
-		Description: description,
-	}
 
 	BeforeEach(func() {
-		svcName = serviceName()
-		config.DisplayName = svcName
+		backend = NewFakeBackend()
+		vcap = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: description}
+		other = Config{ServiceType: SERVICE_WIN32_OWN_PROCESS, Description: "other"}
+		filter = func(_ string, conf *Config) bool {
+			return conf.Description == description
+		}
 	})
 
-	XIt("monitors pre-existing services that match its filter", func() {
-		fmt.Println("testing!", svcName)
-		fmt.Println("test", 1)
-		// manager, service, err := buildAndInstall(svcName, config)
-		// fmt.Println("test", 2)
-		// Expect(err).ToNot(HaveOccurred())
-		// fmt.Println("test", 3)
-		// defer func() {
-		// service.Close()
-		// deleteService(manager, svcName)
-		// manager.Disconnect()
-		// }()
-		// fmt.Println("test", 4)
-
-		// filter := func(name string, _ *mgr.Config) bool {
-		// return name == svcName
-		// }
-		// fmt.Println("test", 5)
-		// s, err := NewSupervisor(filter)
-		// fmt.Println("test", 6)
-		// Expect(err).To(BeNil())
-		// fmt.Println("test", 7)
-		// defer s.Close()
-		// fmt.Println("test", 8)
-
-		// svcs := s.Services()
-		// fmt.Println("test", 7)
-		// Expect(len(svcs)).To(Equal(1))
-		// Expect(svcs[0].Name).To(Equal(svcName))
-	})
+	It("monitors pre-existing services that match its filter", func() {
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", other, running(SERVICE_WIN32_OWN_PROCESS))
 
-	XIt("starts monitoring added services", func() {
-		filter := func(_ string, conf *mgr.Config) bool {
-			return conf.Description == "vcap"
-		}
-		s, err := NewSupervisor(filter)
+		s, err := startSupervisor(filter, WithBackend(backend))
 		Expect(err).To(BeNil())
 		defer s.Close()
 
-		manager, service, err := buildAndInstall(svcName, config)
-		Expect(err).ToNot(HaveOccurred())
-		defer func() {
-			service.Close()
-			deleteService(manager, svcName)
-			manager.Disconnect()
-		}()
-
-		Eventually(func() string {
-			svcs := s.Services()
-			if len(svcs) > 0 {
-				return svcs[0].Name
-			}
-			return ""
-		}).Should(Equal(svcName))
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
 	})
 
-	XIt("removes services that have been deleted", func() {
-		manager, service, err := buildAndInstall(svcName, config)
-		Expect(err).ToNot(HaveOccurred())
-		service.Close()
+	It("starts monitoring added services", func() {
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
 
-		defer manager.Disconnect()
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		backend.AddService("svc-2", other, running(SERVICE_WIN32_OWN_PROCESS))
 
-		filter := func(name string, _ *mgr.Config) bool {
-			return name == svcName
-		}
-		s, err := NewSupervisor(filter)
+		Eventually(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-1"))
+		Consistently(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-1"))
+	})
+
+	It("removes services that have been deleted", func() {
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+
+		s, err := startSupervisor(filter, WithBackend(backend))
 		Expect(err).To(BeNil())
 		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
 
-		Eventually(func() string {
-			svcs := s.Services()
-			if len(svcs) == 1 {
-				return svcs[0].Name
-			}
-			return ""
-		}).Should(Equal(svcName))
-		svcs := s.Services()
-		handle := svcs[0].Service.Handle
+		backend.DeleteService("svc-1")
+		Eventually(s.Services).Should(BeEmpty())
+	})
 
-		deleteService(manager, svcName)
+	It("handles notifications that mix created and deleted services", func() {
+		backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+		s, err := startSupervisor(filter, WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer s.Close()
+		Expect(serviceNames(s)).To(ConsistOf("svc-1"))
 
-		Eventually(s.Services).Should(BeEmpty())
-		Expect(isValidHandle(handle)).To(BeFalse())
+		// Replace svc-1 with svc-2 and report both in one notification.
+		backend.mu.Lock()
+		backend.services["svc-2"] = &fakeService{config: vcap, status: running(SERVICE_WIN32_OWN_PROCESS)}
+		backend.mu.Unlock()
+		backend.NotifySCM("/svc-2", "svc-1")
+
+		Eventually(func() []string { return serviceNames(s) }).Should(ConsistOf("svc-2"))
+		Eventually(func() int {
+			backend.mu.Lock()
+			defer backend.mu.Unlock()
+			return len(backend.services["svc-1"].handles)
+		}).Should(BeZero())
 	})
 
-	XDescribe("Close", func() {
-		It("closes its ServiceControlListener's handle", func() {
-			s, err := NewSupervisor(func(_ string, _ *mgr.Config) bool { return true })
+	Describe("Close", func() {
+		It("closes its backend", func() {
+			s, err := startSupervisor(filter, WithBackend(backend))
 			Expect(err).To(BeNil())
-			err = s.Close()
-			Expect(err).To(BeNil())
-			Expect(isValidHandle(s.scmListener.manager.Handle)).To(BeFalse())
+			Expect(s.Close()).To(Succeed())
+
+			_, err = backend.ListServices(SERVICE_WIN32)
+			Expect(err).To(HaveOccurred())
 		})
 
 		It("closes its ServiceListeners handles", func() {
-			s, err := NewSupervisor(func(_ string, _ *mgr.Config) bool { return true })
+			backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			s, err := startSupervisor(filter, WithBackend(backend))
 			Expect(err).To(BeNil())
-			aHandle := s.Services()[0].Service.Handle
+			h := s.Services()[0].Service
 
-			err = s.Close()
-			Expect(isValidHandle(aHandle)).To(BeFalse())
+			Expect(s.Close()).To(Succeed())
+			_, err = h.Query()
+			Expect(err).To(HaveOccurred())
 			Expect(s.Services()).To(BeEmpty())
+			Expect(s.closed()).To(BeTrue())
 		})
 
-		It("closes its manager's handler", func() {
-			s, err := NewSupervisor(func(_ string, _ *mgr.Config) bool { return true })
+		It("closes every handle and joins the errors", func() {
+			for _, name := range []string{"svc-1", "svc-2", "svc-3"} {
+				backend.AddService(name, vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			}
+			s, err := startSupervisor(filter, WithBackend(backend))
 			Expect(err).To(BeNil())
+			for _, svc := range s.Services() {
+				if svc.Name != "svc-2" {
+					Expect(svc.Service.Close()).To(Succeed())
+				}
+			}
 
 			err = s.Close()
-			Expect(isValidHandle(s.mgr.Handle)).To(BeFalse())
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, errno.Errno(errno.ERROR_INVALID_HANDLE))).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("svc-1"))
+			Expect(err.Error()).To(ContainSubstring("svc-3"))
+			Expect(err.Error()).NotTo(ContainSubstring("svc-2"))
+
+			backend.mu.Lock()
+			Expect(backend.services["svc-2"].handles).To(BeEmpty())
+			backend.mu.Unlock()
+
+			Expect(s.Close()).To(Equal(err))
 		})
 	})
 
-	XDescribe("closed", func() {
-		var s *Supervisor
-		BeforeEach(func() {
-			var err error
-			s, err = NewSupervisor(func(_ string, _ *mgr.Config) bool { return true })
+	Describe("Run", func() {
+		It("closes the Supervisor when the context is cancelled", func() {
+			backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			s, err := NewSupervisor(filter, WithBackend(backend))
 			Expect(err).To(BeNil())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- s.Run(ctx) }()
+			Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(s.Services()).To(BeEmpty())
+			Eventually(s.Events()).Should(BeClosed())
+
+			backend.mu.Lock()
+			Expect(backend.services["svc-1"].handles).To(BeEmpty())
+			backend.mu.Unlock()
 		})
 
-		It("is true when the halt channel has been closed", func() {
+		It("returns when the Supervisor is closed", func() {
+			s, err := NewSupervisor(filter, WithBackend(backend))
+			Expect(err).To(BeNil())
+
+			done := make(chan error, 1)
+			go func() { done <- s.Run(context.Background()) }()
 			Expect(s.Close()).To(Succeed())
-			Expect(s.closed()).To(BeTrue())
+			Eventually(done).Should(Receive(BeNil()))
 		})
 
-		It("is false if the halt channel is still open", func() {
-			Expect(s.closed()).To(BeFalse())
+		It("waits for all goroutines to exit", func() {
+			before := runtime.NumGoroutine()
+			for i := 0; i < 10; i++ {
+				backend.AddService(fmt.Sprintf("svc-%d", i), vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			}
+			s, err := NewSupervisor(filter, WithBackend(backend), WithPollingFallback(time.Millisecond))
+			Expect(err).To(BeNil())
+			backend.SetNotifyError(errno.Errno(errno.ERROR_ACCESS_DENIED))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- s.Run(ctx) }()
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
 		})
+
+		It("does not deliver events before it is run", func() {
+			before := runtime.NumGoroutine()
+			backend.AddService("svc-1", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			s, err := NewSupervisor(filter, WithBackend(backend))
+			Expect(err).To(BeNil())
+			Expect(runtime.NumGoroutine()).To(BeNumerically("<=", before+2), "only the subscriptions deliver")
+
+			backend.AddService("svc-2", vcap, running(SERVICE_WIN32_OWN_PROCESS))
+			backend.SetState("svc-1", SERVICE_STOPPED)
+			Consistently(s.Events(), 50*time.Millisecond).ShouldNot(Receive())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- s.Run(ctx) }()
+
+			events := make(map[string]Event)
+			for len(events) < 2 {
+				var e Event
+				Eventually(s.Events()).Should(Receive(&e))
+				events[e.Service] = e
+			}
+			Expect(events["svc-1"].Status.CurrentState).To(Equal(SERVICE_STOPPED))
+			Expect(events["svc-2"].Type).To(Equal(ServiceAdded))
+			Expect(serviceNames(s)).To(ConsistOf("svc-1", "svc-2"))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("returns ErrRunning if it is already running", func() {
+			s, err := NewSupervisor(filter, WithBackend(backend))
+			Expect(err).To(BeNil())
+			done := make(chan error, 1)
+			go func() { done <- s.Run(context.Background()) }()
+			Eventually(func() bool {
+				s.mu.RLock()
+				defer s.mu.RUnlock()
+				return s.running
+			}).Should(BeTrue())
+			Expect(s.Run(context.Background())).To(Equal(ErrRunning))
+			Expect(s.Close()).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+
+	It("returns an error without a Service Control Manager", func() {
+		if _, err := defaultBackend(); err == nil {
+			Skip("service control manager is available")
+		}
+		_, err := NewSupervisor(filter)
+		Expect(err).To(Equal(ErrNotSupported))
 	})
 })
@@ -0,0 +1,215 @@
+package win
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An enumName is the name of a value of one of the enum or flag types.
+type enumName struct {
+	v uint32
+	s string
+}
+
+// formatEnum returns the name of v, or v in hexadecimal if it has none.
+func formatEnum(names []enumName, v uint32) string {
+	for _, n := range names {
+		if n.v == v {
+			return n.s
+		}
+	}
+	return "0x" + strconv.FormatUint(uint64(v), 16)
+}
+
+// formatFlags returns the name of v or, if it has none, the names of its
+// flags joined by '|' in the order of names.  A name is used if all of its
+// flags are set and not yet named, the remaining flags are formatted in
+// hexadecimal.
+func formatFlags(names []enumName, v uint32) string {
+	for _, n := range names {
+		if n.v == v {
+			return n.s
+		}
+	}
+	if v == 0 {
+		return "0x0"
+	}
+	b := getBuffer()
+	defer putBuffer(b)
+	rem := v
+	for _, n := range names {
+		if n.v != 0 && rem&n.v == n.v {
+			if b.Len() != 0 {
+				b.WriteByte('|')
+			}
+			b.WriteString(n.s)
+			rem &^= n.v
+		}
+	}
+	if rem != 0 {
+		if b.Len() != 0 {
+			b.WriteByte('|')
+		}
+		b.WriteString("0x" + strconv.FormatUint(uint64(rem), 16))
+	}
+	return b.String()
+}
+
+// parseEnum parses a name, matched ignoring case, or a number as accepted
+// by strconv.ParseUint with base 0.
+func parseEnum(names []enumName, typ, s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	for _, n := range names {
+		if strings.EqualFold(n.s, s) {
+			return n.v, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("win: invalid %s: %q", typ, s)
+	}
+	return uint32(v), nil
+}
+
+// parseFlags parses a set of names or numbers separated by '|'.
+func parseFlags(names []enumName, typ, s string) (uint32, error) {
+	var v uint32
+	for _, f := range strings.Split(s, "|") {
+		n, err := parseEnum(names, typ, f)
+		if err != nil {
+			return 0, err
+		}
+		v |= n
+	}
+	return v, nil
+}
+
+// unmarshalJSON decodes a JSON string with u, or a JSON number into v.
+func unmarshalJSON(b []byte, u encoding.TextUnmarshaler, v *uint32) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		return nil
+	case len(b) != 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		return u.UnmarshalText([]byte(s))
+	}
+	n, err := strconv.ParseUint(string(b), 10, 32)
+	if err != nil {
+		return fmt.Errorf("win: invalid JSON value: %s", b)
+	}
+	*v = uint32(n)
+	return nil
+}
+
+func (t ServiceType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+func (t *ServiceType) UnmarshalText(b []byte) error {
+	v, err := parseFlags(serviceTypeNames, "ServiceType", string(b))
+	if err != nil {
+		return err
+	}
+	*t = ServiceType(v)
+	return nil
+}
+
+func (t *ServiceType) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, t, (*uint32)(t))
+}
+
+func (t StartType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+func (t *StartType) UnmarshalText(b []byte) error {
+	v, err := parseEnum(startTypeNames, "StartType", string(b))
+	if err != nil {
+		return err
+	}
+	*t = StartType(v)
+	return nil
+}
+
+func (t *StartType) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, t, (*uint32)(t))
+}
+
+func (e ErrorControl) MarshalText() ([]byte, error) { return []byte(e.String()), nil }
+
+func (e *ErrorControl) UnmarshalText(b []byte) error {
+	v, err := parseEnum(errorControlNames, "ErrorControl", string(b))
+	if err != nil {
+		return err
+	}
+	*e = ErrorControl(v)
+	return nil
+}
+
+func (e *ErrorControl) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, e, (*uint32)(e))
+}
+
+func (c ServiceState) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *ServiceState) UnmarshalText(b []byte) error {
+	v, err := parseEnum(serviceStateNames, "ServiceState", string(b))
+	if err != nil {
+		return err
+	}
+	*c = ServiceState(v)
+	return nil
+}
+
+func (c *ServiceState) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, c, (*uint32)(c))
+}
+
+func (s ServiceControl) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *ServiceControl) UnmarshalText(b []byte) error {
+	v, err := parseFlags(serviceControlNames, "ServiceControl", string(b))
+	if err != nil {
+		return err
+	}
+	*s = ServiceControl(v)
+	return nil
+}
+
+func (s *ServiceControl) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, s, (*uint32)(s))
+}
+
+func (n ServiceNotification) MarshalText() ([]byte, error) { return []byte(n.String()), nil }
+
+func (n *ServiceNotification) UnmarshalText(b []byte) error {
+	v, err := parseFlags(serviceNotificationNames, "ServiceNotification", string(b))
+	if err != nil {
+		return err
+	}
+	*n = ServiceNotification(v)
+	return nil
+}
+
+func (n *ServiceNotification) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, n, (*uint32)(n))
+}
+
+func (s ServiceEnumState) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+func (s *ServiceEnumState) UnmarshalText(b []byte) error {
+	v, err := parseEnum(serviceEnumStateNames, "ServiceEnumState", string(b))
+	if err != nil {
+		return err
+	}
+	*s = ServiceEnumState(v)
+	return nil
+}
+
+func (s *ServiceEnumState) UnmarshalJSON(b []byte) error {
+	return unmarshalJSON(b, s, (*uint32)(s))
+}
@@ -0,0 +1,153 @@
+package win
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// textValue is implemented by pointers to the enum and flag types.
+type textValue interface {
+	fmt.Stringer
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+	json.Unmarshaler
+}
+
+// newTextValues returns a pointer to each of the enum and flag types set
+// to v.
+func newTextValues(v uint32) []textValue {
+	var (
+		t  = ServiceType(v)
+		st = StartType(v)
+		ec = ErrorControl(v)
+		ss = ServiceState(v)
+		sc = ServiceControl(v)
+		sn = ServiceNotification(v)
+		es = ServiceEnumState(v)
+	)
+	return []textValue{&t, &st, &ec, &ss, &sc, &sn, &es}
+}
+
+var _ = Describe("Enum text encoding", func() {
+	DescribeTable("formats values",
+		func(v fmt.Stringer, s string) {
+			Expect(v.String()).To(Equal(s))
+		},
+		Entry("service type", SERVICE_WIN32_OWN_PROCESS, "SERVICE_WIN32_OWN_PROCESS"),
+		Entry("combined service type", SERVICE_WIN32, "SERVICE_WIN32"),
+		Entry("service type flags", SERVICE_WIN32|SERVICE_KERNEL_DRIVER, "SERVICE_WIN32|SERVICE_KERNEL_DRIVER"),
+		Entry("unknown service type flags", SERVICE_WIN32_OWN_PROCESS|0x100, "SERVICE_WIN32_OWN_PROCESS|0x100"),
+		Entry("start type", SERVICE_DEMAND_START, "SERVICE_DEMAND_START"),
+		Entry("unknown start type", StartType(9), "0x9"),
+		Entry("error control", SERVICE_ERROR_CRITICAL, "SERVICE_ERROR_CRITICAL"),
+		Entry("ignored errors", SERVICE_ERROR_IGNORE, "SERVICE_ERROR_IGNORE"),
+		Entry("service state", SERVICE_RUNNING, "SERVICE_RUNNING"),
+		Entry("no state change", SERVICE_NO_CHANGE, "SERVICE_NO_CHANGE"),
+		Entry("unknown service state", ServiceState(0x20), "0x20"),
+		Entry("controls accepted", SERVICE_ACCEPT_SESSIONCHANGE|SERVICE_ACCEPT_STOP,
+			"SERVICE_ACCEPT_STOP|SERVICE_ACCEPT_SESSIONCHANGE"),
+		Entry("no controls accepted", ServiceControl(0), "0x0"),
+		Entry("notifications", SERVICE_NOTIFY_RUNNING|SERVICE_NOTIFY_STOPPED,
+			"SERVICE_NOTIFY_STOPPED|SERVICE_NOTIFY_RUNNING"),
+		Entry("unknown notifications", SERVICE_NOTIFY_DELETED|0x1000, "SERVICE_NOTIFY_DELETED|0x1000"),
+		Entry("enum state", SERVICE_STATE_ALL, "SERVICE_STATE_ALL"),
+		Entry("unknown enum state", ServiceEnumState(0), "0x0"),
+	)
+
+	It("formats flag sets deterministically", func() {
+		n := SERVICE_NOTIFY_CREATED | SERVICE_NOTIFY_DELETED | SERVICE_NOTIFY_PAUSED | SERVICE_NOTIFY_STOPPED
+		s := n.String()
+		for i := 0; i < 100; i++ {
+			Expect(n.String()).To(Equal(s))
+		}
+	})
+
+	DescribeTable("parses text",
+		func(s string, dst encoding.TextUnmarshaler, expected interface{}) {
+			Expect(dst.UnmarshalText([]byte(s))).To(Succeed())
+			Expect(reflect.ValueOf(dst).Elem().Interface()).To(Equal(expected))
+		},
+		Entry("flag set", "SERVICE_NOTIFY_RUNNING|SERVICE_NOTIFY_STOPPED",
+			new(ServiceNotification), SERVICE_NOTIFY_RUNNING|SERVICE_NOTIFY_STOPPED),
+		Entry("flags with spaces and numbers", " service_accept_stop | 0x80 ",
+			new(ServiceControl), SERVICE_ACCEPT_STOP|SERVICE_ACCEPT_SESSIONCHANGE),
+		Entry("combined service type", "SERVICE_WIN32|SERVICE_KERNEL_DRIVER",
+			new(ServiceType), SERVICE_WIN32|SERVICE_KERNEL_DRIVER),
+		Entry("name", "SERVICE_AUTO_START", new(StartType), SERVICE_AUTO_START),
+		Entry("decimal", "3", new(ErrorControl), SERVICE_ERROR_CRITICAL),
+		Entry("hexadecimal", "0x4", new(ServiceState), SERVICE_RUNNING),
+		Entry("enum state", "SERVICE_INACTIVE", new(ServiceEnumState), SERVICE_INACTIVE),
+	)
+
+	It("rejects invalid text and keeps the value", func() {
+		n := SERVICE_NOTIFY_RUNNING
+		Expect(n.UnmarshalText([]byte("SERVICE_NOTIFY_RUNNING|SERVICE_NOTIFY_BOGUS"))).
+			To(MatchError(`win: invalid ServiceNotification: "SERVICE_NOTIFY_BOGUS"`))
+		Expect(n).To(Equal(SERVICE_NOTIFY_RUNNING))
+
+		for _, v := range newTextValues(0) {
+			Expect(v.UnmarshalText([]byte("SERVICE_RUNNING|"))).To(HaveOccurred())
+			Expect(v.UnmarshalText([]byte("0x100000000"))).To(HaveOccurred())
+			Expect(v.UnmarshalText(nil)).To(HaveOccurred())
+		}
+	})
+
+	It("encodes JSON as text and decodes text or numbers", func() {
+		status := SERVICE_STATUS_PROCESS{
+			ServiceType:      SERVICE_WIN32_OWN_PROCESS,
+			CurrentState:     SERVICE_RUNNING,
+			ControlsAccepted: SERVICE_ACCEPT_STOP | SERVICE_ACCEPT_SHUTDOWN,
+			ProcessId:        42,
+		}
+		b, err := json.Marshal(status)
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(ContainSubstring(`"CurrentState":"SERVICE_RUNNING"`))
+		Expect(string(b)).To(ContainSubstring(`"ControlsAccepted":"SERVICE_ACCEPT_STOP|SERVICE_ACCEPT_SHUTDOWN"`))
+
+		var decoded SERVICE_STATUS_PROCESS
+		Expect(json.Unmarshal(b, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(status))
+
+		decoded = SERVICE_STATUS_PROCESS{}
+		Expect(json.Unmarshal([]byte(`{"ServiceType":16,"CurrentState":4,"ControlsAccepted":5,"ProcessId":42}`),
+			&decoded)).To(Succeed())
+		Expect(decoded).To(Equal(status))
+
+		Expect(json.Unmarshal([]byte(`{"CurrentState":-1}`), &decoded)).To(HaveOccurred())
+		Expect(json.Unmarshal([]byte(`{"CurrentState":"RUNNING"}`), &decoded)).To(HaveOccurred())
+	})
+})
+
+func FuzzEnumTextRoundTrip(f *testing.F) {
+	for _, v := range []uint32{0, 1, 0x30, 0x13b, 0x3ff, 0xffffffff} {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v uint32) {
+		for i, tv := range newTextValues(v) {
+			b, err := tv.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tv.String() {
+				t.Fatalf("MarshalText %q, String %q", b, tv.String())
+			}
+			dst := newTextValues(^v)[i]
+			if err := dst.UnmarshalText(b); err != nil {
+				t.Fatalf("%T: %s", dst, err)
+			}
+			if dst.String() != tv.String() {
+				t.Fatalf("%T: round trip of %#x: %s", dst, v, dst)
+			}
+			if err := json.Unmarshal([]byte(fmt.Sprint(v)), dst); err != nil || dst.String() != tv.String() {
+				t.Fatalf("%T: JSON number %d: %s, %v", dst, v, dst, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,319 @@
+package win
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// https://msdn.microsoft.com/en-us/library/windows/desktop/dd405512(v=vs.85).aspx
+type TriggerType uint32
+
+const (
+	SERVICE_TRIGGER_TYPE_DEVICE_INTERFACE_ARRIVAL   TriggerType = 1
+	SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY    TriggerType = 2
+	SERVICE_TRIGGER_TYPE_DOMAIN_JOIN                TriggerType = 3
+	SERVICE_TRIGGER_TYPE_FIREWALL_PORT_EVENT        TriggerType = 4
+	SERVICE_TRIGGER_TYPE_GROUP_POLICY               TriggerType = 5
+	SERVICE_TRIGGER_TYPE_NETWORK_ENDPOINT           TriggerType = 6
+	SERVICE_TRIGGER_TYPE_CUSTOM_SYSTEM_STATE_CHANGE TriggerType = 7
+	SERVICE_TRIGGER_TYPE_CUSTOM                     TriggerType = 20
+	SERVICE_TRIGGER_TYPE_AGGREGATE                  TriggerType = 30
+)
+
+var triggerTypeNames = []enumName{
+	{uint32(SERVICE_TRIGGER_TYPE_DEVICE_INTERFACE_ARRIVAL), "SERVICE_TRIGGER_TYPE_DEVICE_INTERFACE_ARRIVAL"},
+	{uint32(SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY), "SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY"},
+	{uint32(SERVICE_TRIGGER_TYPE_DOMAIN_JOIN), "SERVICE_TRIGGER_TYPE_DOMAIN_JOIN"},
+	{uint32(SERVICE_TRIGGER_TYPE_FIREWALL_PORT_EVENT), "SERVICE_TRIGGER_TYPE_FIREWALL_PORT_EVENT"},
+	{uint32(SERVICE_TRIGGER_TYPE_GROUP_POLICY), "SERVICE_TRIGGER_TYPE_GROUP_POLICY"},
+	{uint32(SERVICE_TRIGGER_TYPE_NETWORK_ENDPOINT), "SERVICE_TRIGGER_TYPE_NETWORK_ENDPOINT"},
+	{uint32(SERVICE_TRIGGER_TYPE_CUSTOM_SYSTEM_STATE_CHANGE), "SERVICE_TRIGGER_TYPE_CUSTOM_SYSTEM_STATE_CHANGE"},
+	{uint32(SERVICE_TRIGGER_TYPE_CUSTOM), "SERVICE_TRIGGER_TYPE_CUSTOM"},
+	{uint32(SERVICE_TRIGGER_TYPE_AGGREGATE), "SERVICE_TRIGGER_TYPE_AGGREGATE"},
+}
+
+func (t TriggerType) String() string { return formatEnum(triggerTypeNames, uint32(t)) }
+
+type TriggerAction uint32
+
+const (
+	SERVICE_TRIGGER_ACTION_SERVICE_START TriggerAction = 1
+	SERVICE_TRIGGER_ACTION_SERVICE_STOP  TriggerAction = 2
+)
+
+var triggerActionNames = []enumName{
+	{uint32(SERVICE_TRIGGER_ACTION_SERVICE_START), "SERVICE_TRIGGER_ACTION_SERVICE_START"},
+	{uint32(SERVICE_TRIGGER_ACTION_SERVICE_STOP), "SERVICE_TRIGGER_ACTION_SERVICE_STOP"},
+}
+
+func (a TriggerAction) String() string { return formatEnum(triggerActionNames, uint32(a)) }
+
+// https://msdn.microsoft.com/en-us/library/windows/desktop/dd405515(v=vs.85).aspx
+type TriggerDataType uint32
+
+const (
+	SERVICE_TRIGGER_DATA_TYPE_BINARY      TriggerDataType = 1
+	SERVICE_TRIGGER_DATA_TYPE_STRING      TriggerDataType = 2
+	SERVICE_TRIGGER_DATA_TYPE_LEVEL       TriggerDataType = 3
+	SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ANY TriggerDataType = 4
+	SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ALL TriggerDataType = 5
+)
+
+var triggerDataTypeNames = []enumName{
+	{uint32(SERVICE_TRIGGER_DATA_TYPE_BINARY), "SERVICE_TRIGGER_DATA_TYPE_BINARY"},
+	{uint32(SERVICE_TRIGGER_DATA_TYPE_STRING), "SERVICE_TRIGGER_DATA_TYPE_STRING"},
+	{uint32(SERVICE_TRIGGER_DATA_TYPE_LEVEL), "SERVICE_TRIGGER_DATA_TYPE_LEVEL"},
+	{uint32(SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ANY), "SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ANY"},
+	{uint32(SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ALL), "SERVICE_TRIGGER_DATA_TYPE_KEYWORD_ALL"},
+}
+
+func (t TriggerDataType) String() string { return formatEnum(triggerDataTypeNames, uint32(t)) }
+
+// A GUID identifies the subtype of a trigger, for example the event or
+// device interface class it waits for.
+type GUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// Well-known trigger subtypes.
+var (
+	NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID = MustParseGUID("{4f27f2de-14e2-430b-a549-7cd48cbc8245}")
+	NETWORK_MANAGER_LAST_IP_ADDRESS_REMOVAL_GUID  = MustParseGUID("{cc4ba62a-162e-4648-847a-b6bdf993e335}")
+	DOMAIN_JOIN_GUID                              = MustParseGUID("{1ce20aba-9851-4421-9430-1ddeb766e809}")
+	DOMAIN_LEAVE_GUID                             = MustParseGUID("{ddaf516e-58c2-4866-9574-c3b615d42ea1}")
+	FIREWALL_PORT_OPEN_GUID                       = MustParseGUID("{b7569e07-8421-4ee0-ad10-86915afdad09}")
+	FIREWALL_PORT_CLOSE_GUID                      = MustParseGUID("{a144ed38-8e12-4de4-9d96-e64740b1a524}")
+	MACHINE_POLICY_PRESENT_GUID                   = MustParseGUID("{659fcae6-5bdb-4da9-b1ff-ca2a178d46e0}")
+	USER_POLICY_PRESENT_GUID                      = MustParseGUID("{54fb46c8-f089-464c-b1fd-59d1b62c3b50}")
+	RPC_INTERFACE_EVENT_GUID                      = MustParseGUID("{bc90d167-9470-4139-a9ba-be0bbbf5b74d}")
+	NAMED_PIPE_EVENT_GUID                         = MustParseGUID("{1f81d131-3fac-4537-9e0c-7e7b0c2f4b55}")
+)
+
+var guidNames = map[GUID]string{
+	NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID: "NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID",
+	NETWORK_MANAGER_LAST_IP_ADDRESS_REMOVAL_GUID:  "NETWORK_MANAGER_LAST_IP_ADDRESS_REMOVAL_GUID",
+	DOMAIN_JOIN_GUID:            "DOMAIN_JOIN_GUID",
+	DOMAIN_LEAVE_GUID:           "DOMAIN_LEAVE_GUID",
+	FIREWALL_PORT_OPEN_GUID:     "FIREWALL_PORT_OPEN_GUID",
+	FIREWALL_PORT_CLOSE_GUID:    "FIREWALL_PORT_CLOSE_GUID",
+	MACHINE_POLICY_PRESENT_GUID: "MACHINE_POLICY_PRESENT_GUID",
+	USER_POLICY_PRESENT_GUID:    "USER_POLICY_PRESENT_GUID",
+	RPC_INTERFACE_EVENT_GUID:    "RPC_INTERFACE_EVENT_GUID",
+	NAMED_PIPE_EVENT_GUID:       "NAMED_PIPE_EVENT_GUID",
+}
+
+// ParseGUID parses a GUID in registry format, the braces are optional.
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	t := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if len(t) != 36 || len(t) != len(s) && len(t)+2 != len(s) ||
+		t[8] != '-' || t[13] != '-' || t[18] != '-' || t[23] != '-' {
+		return g, fmt.Errorf("win: invalid GUID: %q", s)
+	}
+	var b [16]byte
+	if _, err := hex.Decode(b[:], []byte(t[:8]+t[9:13]+t[14:18]+t[19:23]+t[24:])); err != nil {
+		return g, fmt.Errorf("win: invalid GUID: %q", s)
+	}
+	g.Data1 = binary.BigEndian.Uint32(b[0:])
+	g.Data2 = binary.BigEndian.Uint16(b[4:])
+	g.Data3 = binary.BigEndian.Uint16(b[6:])
+	copy(g.Data4[:], b[8:])
+	return g, nil
+}
+
+// MustParseGUID is like ParseGUID but panics if s is invalid.
+func MustParseGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// String returns g in registry format, for example
+// {4F27F2DE-14E2-430B-A549-7CD48CBC8245}.
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", g.Data1, g.Data2, g.Data3,
+		g.Data4[:2], g.Data4[2:])
+}
+
+// Name returns the name of a well-known GUID, or an empty string.
+func (g GUID) Name() string { return guidNames[g] }
+
+func (g GUID) MarshalText() ([]byte, error) { return []byte(g.String()), nil }
+
+func (g *GUID) UnmarshalText(b []byte) error {
+	v, err := ParseGUID(string(b))
+	if err != nil {
+		return err
+	}
+	*g = v
+	return nil
+}
+
+// TriggerData is a data item of a trigger, it is compared with the data
+// of the event that fires the trigger.
+type TriggerData struct {
+	Type TriggerDataType
+	Data []byte
+}
+
+// Strings returns the strings of a SERVICE_TRIGGER_DATA_TYPE_STRING data
+// item, which is either a single string or a MULTI_SZ string.
+func (d TriggerData) Strings() ([]string, error) {
+	if d.Type != SERVICE_TRIGGER_DATA_TYPE_STRING {
+		return nil, fmt.Errorf("win: trigger data is not a string: %s", d.Type)
+	}
+	s := make([]uint16, len(d.Data)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(d.Data[2*i:])
+	}
+	if len(s) != 0 && s[len(s)-1] == 0 && (len(s) == 1 || s[len(s)-2] != 0) {
+		s = append(s, 0) // Single string
+	}
+	return DecodeMultiSZ(s)
+}
+
+// A ServiceTrigger starts or stops a service when the event identified
+// by its Type and Subtype occurs.
+type ServiceTrigger struct {
+	Type    TriggerType
+	Action  TriggerAction
+	Subtype GUID
+	Data    []TriggerData
+}
+
+// TriggerStart reports whether the service is started by a trigger, the
+// Service Control Manager may start it with a trigger even when its
+// StartType is SERVICE_AUTO_START.
+func (c *Config) TriggerStart() bool {
+	if c.StartType == SERVICE_DISABLED {
+		return false
+	}
+	for _, t := range c.Triggers {
+		if t.Action == SERVICE_TRIGGER_ACTION_SERVICE_START {
+			return true
+		}
+	}
+	return false
+}
+
+// StartMode returns how the service is started, one of boot, system,
+// auto, demand, trigger or disabled.  A SERVICE_DEMAND_START service that
+// is started by a trigger is a trigger service, automatic services
+// remain auto.
+func (c *Config) StartMode() string {
+	switch c.StartType {
+	case SERVICE_BOOT_START:
+		return "boot"
+	case SERVICE_SYSTEM_START:
+		return "system"
+	case SERVICE_AUTO_START:
+		return "auto"
+	case SERVICE_DEMAND_START:
+		if c.TriggerStart() {
+			return "trigger"
+		}
+		return "demand"
+	case SERVICE_DISABLED:
+		return "disabled"
+	}
+	return c.StartType.String()
+}
+
+// alignPtr rounds off up to a multiple of ptrSize.
+func alignPtr(off, ptrSize int) int {
+	return (off + ptrSize - 1) &^ (ptrSize - 1)
+}
+
+// decodeTriggerInfo decodes a SERVICE_TRIGGER_INFO structure, whose
+// pointers are aligned to ptrSize.
+func decodeTriggerInfo(buf []byte, base uint64, ptrSize int) ([]ServiceTrigger, error) {
+	arrayOff := alignPtr(4, ptrSize) // After cTriggers
+	if len(buf) < arrayOff+ptrSize {
+		return nil, &DecodeError{Msg: fmt.Sprintf("SERVICE_TRIGGER_INFO does not fit in %d bytes", len(buf))}
+	}
+	count := int(binary.LittleEndian.Uint32(buf))
+	start, err := decodePtr(buf, base, arrayOff, ptrSize)
+	if err != nil || start < 0 {
+		return nil, err
+	}
+	var (
+		subtypeOff = 8
+		itemsOff   = alignPtr(subtypeOff+ptrSize+4, ptrSize) // After cDataItems
+		size       = itemsOff + ptrSize
+	)
+	if count > (len(buf)-start)/size {
+		return nil, &DecodeError{Offset: start, Msg: fmt.Sprintf("%d triggers do not fit in buffer", count)}
+	}
+	triggers := make([]ServiceTrigger, count)
+	for i := range triggers {
+		off := start + i*size
+		t := &triggers[i]
+		t.Type = TriggerType(binary.LittleEndian.Uint32(buf[off:]))
+		t.Action = TriggerAction(binary.LittleEndian.Uint32(buf[off+4:]))
+		guid, err := decodePtr(buf, base, off+subtypeOff, ptrSize)
+		if err != nil {
+			return triggers, err
+		}
+		if guid >= 0 {
+			if t.Subtype, err = decodeGUID(buf, guid); err != nil {
+				return triggers, err
+			}
+		}
+		n := int(binary.LittleEndian.Uint32(buf[off+subtypeOff+ptrSize:]))
+		if t.Data, err = decodeTriggerData(buf, base, off+itemsOff, ptrSize, n); err != nil {
+			return triggers, err
+		}
+	}
+	return triggers, nil
+}
+
+// decodeTriggerData decodes the array of count
+// SERVICE_TRIGGER_SPECIFIC_DATA_ITEM structures pointed to by the pointer
+// at buf[off:].
+func decodeTriggerData(buf []byte, base uint64, off, ptrSize, count int) ([]TriggerData, error) {
+	start, err := decodePtr(buf, base, off, ptrSize)
+	if err != nil || start < 0 {
+		return nil, err
+	}
+	size := 8 + ptrSize // dwDataType, cbData and pData
+	if count > (len(buf)-start)/size {
+		return nil, &DecodeError{Offset: start, Msg: fmt.Sprintf("%d data items do not fit in buffer", count)}
+	}
+	items := make([]TriggerData, count)
+	for i := range items {
+		off := start + i*size
+		items[i].Type = TriggerDataType(binary.LittleEndian.Uint32(buf[off:]))
+		n := int(binary.LittleEndian.Uint32(buf[off+4:]))
+		data, err := decodePtr(buf, base, off+8, ptrSize)
+		if err != nil {
+			return items, err
+		}
+		if data < 0 {
+			continue
+		}
+		if n > len(buf)-data {
+			return items, &DecodeError{Offset: data, Msg: fmt.Sprintf("%d bytes of data do not fit in buffer", n)}
+		}
+		items[i].Data = append([]byte(nil), buf[data:data+n]...)
+	}
+	return items, nil
+}
+
+func decodeGUID(buf []byte, off int) (GUID, error) {
+	var g GUID
+	if off+16 > len(buf) {
+		return g, &DecodeError{Offset: off, Msg: "GUID outside of buffer"}
+	}
+	g.Data1 = binary.LittleEndian.Uint32(buf[off:])
+	g.Data2 = binary.LittleEndian.Uint16(buf[off+4:])
+	g.Data3 = binary.LittleEndian.Uint16(buf[off+6:])
+	copy(g.Data4[:], buf[off+8:])
+	return g, nil
+}
@@ -0,0 +1,129 @@
+package win
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Service triggers", func() {
+	startTrigger := ServiceTrigger{
+		Type:    SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY,
+		Action:  SERVICE_TRIGGER_ACTION_SERVICE_START,
+		Subtype: NETWORK_MANAGER_FIRST_IP_ADDRESS_ARRIVAL_GUID,
+	}
+	stopTrigger := ServiceTrigger{
+		Type:    SERVICE_TRIGGER_TYPE_IP_ADDRESS_AVAILABILITY,
+		Action:  SERVICE_TRIGGER_ACTION_SERVICE_STOP,
+		Subtype: NETWORK_MANAGER_LAST_IP_ADDRESS_REMOVAL_GUID,
+	}
+
+	Context("GUIDs", func() {
+		It("formats and parses the registry format", func() {
+			g := DOMAIN_JOIN_GUID
+			Expect(g.String()).To(Equal("{1CE20ABA-9851-4421-9430-1DDEB766E809}"))
+			Expect(g.Name()).To(Equal("DOMAIN_JOIN_GUID"))
+			Expect(ParseGUID("1ce20aba-9851-4421-9430-1ddeb766e809")).To(Equal(g))
+
+			b, err := json.Marshal(g)
+			Expect(err).To(BeNil())
+			Expect(string(b)).To(Equal(`"{1CE20ABA-9851-4421-9430-1DDEB766E809}"`))
+			var decoded GUID
+			Expect(json.Unmarshal(b, &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(g))
+		})
+
+		It("has no name if it is not well-known", func() {
+			Expect(MustParseGUID("{00000000-0000-0000-0000-000000000001}").Name()).To(BeEmpty())
+		})
+
+		DescribeTable("rejects invalid GUIDs",
+			func(s string) {
+				_, err := ParseGUID(s)
+				Expect(err).To(MatchError(ContainSubstring("win: invalid GUID")))
+			},
+			Entry("empty", ""),
+			Entry("unbalanced braces", "{1ce20aba-9851-4421-9430-1ddeb766e809"),
+			Entry("misplaced dashes", "1ce20aba9-851-4421-9430-1ddeb766e809"),
+			Entry("not hexadecimal", "1ce20abx-9851-4421-9430-1ddeb766e809"),
+		)
+	})
+
+	It("decodes string data items", func() {
+		d := TriggerData{Type: SERVICE_TRIGGER_DATA_TYPE_STRING, Data: utf16Bytes("a\x00")}
+		Expect(d.Strings()).To(Equal([]string{"a"}))
+		d.Data = utf16Bytes("a\x00bc\x00\x00")
+		Expect(d.Strings()).To(Equal([]string{"a", "bc"}))
+		d.Data = utf16Bytes("a")
+		_, err := d.Strings()
+		Expect(err).To(HaveOccurred())
+		d.Type = SERVICE_TRIGGER_DATA_TYPE_LEVEL
+		_, err = d.Strings()
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("start mode",
+		func(start StartType, triggers []ServiceTrigger, mode string, triggerStart bool) {
+			conf := Config{StartType: start, Triggers: triggers}
+			Expect(conf.StartMode()).To(Equal(mode))
+			Expect(conf.TriggerStart()).To(Equal(triggerStart))
+		},
+		Entry("demand", SERVICE_DEMAND_START, nil, "demand", false),
+		Entry("demand with a stop trigger", SERVICE_DEMAND_START, []ServiceTrigger{stopTrigger}, "demand", false),
+		Entry("trigger", SERVICE_DEMAND_START, []ServiceTrigger{stopTrigger, startTrigger}, "trigger", true),
+		Entry("auto with a trigger", SERVICE_AUTO_START, []ServiceTrigger{startTrigger}, "auto", true),
+		Entry("disabled with a trigger", SERVICE_DISABLED, []ServiceTrigger{startTrigger}, "disabled", false),
+		Entry("boot", SERVICE_BOOT_START, nil, "boot", false),
+		Entry("unknown", StartType(9), nil, "0x9", false),
+	)
+
+	It("filters on the start mode", func() {
+		filter := MustParseFilter(`start_mode == trigger`)
+		Expect(filter("svc", &Config{StartType: SERVICE_DEMAND_START, Triggers: []ServiceTrigger{startTrigger}})).To(BeTrue())
+		Expect(filter("svc", &Config{StartType: SERVICE_DEMAND_START})).To(BeFalse())
+		filter = MustParseFilter(`start_mode in (demand, auto)`)
+		Expect(filter("svc", &Config{StartType: SERVICE_DEMAND_START})).To(BeTrue())
+		Expect(filter("svc", &Config{StartType: SERVICE_DEMAND_START, Triggers: []ServiceTrigger{startTrigger}})).To(BeFalse())
+	})
+
+	DescribeTable("service health",
+		func(conf Config, status SERVICE_STATUS_PROCESS, healthy bool) {
+			svc := Service{Name: "svc", Config: conf, Status: status}
+			Expect(svc.Healthy()).To(Equal(healthy))
+		},
+		Entry("running", Config{StartType: SERVICE_AUTO_START}, running(SERVICE_WIN32_OWN_PROCESS), true),
+		Entry("stopped automatic service", Config{StartType: SERVICE_AUTO_START},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED}, false),
+		Entry("stopped trigger started automatic service",
+			Config{StartType: SERVICE_AUTO_START, Triggers: []ServiceTrigger{startTrigger}},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED}, true),
+		Entry("stopped demand service", Config{StartType: SERVICE_DEMAND_START},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED, Win32ExitCode: 0x435}, true),
+		Entry("failed trigger started service",
+			Config{StartType: SERVICE_DEMAND_START, Triggers: []ServiceTrigger{startTrigger}},
+			SERVICE_STATUS_PROCESS{CurrentState: SERVICE_STOPPED, Win32ExitCode: 0x42A}, false),
+	)
+
+	It("is reported by the fake backend and not flagged by the Supervisor", func() {
+		backend := NewFakeBackend()
+		stopped := SERVICE_STATUS_PROCESS{ServiceType: SERVICE_WIN32_OWN_PROCESS, CurrentState: SERVICE_STOPPED}
+		backend.AddService("trigger", Config{StartType: SERVICE_AUTO_START, Triggers: []ServiceTrigger{startTrigger}}, stopped)
+		backend.AddService("auto", Config{StartType: SERVICE_AUTO_START}, stopped)
+		backend.AddService("demand", Config{StartType: SERVICE_DEMAND_START}, stopped)
+
+		sup, err := startSupervisor(MatchAll(), WithBackend(backend))
+		Expect(err).To(BeNil())
+		defer sup.Close()
+
+		unhealthy := sup.Unhealthy()
+		Expect(unhealthy).To(HaveLen(1))
+		Expect(unhealthy[0].Name).To(Equal("auto"))
+		for _, l := range sup.Services() {
+			if l.Name == "trigger" {
+				Expect(l.Config.Triggers).To(Equal([]ServiceTrigger{startTrigger}))
+			}
+		}
+	})
+})
@@ -24,21 +24,18 @@ const (
 	SERVICE_WIN32               ServiceType = 0x00000030
 )
 
-var serviceTypeMap = map[ServiceType]string{
-	SERVICE_DRIVER:              "SERVICE_DRIVER",
-	SERVICE_FILE_SYSTEM_DRIVER:  "SERVICE_FILE_SYSTEM_DRIVER",
-	SERVICE_KERNEL_DRIVER:       "SERVICE_KERNEL_DRIVER",
-	SERVICE_WIN32:               "SERVICE_WIN32",
-	SERVICE_WIN32_OWN_PROCESS:   "SERVICE_WIN32_OWN_PROCESS",
-	SERVICE_WIN32_SHARE_PROCESS: "SERVICE_WIN32_SHARE_PROCESS",
+// The combined types are listed first, so that they are preferred when
+// formatting.
+var serviceTypeNames = []enumName{
+	{uint32(SERVICE_WIN32), "SERVICE_WIN32"},
+	{uint32(SERVICE_DRIVER), "SERVICE_DRIVER"},
+	{uint32(SERVICE_KERNEL_DRIVER), "SERVICE_KERNEL_DRIVER"},
+	{uint32(SERVICE_FILE_SYSTEM_DRIVER), "SERVICE_FILE_SYSTEM_DRIVER"},
+	{uint32(SERVICE_WIN32_OWN_PROCESS), "SERVICE_WIN32_OWN_PROCESS"},
+	{uint32(SERVICE_WIN32_SHARE_PROCESS), "SERVICE_WIN32_SHARE_PROCESS"},
 }
 
-func (t ServiceType) String() string {
-	if s := serviceTypeMap[t]; s != "" {
-		return s
-	}
-	return strconv.FormatUint(uint64(t), 16)
-}
+func (t ServiceType) String() string { return formatFlags(serviceTypeNames, uint32(t)) }
 
 type StartType uint32
 
@@ -50,20 +47,15 @@ const (
 	SERVICE_DISABLED                      // 0x4
 )
 
-var startTypeStr = [...]string{
-	"SERVICE_BOOT_START",
-	"SERVICE_SYSTEM_START",
-	"SERVICE_AUTO_START",
-	"SERVICE_DEMAND_START",
-	"SERVICE_DISABLED",
+var startTypeNames = []enumName{
+	{uint32(SERVICE_BOOT_START), "SERVICE_BOOT_START"},
+	{uint32(SERVICE_SYSTEM_START), "SERVICE_SYSTEM_START"},
+	{uint32(SERVICE_AUTO_START), "SERVICE_AUTO_START"},
+	{uint32(SERVICE_DEMAND_START), "SERVICE_DEMAND_START"},
+	{uint32(SERVICE_DISABLED), "SERVICE_DISABLED"},
 }
 
-func (t StartType) String() (s string) {
-	if int(t) < len(startTypeStr) {
-		return startTypeStr[t]
-	}
-	return strconv.FormatUint(uint64(t), 16)
-}
+func (t StartType) String() string { return formatEnum(startTypeNames, uint32(t)) }
 
 type ServiceState uint32
 
@@ -78,23 +70,18 @@ const (
 	SERVICE_NO_CHANGE        ServiceState = 0xffffffff
 )
 
-var serviceStateMap = map[ServiceState]string{
-	SERVICE_STOPPED:          "SERVICE_STOPPED",
-	SERVICE_START_PENDING:    "SERVICE_START_PENDING",
-	SERVICE_STOP_PENDING:     "SERVICE_STOP_PENDING",
-	SERVICE_RUNNING:          "SERVICE_RUNNING",
-	SERVICE_CONTINUE_PENDING: "SERVICE_CONTINUE_PENDING",
-	SERVICE_PAUSE_PENDING:    "SERVICE_PAUSE_PENDING",
-	SERVICE_PAUSED:           "SERVICE_PAUSED",
-	SERVICE_NO_CHANGE:        "SERVICE_NO_CHANGE",
+var serviceStateNames = []enumName{
+	{uint32(SERVICE_STOPPED), "SERVICE_STOPPED"},
+	{uint32(SERVICE_START_PENDING), "SERVICE_START_PENDING"},
+	{uint32(SERVICE_STOP_PENDING), "SERVICE_STOP_PENDING"},
+	{uint32(SERVICE_RUNNING), "SERVICE_RUNNING"},
+	{uint32(SERVICE_CONTINUE_PENDING), "SERVICE_CONTINUE_PENDING"},
+	{uint32(SERVICE_PAUSE_PENDING), "SERVICE_PAUSE_PENDING"},
+	{uint32(SERVICE_PAUSED), "SERVICE_PAUSED"},
+	{uint32(SERVICE_NO_CHANGE), "SERVICE_NO_CHANGE"},
 }
 
-func (c ServiceState) String() string {
-	if s := serviceStateMap[c]; s != "" {
-		return s
-	}
-	return strconv.FormatUint(uint64(c), 16)
-}
+func (c ServiceState) String() string { return formatEnum(serviceStateNames, uint32(c)) }
 
 type ErrorControl uint32
 
@@ -105,19 +92,14 @@ const (
 	SERVICE_ERROR_CRITICAL                     // 0x03
 )
 
-var errorControlStr = [...]string{
-	"SERVICE_ERROR_IGNORE",
-	"SERVICE_ERROR_NORMAL",
-	"SERVICE_ERROR_SEVERE",
-	"SERVICE_ERROR_CRITICAL",
+var errorControlNames = []enumName{
+	{uint32(SERVICE_ERROR_IGNORE), "SERVICE_ERROR_IGNORE"},
+	{uint32(SERVICE_ERROR_NORMAL), "SERVICE_ERROR_NORMAL"},
+	{uint32(SERVICE_ERROR_SEVERE), "SERVICE_ERROR_SEVERE"},
+	{uint32(SERVICE_ERROR_CRITICAL), "SERVICE_ERROR_CRITICAL"},
 }
 
-func (e ErrorControl) String() string {
-	if int(e) < len(startTypeStr) {
-		return startTypeStr[e]
-	}
-	return strconv.FormatUint(uint64(e), 16)
-}
+func (e ErrorControl) String() string { return formatEnum(errorControlNames, uint32(e)) }
 
 type ServiceControl uint32
 
@@ -132,32 +114,45 @@ const (
 	SERVICE_ACCEPT_SESSIONCHANGE                                    // 128
 )
 
-var serviceControlMap = map[ServiceControl]string{
-	SERVICE_ACCEPT_STOP:                  "SERVICE_ACCEPT_STOP",
-	SERVICE_ACCEPT_PAUSE_CONTINUE:        "SERVICE_ACCEPT_PAUSE_CONTINUE",
-	SERVICE_ACCEPT_SHUTDOWN:              "SERVICE_ACCEPT_SHUTDOWN",
-	SERVICE_ACCEPT_PARAMCHANGE:           "SERVICE_ACCEPT_PARAMCHANGE",
-	SERVICE_ACCEPT_NETBINDCHANGE:         "SERVICE_ACCEPT_NETBINDCHANGE",
-	SERVICE_ACCEPT_HARDWAREPROFILECHANGE: "SERVICE_ACCEPT_HARDWAREPROFILECHANGE",
-	SERVICE_ACCEPT_POWEREVENT:            "SERVICE_ACCEPT_POWEREVENT",
-	SERVICE_ACCEPT_SESSIONCHANGE:         "SERVICE_ACCEPT_SESSIONCHANGE",
+var serviceControlNames = []enumName{
+	{uint32(SERVICE_ACCEPT_STOP), "SERVICE_ACCEPT_STOP"},
+	{uint32(SERVICE_ACCEPT_PAUSE_CONTINUE), "SERVICE_ACCEPT_PAUSE_CONTINUE"},
+	{uint32(SERVICE_ACCEPT_SHUTDOWN), "SERVICE_ACCEPT_SHUTDOWN"},
+	{uint32(SERVICE_ACCEPT_PARAMCHANGE), "SERVICE_ACCEPT_PARAMCHANGE"},
+	{uint32(SERVICE_ACCEPT_NETBINDCHANGE), "SERVICE_ACCEPT_NETBINDCHANGE"},
+	{uint32(SERVICE_ACCEPT_HARDWAREPROFILECHANGE), "SERVICE_ACCEPT_HARDWAREPROFILECHANGE"},
+	{uint32(SERVICE_ACCEPT_POWEREVENT), "SERVICE_ACCEPT_POWEREVENT"},
+	{uint32(SERVICE_ACCEPT_SESSIONCHANGE), "SERVICE_ACCEPT_SESSIONCHANGE"},
 }
 
-func (s ServiceControl) String() string {
-	if s == 0 {
-		return "0x0"
-	}
-	b := getBuffer()
-	defer putBuffer(b)
-	for k, v := range serviceControlMap {
-		if s&k != 0 {
-			if b.Len() != 0 {
-				b.WriteByte('|')
-			}
-			b.WriteString(v)
-		}
+func (s ServiceControl) String() string { return formatFlags(serviceControlNames, uint32(s)) }
+
+// https://msdn.microsoft.com/en-us/library/windows/desktop/ms682108(v=vs.85).aspx
+type ControlCode uint32
+
+const (
+	SERVICE_CONTROL_STOP        ControlCode = 1 + iota // 0x01
+	SERVICE_CONTROL_PAUSE                              // 0x02
+	SERVICE_CONTROL_CONTINUE                           // 0x03
+	SERVICE_CONTROL_INTERROGATE                        // 0x04
+	SERVICE_CONTROL_SHUTDOWN                           // 0x05
+	SERVICE_CONTROL_PARAMCHANGE                        // 0x06
+)
+
+var controlCodeMap = map[ControlCode]string{
+	SERVICE_CONTROL_STOP:        "SERVICE_CONTROL_STOP",
+	SERVICE_CONTROL_PAUSE:       "SERVICE_CONTROL_PAUSE",
+	SERVICE_CONTROL_CONTINUE:    "SERVICE_CONTROL_CONTINUE",
+	SERVICE_CONTROL_INTERROGATE: "SERVICE_CONTROL_INTERROGATE",
+	SERVICE_CONTROL_SHUTDOWN:    "SERVICE_CONTROL_SHUTDOWN",
+	SERVICE_CONTROL_PARAMCHANGE: "SERVICE_CONTROL_PARAMCHANGE",
+}
+
+func (c ControlCode) String() string {
+	if s := controlCodeMap[c]; s != "" {
+		return s
 	}
-	return b.String()
+	return strconv.FormatUint(uint64(c), 16)
 }
 
 // https://msdn.microsoft.com/en-us/library/windows/desktop/ms685992(v=vs.85).aspx
@@ -202,21 +197,60 @@ type QueryServiceConfig struct {
 	BinaryPathName   string
 	LoadOrderGroup   string
 	TagId            uint32
+	Dependencies     []string // Load-order groups are prefixed with '+'
 	ServiceStartName string
 	DisplayName      string
 }
 
-func NewQueryServiceConfig(s *QUERY_SERVICE_CONFIG) *QueryServiceConfig {
-	return &QueryServiceConfig{
+// NewQueryServiceConfig decodes s, which QueryServiceConfig returned at the
+// start of a buffer of size bytes.  The strings must lie within the buffer,
+// a string that is not terminated ends with it.
+func NewQueryServiceConfig(s *QUERY_SERVICE_CONFIG, size int) (*QueryServiceConfig, error) {
+	if size < int(unsafe.Sizeof(*s)) {
+		return nil, &DecodeError{Msg: fmt.Sprintf("QUERY_SERVICE_CONFIG does not fit in %d bytes", size)}
+	}
+	var err error
+	str := func(p *uint16) string {
+		n, e := bufferLen(unsafe.Pointer(s), size, p)
+		if e != nil && err == nil {
+			err = e
+		}
+		return UTF16PtrToString(p, n)
+	}
+	conf := &QueryServiceConfig{
 		ServiceType:      s.ServiceType,
 		StartType:        s.StartType,
 		ErrorControl:     s.ErrorControl,
-		BinaryPathName:   UTF16ToString(s.BinaryPathName),
-		LoadOrderGroup:   UTF16ToString(s.LoadOrderGroup),
+		BinaryPathName:   str(s.BinaryPathName),
+		LoadOrderGroup:   str(s.LoadOrderGroup),
 		TagId:            s.TagId,
-		ServiceStartName: UTF16ToString(s.ServiceStartName),
-		DisplayName:      UTF16ToString(s.DisplayName),
+		ServiceStartName: str(s.ServiceStartName),
+		DisplayName:      str(s.DisplayName),
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := bufferLen(unsafe.Pointer(s), size, s.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Dependencies, err = MultiSZPtrToStrings(s.Dependencies, n); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// bufferLen returns the number of UTF-16 code units from p to the end of
+// the buffer of size bytes at base, p must be nil or point into the buffer.
+func bufferLen(base unsafe.Pointer, size int, p *uint16) (int, error) {
+	if p == nil {
+		return 0, nil
 	}
+	off := int(uintptr(unsafe.Pointer(p)) - uintptr(base))
+	if uintptr(unsafe.Pointer(p)) < uintptr(base) || off >= size {
+		return 0, &DecodeError{Msg: fmt.Sprintf("pointer %p outside of buffer", p)}
+	}
+	return (size - off) / 2, nil
 }
 
 type SERVICE_DESCRIPTION struct {
@@ -231,13 +265,13 @@ const (
 	SERVICE_STATE_ALL                             // 0x03
 )
 
-var serviceEnumStateMap = map[ServiceEnumState]string{
-	SERVICE_ACTIVE:    "SERVICE_ACTIVE",
-	SERVICE_INACTIVE:  "SERVICE_INACTIVE",
-	SERVICE_STATE_ALL: "SERVICE_STATE_ALL",
+var serviceEnumStateNames = []enumName{
+	{uint32(SERVICE_ACTIVE), "SERVICE_ACTIVE"},
+	{uint32(SERVICE_INACTIVE), "SERVICE_INACTIVE"},
+	{uint32(SERVICE_STATE_ALL), "SERVICE_STATE_ALL"},
 }
 
-func (s ServiceEnumState) String() string { return serviceEnumStateMap[s] }
+func (s ServiceEnumState) String() string { return formatEnum(serviceEnumStateNames, uint32(s)) }
 
 const SC_ENUM_PROCESS_INFO uint32 = 0
 
@@ -248,10 +282,14 @@ type ENUM_SERVICE_STATUS_PROCESS struct {
 	ServiceStatusProcess SERVICE_STATUS_PROCESS
 }
 
+// maxServiceNameLen is the maximum length, in UTF-16 code units, of the
+// name and display name of a service.
+const maxServiceNameLen = 256
+
 func (e ENUM_SERVICE_STATUS_PROCESS) String() string {
 	const format = "{ServiceName: %s, DisplayName: %s, ServiceStatusProcess: {%s}}"
-	return fmt.Sprintf(format, UTF16ToString(e.ServiceName),
-		UTF16ToString(e.DisplayName), e.ServiceStatusProcess)
+	return fmt.Sprintf(format, UTF16PtrToString(e.ServiceName, maxServiceNameLen+1),
+		UTF16PtrToString(e.DisplayName, maxServiceNameLen+1), e.ServiceStatusProcess)
 }
 
 type EnumServiceStatusProcess struct {
@@ -285,34 +323,31 @@ const (
 	SERVICE_NOTIFY_STOPPED          ServiceNotification = 0x00000001
 )
 
-var serviceNotificationMap = map[ServiceNotification]string{
-	SERVICE_NOTIFY_CREATED:          "SERVICE_NOTIFY_CREATED",
-	SERVICE_NOTIFY_DELETED:          "SERVICE_NOTIFY_DELETED",
-	SERVICE_NOTIFY_CONTINUE_PENDING: "SERVICE_NOTIFY_CONTINUE_PENDING",
-	SERVICE_NOTIFY_DELETE_PENDING:   "SERVICE_NOTIFY_DELETE_PENDING",
-	SERVICE_NOTIFY_PAUSE_PENDING:    "SERVICE_NOTIFY_PAUSE_PENDING",
-	SERVICE_NOTIFY_PAUSED:           "SERVICE_NOTIFY_PAUSED",
-	SERVICE_NOTIFY_RUNNING:          "SERVICE_NOTIFY_RUNNING",
-	SERVICE_NOTIFY_START_PENDING:    "SERVICE_NOTIFY_START_PENDING",
-	SERVICE_NOTIFY_STOP_PENDING:     "SERVICE_NOTIFY_STOP_PENDING",
-	SERVICE_NOTIFY_STOPPED:          "SERVICE_NOTIFY_STOPPED",
+// In the order of their values.
+var serviceNotificationNames = []enumName{
+	{uint32(SERVICE_NOTIFY_STOPPED), "SERVICE_NOTIFY_STOPPED"},
+	{uint32(SERVICE_NOTIFY_START_PENDING), "SERVICE_NOTIFY_START_PENDING"},
+	{uint32(SERVICE_NOTIFY_STOP_PENDING), "SERVICE_NOTIFY_STOP_PENDING"},
+	{uint32(SERVICE_NOTIFY_RUNNING), "SERVICE_NOTIFY_RUNNING"},
+	{uint32(SERVICE_NOTIFY_CONTINUE_PENDING), "SERVICE_NOTIFY_CONTINUE_PENDING"},
+	{uint32(SERVICE_NOTIFY_PAUSE_PENDING), "SERVICE_NOTIFY_PAUSE_PENDING"},
+	{uint32(SERVICE_NOTIFY_PAUSED), "SERVICE_NOTIFY_PAUSED"},
+	{uint32(SERVICE_NOTIFY_CREATED), "SERVICE_NOTIFY_CREATED"},
+	{uint32(SERVICE_NOTIFY_DELETED), "SERVICE_NOTIFY_DELETED"},
+	{uint32(SERVICE_NOTIFY_DELETE_PENDING), "SERVICE_NOTIFY_DELETE_PENDING"},
 }
 
 func (n ServiceNotification) String() string {
-	if n == 0 {
-		return "0x0"
-	}
-	b := getBuffer()
-	defer putBuffer(b)
-	for k, v := range serviceNotificationMap {
-		if n&k != 0 {
-			if b.Len() != 0 {
-				b.WriteByte('|')
-			}
-			b.WriteString(v)
-		}
+	return formatFlags(serviceNotificationNames, uint32(n))
+}
+
+// notificationForState returns the status notification that is
+// triggered when a service enters state.
+func notificationForState(state ServiceState) ServiceNotification {
+	if state < SERVICE_STOPPED || state > SERVICE_PAUSED {
+		return 0
 	}
-	return b.String()
+	return ServiceNotification(1 << (state - 1))
 }
 
 const SERVICE_NOTIFY_STATUS_CHANGE uint32 = 2
@@ -344,10 +379,6 @@ type SERVICE_NOTIFY struct {
 	ServiceNames *uint16
 }
 
-func (s *SERVICE_NOTIFY) Free() {
-	procLocalFree.Call(uintptr(unsafe.Pointer(s.ServiceNames)))
-}
-
 func (s SERVICE_NOTIFY) String() string {
 	const format = "{Version: %X, NotifyCallback: %X, Context: %X, " +
 		"NotificationStatus: %X, ServiceStatus: {%s}, " +
@@ -355,14 +386,54 @@ func (s SERVICE_NOTIFY) String() string {
 
 	return fmt.Sprintf(format, s.Version, s.NotifyCallback, s.Context,
 		s.NotificationStatus, s.ServiceStatus, s.NotificationTriggered,
-		UTF16ToString(s.ServiceNames))
+		UTF16PtrToString(s.ServiceNames, localLen(s.ServiceNames)))
 }
 
 type ServiceNotify struct {
 	NotificationStatus    errno.Errno
 	ServiceStatus         SERVICE_STATUS_PROCESS
 	NotificationTriggered ServiceNotification
-	ServiceNames          []string
+	ServiceNames          []string // Without the '/' prefix of created services
+
+	changes []Change // Set by setServiceNames
+}
+
+// setServiceNames sets the ServiceNames of an SCM notification from the
+// MULTI_SZ names delivered by the SCM, which prefixes the names of created
+// services with a '/'.
+func (s *ServiceNotify) setServiceNames(names []string) {
+	s.ServiceNames = make([]string, len(names))
+	s.changes = make([]Change, len(names))
+	for i, name := range names {
+		typ := ServiceDeleted
+		if strings.HasPrefix(name, "/") {
+			typ, name = ServiceCreated, name[1:]
+		}
+		s.ServiceNames[i] = name
+		s.changes[i] = Change{Type: typ, ServiceName: name}
+	}
+}
+
+// Changes returns a ServiceCreated or ServiceDeleted Change for each of the
+// ServiceNames of an SCM notification, one notification may report both.
+// If the notification was not decoded from the names delivered by the SCM
+// every service is of the kind in NotificationTriggered, created if it has
+// both.
+func (s *ServiceNotify) Changes() []Change {
+	if s.changes != nil {
+		return s.changes
+	}
+	typ := ServiceDeleted
+	if s.NotificationTriggered&SERVICE_NOTIFY_CREATED != 0 {
+		typ = ServiceCreated
+	} else if s.NotificationTriggered&SERVICE_NOTIFY_DELETED == 0 {
+		return nil
+	}
+	changes := make([]Change, len(s.ServiceNames))
+	for i, name := range s.ServiceNames {
+		changes[i] = Change{Type: typ, ServiceName: name}
+	}
+	return changes
 }
 
 func (s ServiceNotify) String() string {
@@ -373,29 +444,6 @@ func (s ServiceNotify) String() string {
 		s.NotificationTriggered, strings.Join(s.ServiceNames, ", "))
 }
 
-func newServiceNotify(n *SERVICE_NOTIFY) *ServiceNotify {
-	if n == nil {
-		return nil
-	}
-	s := &ServiceNotify{
-		NotificationStatus:    errno.Errno(n.NotificationStatus),
-		ServiceStatus:         n.ServiceStatus,
-		NotificationTriggered: n.NotificationTriggered,
-		ServiceNames:          toStringSlice(n.ServiceNames),
-	}
-	if n.ServiceNames != nil {
-		procLocalFree.Call(uintptr(unsafe.Pointer(n.ServiceNames)))
-	}
-	// The names of the created services have a '/' prefix to
-	// distinguish them from the names of the deleted services.
-	if s.NotificationTriggered == SERVICE_NOTIFY_CREATED {
-		for i := 0; i < len(s.ServiceNames); i++ {
-			s.ServiceNames[i] = strings.TrimPrefix(s.ServiceNames[i], "/")
-		}
-	}
-	return s
-}
-
 var bufferPool sync.Pool
 
 func getBuffer() *bytes.Buffer {
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package win
+
+// localLen returns zero, the Service Control Manager only allocates strings
+// on Windows.
+func localLen(p *uint16) int { return 0 }
@@ -0,0 +1,107 @@
+package win
+
+import (
+	"unicode/utf16"
+	"unsafe"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceNotify", func() {
+	It("splits the created and deleted services of SCM notifications", func() {
+		n := &ServiceNotify{NotificationTriggered: SERVICE_NOTIFY_CREATED | SERVICE_NOTIFY_DELETED}
+		n.setServiceNames([]string{"/svc-1", "svc-2", "/svc-3", "/"})
+		Expect(n.ServiceNames).To(Equal([]string{"svc-1", "svc-2", "svc-3", ""}))
+		Expect(n.Changes()).To(Equal([]Change{
+			{Type: ServiceCreated, ServiceName: "svc-1"},
+			{Type: ServiceDeleted, ServiceName: "svc-2"},
+			{Type: ServiceCreated, ServiceName: "svc-3"},
+			{Type: ServiceCreated, ServiceName: ""},
+		}))
+	})
+
+	It("trusts the prefix over the notification triggered", func() {
+		n := &ServiceNotify{NotificationTriggered: SERVICE_NOTIFY_CREATED}
+		n.setServiceNames([]string{"svc-1"})
+		Expect(n.Changes()).To(Equal([]Change{{Type: ServiceDeleted, ServiceName: "svc-1"}}))
+	})
+
+	It("uses the notification triggered for names without prefixes", func() {
+		n := &ServiceNotify{
+			NotificationTriggered: SERVICE_NOTIFY_CREATED,
+			ServiceNames:          []string{"svc-1", "svc-2"},
+		}
+		Expect(n.Changes()).To(Equal([]Change{
+			{Type: ServiceCreated, ServiceName: "svc-1"},
+			{Type: ServiceCreated, ServiceName: "svc-2"},
+		}))
+
+		n.NotificationTriggered = SERVICE_NOTIFY_DELETED
+		Expect(n.Changes()).To(Equal([]Change{
+			{Type: ServiceDeleted, ServiceName: "svc-1"},
+			{Type: ServiceDeleted, ServiceName: "svc-2"},
+		}))
+
+		n.NotificationTriggered = SERVICE_NOTIFY_RUNNING
+		Expect(n.Changes()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("QueryServiceConfig", func() {
+	// configBuffer is a QUERY_SERVICE_CONFIG followed by its strings, as
+	// returned by QueryServiceConfig.
+	type configBuffer struct {
+		QUERY_SERVICE_CONFIG
+		strings [64]uint16
+	}
+
+	var (
+		buf  *configBuffer
+		next int
+	)
+
+	str := func(s string) *uint16 {
+		p := &buf.strings[next]
+		next += copy(buf.strings[next:], utf16.Encode([]rune(s)))
+		return p
+	}
+
+	BeforeEach(func() {
+		buf, next = new(configBuffer), 0
+	})
+
+	It("includes the dependencies", func() {
+		buf.StartType = SERVICE_AUTO_START
+		buf.Dependencies = str("Tcpip\x00+PNP_TDI\x00\x00")
+		buf.DisplayName = str("NetBIOS over Tcpip\x00")
+		conf, err := NewQueryServiceConfig(&buf.QUERY_SERVICE_CONFIG, int(unsafe.Sizeof(*buf)))
+		Expect(err).To(BeNil())
+		Expect(conf.Dependencies).To(Equal([]string{"Tcpip", "+PNP_TDI"}))
+		Expect(conf.DisplayName).To(Equal("NetBIOS over Tcpip"))
+
+		conf, err = NewQueryServiceConfig(new(QUERY_SERVICE_CONFIG), int(unsafe.Sizeof(QUERY_SERVICE_CONFIG{})))
+		Expect(err).To(BeNil())
+		Expect(conf.Dependencies).To(BeNil())
+	})
+
+	It("does not read past the end of the buffer", func() {
+		buf.DisplayName = str("NetBIOS over Tcpip")
+		size := int(unsafe.Sizeof(*buf))
+		conf, err := NewQueryServiceConfig(&buf.QUERY_SERVICE_CONFIG, size-2*(len(buf.strings)-10))
+		Expect(err).To(BeNil())
+		Expect(conf.DisplayName).To(Equal("NetBIOS ov"))
+
+		next = len(buf.strings) - 6
+		buf.Dependencies = str("Tcpip\x00") // Ends with the buffer
+		_, err = NewQueryServiceConfig(&buf.QUERY_SERVICE_CONFIG, size)
+		Expect(err).To(MatchError(ContainSubstring("unterminated MULTI_SZ string")))
+
+		buf.Dependencies = &utf16z("Tcpip\x00")[0]
+		_, err = NewQueryServiceConfig(&buf.QUERY_SERVICE_CONFIG, size)
+		Expect(err).To(MatchError(ContainSubstring("outside of buffer")))
+
+		_, err = NewQueryServiceConfig(&buf.QUERY_SERVICE_CONFIG, 8)
+		Expect(err).To(MatchError(ContainSubstring("does not fit in 8 bytes")))
+	})
+})
@@ -0,0 +1,40 @@
+package win
+
+import (
+	"unsafe"
+
+	"monitor/errno"
+)
+
+func (s *SERVICE_NOTIFY) Free() {
+	procLocalFree.Call(uintptr(unsafe.Pointer(s.ServiceNames)))
+}
+
+// localLen returns the number of UTF-16 code units in the LocalAlloc
+// allocation at p.
+func localLen(p *uint16) int {
+	if p == nil {
+		return 0
+	}
+	size, _, _ := procLocalSize.Call(uintptr(unsafe.Pointer(p)))
+	return int(size / 2)
+}
+
+func newServiceNotify(n *SERVICE_NOTIFY) *ServiceNotify {
+	if n == nil {
+		return nil
+	}
+	s := &ServiceNotify{
+		NotificationStatus:    errno.Errno(n.NotificationStatus),
+		ServiceStatus:         n.ServiceStatus,
+		NotificationTriggered: n.NotificationTriggered,
+	}
+	if n.ServiceNames != nil {
+		// The names are bounded by the size of their allocation, an
+		// unterminated trailing name is dropped.
+		names, _ := MultiSZPtrToStrings(n.ServiceNames, localLen(n.ServiceNames))
+		procLocalFree.Call(uintptr(unsafe.Pointer(n.ServiceNames)))
+		s.setServiceNames(names)
+	}
+	return s
+}
@@ -1,53 +1,103 @@
 package win
 
 import (
-	"syscall"
+	"errors"
+	"strings"
 	"unicode/utf16"
 	"unsafe"
 )
 
-func UTF16ToStringPtr(p *uint16) *string {
+// UTF16ToStringPtr is like UTF16ToString, but returns nil if p is nil.
+func UTF16ToStringPtr(p *uint16, max int) *string {
 	if p == nil {
 		return nil
 	}
-	s := UTF16ToString(p)
+	s := UTF16ToString(p, max)
 	return &s
 }
 
-func UTF16ToString(p *uint16) string {
-	if p == nil || *p == 0 {
+// UTF16ToString returns the UTF-16 string at p, which ends at a NUL or
+// after max code units, see UTF16PtrToString.
+func UTF16ToString(p *uint16, max int) string {
+	return UTF16PtrToString(p, max)
+}
+
+// UTF16PtrToString returns the UTF-16 string at p, which ends at a NUL or
+// after max code units, whichever comes first.  max is bounded by the
+// allocation p points into.
+func UTF16PtrToString(p *uint16, max int) string {
+	if p == nil || max <= 0 {
 		return ""
 	}
-	return syscall.UTF16ToString((*[4096]uint16)(unsafe.Pointer(p))[:])
+	return DecodeUTF16(unsafe.Slice(p, max))
 }
 
-type sliceHeader struct {
-	Data unsafe.Pointer
-	Len  int
-	Cap  int
+// MultiSZPtrToStrings is like DecodeMultiSZ for the MULTI_SZ string at p,
+// which holds at most max code units.
+func MultiSZPtrToStrings(p *uint16, max int) ([]string, error) {
+	if p == nil || max <= 0 {
+		return nil, nil
+	}
+	return DecodeMultiSZ(unsafe.Slice(p, max))
 }
 
-func toString(p *uint16) string {
-	if p == nil {
-		return ""
+// DecodeUTF16 returns the UTF-8 encoding of the UTF-16 string s, which ends
+// at the first NUL or at the end of s.
+func DecodeUTF16(s []uint16) string {
+	for i, v := range s {
+		if v == 0 {
+			s = s[:i]
+			break
+		}
 	}
-	return syscall.UTF16ToString((*[4096]uint16)(unsafe.Pointer(p))[:])
+	return string(utf16.Decode(s))
 }
 
-func toStringSlice(ps *uint16) []string {
-	if ps == nil {
-		return nil
+// DecodeMultiSZ decodes a MULTI_SZ string, a sequence of NUL terminated
+// strings ended by an empty string, which must lie within s.  If the end of
+// the sequence is missing, the strings decoded so far are returned with a
+// DecodeError, an unterminated string is dropped.  An empty s is an empty
+// sequence.
+func DecodeMultiSZ(s []uint16) ([]string, error) {
+	var list []string
+	from := 0
+	for i, v := range s {
+		if v != 0 {
+			continue
+		}
+		if i == from {
+			return list, nil
+		}
+		list = append(list, string(utf16.Decode(s[from:i])))
+		from = i + 1
+	}
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return list, &DecodeError{Offset: 2 * from, Msg: "unterminated MULTI_SZ string"}
+}
+
+var (
+	errMultiSZEmpty = errors.New("win: MULTI_SZ string may not contain an empty string")
+	errMultiSZNUL   = errors.New("win: MULTI_SZ string may not contain NUL")
+)
+
+// EncodeMultiSZ encodes list as a MULTI_SZ string, including the empty
+// string that ends it.  The strings may not be empty or contain NUL.
+func EncodeMultiSZ(list []string) ([]uint16, error) {
+	if len(list) == 0 {
+		return []uint16{0, 0}, nil
 	}
-	r := make([]string, 0)
-	for from, i, p := 0, 0, (*[1 << 24]uint16)(unsafe.Pointer(ps)); true; i++ {
-		if p[i] == 0 {
-			// empty string marks the end
-			if i <= from {
-				break
-			}
-			r = append(r, string(utf16.Decode(p[from:i])))
-			from = i + 1
+	var s []uint16
+	for _, v := range list {
+		if v == "" {
+			return nil, errMultiSZEmpty
+		}
+		if strings.IndexByte(v, 0) != -1 {
+			return nil, errMultiSZNUL
 		}
+		s = append(s, utf16.Encode([]rune(v))...)
+		s = append(s, 0)
 	}
-	return r
+	return append(s, 0), nil
 }
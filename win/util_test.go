@@ -0,0 +1,179 @@
+package win
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func utf16z(s string) []uint16 {
+	return utf16.Encode([]rune(s + "\x00"))
+}
+
+// uint16s decodes b as little-endian UTF-16 code units, an odd trailing
+// byte is dropped.
+func uint16s(b []byte) []uint16 {
+	s := make([]uint16, len(b)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return s
+}
+
+var _ = Describe("UTF-16 helpers", func() {
+	long := strings.Repeat("Go is expressive, concise, clean, and efficient. ", 200)
+
+	It("does not truncate long strings", func() {
+		Expect(len(long)).To(BeNumerically(">", 4096))
+		s := utf16z(long)
+		Expect(UTF16ToString(&s[0], len(s))).To(Equal(long))
+		Expect(UTF16ToString(&s[0], 2)).To(Equal(long[:2]))
+		Expect(UTF16ToString(nil, 10)).To(BeEmpty())
+		Expect(*UTF16ToStringPtr(&s[0], len(s))).To(Equal(long))
+		Expect(UTF16ToStringPtr(nil, 10)).To(BeNil())
+	})
+
+	It("decodes strings up to a NUL or the bound", func() {
+		Expect(DecodeUTF16(utf16z("hello\x00, world"))).To(Equal("hello"))
+		Expect(DecodeUTF16(utf16.Encode([]rune("hello")))).To(Equal("hello"))
+		Expect(DecodeUTF16(nil)).To(BeEmpty())
+
+		s := utf16z(long)
+		Expect(UTF16PtrToString(&s[0], len(s))).To(Equal(long))
+		Expect(UTF16PtrToString(&s[0], 5)).To(Equal(long[:5]))
+		Expect(UTF16PtrToString(&s[0], 0)).To(BeEmpty())
+		Expect(UTF16PtrToString(nil, 10)).To(BeEmpty())
+	})
+
+	It("decodes MULTI_SZ strings", func() {
+		list, err := DecodeMultiSZ(utf16z("a\x00bc\x00ümlaut 🌱\x00"))
+		Expect(err).To(BeNil())
+		Expect(list).To(Equal([]string{"a", "bc", "ümlaut 🌱"}))
+
+		// Anything after the terminating empty string is ignored.
+		list, err = DecodeMultiSZ(utf16z("a\x00\x00b\x00"))
+		Expect(err).To(BeNil())
+		Expect(list).To(Equal([]string{"a"}))
+
+		for _, s := range [][]uint16{nil, {0}, {0, 0}} {
+			list, err = DecodeMultiSZ(s)
+			Expect(err).To(BeNil())
+			Expect(list).To(BeEmpty())
+		}
+
+		s := utf16z("a\x00bc\x00")
+		list, err = MultiSZPtrToStrings(&s[0], len(s))
+		Expect(err).To(BeNil())
+		Expect(list).To(Equal([]string{"a", "bc"}))
+	})
+
+	It("returns the strings before an unterminated MULTI_SZ end", func() {
+		s := utf16z("a\x00bc\x00def")
+		list, err := DecodeMultiSZ(s[:len(s)-1])
+		Expect(err).To(MatchError("win: decode: offset 10: unterminated MULTI_SZ string"))
+		Expect(list).To(Equal([]string{"a", "bc"}))
+
+		list, err = MultiSZPtrToStrings(&s[0], 4)
+		Expect(err).To(HaveOccurred())
+		Expect(list).To(Equal([]string{"a"}))
+	})
+
+	It("encodes MULTI_SZ strings", func() {
+		s, err := EncodeMultiSZ([]string{"a", "bc", "🌱"})
+		Expect(err).To(BeNil())
+		Expect(s).To(Equal(utf16z("a\x00bc\x00🌱\x00")))
+
+		s, err = EncodeMultiSZ(nil)
+		Expect(err).To(BeNil())
+		Expect(s).To(Equal([]uint16{0, 0}))
+
+		_, err = EncodeMultiSZ([]string{"a", ""})
+		Expect(err).To(HaveOccurred())
+		_, err = EncodeMultiSZ([]string{"a\x00b"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func FuzzDecodeMultiSZ(f *testing.F) {
+	f.Add([]byte{'a', 0, 0, 0, 'b', 0, 0, 0, 0, 0})
+	f.Add([]byte{0x3c, 0xd8, 0x31, 0xdf, 0, 0, 0, 0})
+	f.Add([]byte{'a', 0, 'b'})
+	f.Add([]byte{0x3c, 0xd8, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s := uint16s(b)
+		list, err := DecodeMultiSZ(s)
+		for _, v := range list {
+			if v == "" || strings.IndexByte(v, 0) != -1 {
+				t.Fatalf("invalid string %q in %q", v, list)
+			}
+		}
+		enc, err2 := EncodeMultiSZ(list)
+		if err2 != nil {
+			t.Fatalf("encoding %q: %s", list, err2)
+		}
+		again, err2 := DecodeMultiSZ(enc)
+		if err2 != nil || len(again) != len(list) {
+			t.Fatalf("round trip of %q: %q, %v", list, again, err2)
+		}
+		for i := range list {
+			if again[i] != list[i] {
+				t.Fatalf("round trip of %q: %q", list, again)
+			}
+		}
+		if err == nil && len(s) > 0 && s[0] != 0 && len(list) == 0 {
+			t.Fatalf("no strings decoded from %v", s)
+		}
+	})
+}
+
+func FuzzEncodeMultiSZ(f *testing.F) {
+	f.Add("a|bc|ümlaut 🌱")
+	f.Add("")
+	f.Add("a||b")
+	f.Add("a\x00b")
+	f.Fuzz(func(t *testing.T, joined string) {
+		var list []string
+		if joined != "" {
+			list = strings.Split(joined, "|")
+		}
+		s, err := EncodeMultiSZ(list)
+		if err != nil {
+			return
+		}
+		if len(s) < 2 || s[len(s)-1] != 0 || s[len(s)-2] != 0 {
+			t.Fatalf("%q is not terminated: %v", list, s)
+		}
+		if !utf8.ValidString(joined) {
+			return // Invalid UTF-8 is replaced with U+FFFD
+		}
+		decoded, err := DecodeMultiSZ(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(decoded, "|") != joined {
+			t.Fatalf("round trip of %q: %q", list, decoded)
+		}
+	})
+}
+
+func FuzzDecodeUTF16(f *testing.F) {
+	f.Add([]byte{'h', 0, 'i', 0, 0, 0, 'x', 0})
+	f.Add([]byte{0x3c, 0xd8})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		s := uint16s(b)
+		str := DecodeUTF16(s)
+		if strings.IndexByte(str, 0) != -1 {
+			t.Fatalf("%q contains NUL", str)
+		}
+		if len(s) > 0 {
+			if got := UTF16PtrToString(&s[0], len(s)); got != str {
+				t.Fatalf("UTF16PtrToString: %q, DecodeUTF16: %q", got, str)
+			}
+		}
+	})
+}
@@ -1,22 +1,6 @@
 package win
 
-import "syscall"
-
-var (
-	kernel32DLL = syscall.MustLoadDLL("kernel32")
-	advapi32DLL = syscall.MustLoadDLL("Advapi32")
-	psapiDLL    = syscall.MustLoadDLL("psapi")
-
-	procGetProcessId              = kernel32DLL.MustFindProc("GetProcessId")
-	procSleepEx                   = kernel32DLL.MustFindProc("SleepEx")
-	procOpenProcess               = kernel32DLL.MustFindProc("OpenProcess")
-	procLocalFree                 = kernel32DLL.MustFindProc("LocalFree")
-	procNotifyServiceStatusChange = advapi32DLL.MustFindProc("NotifyServiceStatusChange")
-	procEnumServicesStatusExW     = advapi32DLL.MustFindProc("EnumServicesStatusExW")
-	procQueryServiceConfigW       = advapi32DLL.MustFindProc("QueryServiceConfigW")
-	procQueryServiceConfig2W      = advapi32DLL.MustFindProc("QueryServiceConfig2W")
-	procGetProcessMemoryInfo      = psapiDLL.MustFindProc("GetProcessMemoryInfo")
-)
+import "time"
 
 /*
 const (
@@ -51,4 +35,9 @@ type Notification struct {
 	Name   string // Only used for service notifications.
 	Notify *ServiceNotify
 	Action MonitorAction
+	Err    error // Notification registration or reload error, if any.
 }
+
+// notifyTimeout is how long the listeners wait for a notification before
+// checking whether they have been closed.
+const notifyTimeout = time.Second
@@ -72,7 +72,6 @@ func buildAndInstall(svcName string, conf mgr.Config) (*mgr.Mgr, *mgr.Service, e
 	if err != nil {
 		return nil, nil, err
 	}
-	fmt.Println("installing", svcName)
 	if err := install(m, svcName, exePath, conf); err != nil {
 		m.Disconnect()
 		return nil, nil, err
@@ -125,6 +124,17 @@ func remove(s *mgr.Service) error {
 	return nil
 }
 
+// handleOf returns the Windows handle of a backend or service handle.
+func handleOf(v interface{}) windows.Handle {
+	switch h := v.(type) {
+	case *windowsBackend:
+		return h.mgr.Handle
+	case *windowsService:
+		return h.svc.Handle
+	}
+	return windows.InvalidHandle
+}
+
 func isValidHandle(handle windows.Handle) bool {
 	var bytesNeeded uint32
 	_, _, e1 := syscall.Syscall6(
@@ -0,0 +1,21 @@
+package win
+
+import "syscall"
+
+var (
+	kernel32DLL = syscall.MustLoadDLL("kernel32")
+	advapi32DLL = syscall.MustLoadDLL("Advapi32")
+	psapiDLL    = syscall.MustLoadDLL("psapi")
+
+	procGetProcessId              = kernel32DLL.MustFindProc("GetProcessId")
+	procSleepEx                   = kernel32DLL.MustFindProc("SleepEx")
+	procQueueUserAPC              = kernel32DLL.MustFindProc("QueueUserAPC")
+	procOpenProcess               = kernel32DLL.MustFindProc("OpenProcess")
+	procLocalFree                 = kernel32DLL.MustFindProc("LocalFree")
+	procLocalSize                 = kernel32DLL.MustFindProc("LocalSize")
+	procNotifyServiceStatusChange = advapi32DLL.MustFindProc("NotifyServiceStatusChange")
+	procEnumServicesStatusExW     = advapi32DLL.MustFindProc("EnumServicesStatusExW")
+	procQueryServiceConfigW       = advapi32DLL.MustFindProc("QueryServiceConfigW")
+	procQueryServiceConfig2W      = advapi32DLL.MustFindProc("QueryServiceConfig2W")
+	procGetProcessMemoryInfo      = psapiDLL.MustFindProc("GetProcessMemoryInfo")
+)